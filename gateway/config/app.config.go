@@ -0,0 +1,615 @@
+package config
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/url"
+	"sort"
+	"strings"
+	"time"
+
+	"golang.org/x/crypto/bcrypt"
+	"gopkg.in/yaml.v3"
+)
+
+// Config holds every setting the gateway needs at boot. Each section is
+// handed to the package that uses it.
+type Config struct {
+	Server    Server    `json:"server" yaml:"server"`
+	Database  Database  `json:"database" yaml:"database"`
+	Security  Security  `json:"security" yaml:"security"`
+	Routing   Routing   `json:"routing" yaml:"routing"`
+	Bootstrap Bootstrap `json:"bootstrap" yaml:"bootstrap"`
+	RateLimit RateLimit `json:"rateLimit" yaml:"rateLimit"`
+	Upstream  Upstream  `json:"upstream" yaml:"upstream"`
+	Discovery Discovery `json:"discovery" yaml:"discovery"`
+	Readiness Readiness `json:"readiness" yaml:"readiness"`
+	Metrics   Metrics   `json:"metrics" yaml:"metrics"`
+	Tracing   Tracing   `json:"tracing" yaml:"tracing"`
+	Logging   Logging   `json:"logging" yaml:"logging"`
+	AccessLog AccessLog `json:"accessLog" yaml:"accessLog"`
+}
+
+// Server stops on SIGTERM or SIGINT by failing readiness for DrainDelay, so
+// that load balancers stop sending traffic, then waits up to ShutdownTimeout
+// for requests in flight to finish.
+type Server struct {
+	Addr            string   `json:"addr" yaml:"addr"`
+	DrainDelay      Duration `json:"drainDelay" yaml:"drainDelay"`
+	ShutdownTimeout Duration `json:"shutdownTimeout" yaml:"shutdownTimeout"`
+}
+
+// Database connects to PostgreSQL when DSN is a postgres:// URL or a
+// key=value connection string, and to a SQLite file otherwise.
+type Database struct {
+	DSN             string   `json:"dsn" yaml:"dsn"`
+	MaxOpenConns    int      `json:"maxOpenConns" yaml:"maxOpenConns"`
+	MaxIdleConns    int      `json:"maxIdleConns" yaml:"maxIdleConns"`
+	ConnMaxLifetime Duration `json:"connMaxLifetime" yaml:"connMaxLifetime"`
+	ConnMaxIdleTime Duration `json:"connMaxIdleTime" yaml:"connMaxIdleTime"`
+	// AutoMigrate applies pending migrations at boot. Turn it off to run
+	// them explicitly with the migrate subcommand.
+	AutoMigrate bool `json:"autoMigrate" yaml:"autoMigrate"`
+}
+
+// Security signs access tokens with the private key ActiveKeyID of Keys. The
+// other keys only verify, which allows rotating keys without logging users
+// out. Without keys, tokens are signed with HS512 and JWTSecret.
+type Security struct {
+	JWTSecret       string       `json:"jwtSecret" yaml:"jwtSecret"`
+	Keys            []SigningKey `json:"keys" yaml:"keys"`
+	ActiveKeyID     string       `json:"activeKeyId" yaml:"activeKeyId"`
+	AccessTokenTTL  Duration     `json:"accessTokenTtl" yaml:"accessTokenTtl"`
+	RefreshTokenTTL Duration     `json:"refreshTokenTtl" yaml:"refreshTokenTtl"`
+	BcryptCost      int          `json:"bcryptCost" yaml:"bcryptCost"`
+	// RevocationSyncInterval is how soon token revocations made by other
+	// instances take effect here.
+	RevocationSyncInterval Duration `json:"revocationSyncInterval" yaml:"revocationSyncInterval"`
+	// PermissionCacheTTL is how long a user's roles and permissions are kept
+	// in memory. Changes made on other instances take up to this long to
+	// apply here; 0 disables the cache.
+	PermissionCacheTTL Duration `json:"permissionCacheTtl" yaml:"permissionCacheTtl"`
+	Lockout            Lockout  `json:"lockout" yaml:"lockout"`
+}
+
+// Lockout slows down password guessing. After FreeAttempts failed passwords
+// in a row, a username, whether or not a user has it, waits Delay before the
+// next attempt, twice as long with every further failure, and is locked for
+// Duration after Threshold failures; a Duration of 0 disables the lockout. A
+// client IP with IPThreshold failures within IPWindow is refused until the
+// window ends; 0 disables that check.
+type Lockout struct {
+	FreeAttempts int      `json:"freeAttempts" yaml:"freeAttempts"`
+	Delay        Duration `json:"delay" yaml:"delay"`
+	Threshold    int      `json:"threshold" yaml:"threshold"`
+	Duration     Duration `json:"duration" yaml:"duration"`
+	IPThreshold  int      `json:"ipThreshold" yaml:"ipThreshold"`
+	IPWindow     Duration `json:"ipWindow" yaml:"ipWindow"`
+}
+
+// SigningKey is a PEM encoded RSA, ECDSA or Ed25519 private key published
+// with ID as kid.
+type SigningKey struct {
+	ID   string `json:"id" yaml:"id"`
+	File string `json:"file" yaml:"file"`
+}
+
+type Routing struct {
+	File          string   `json:"file" yaml:"file"`
+	WatchInterval Duration `json:"watchInterval" yaml:"watchInterval"`
+}
+
+// Bootstrap is the administrator created when the database has no users.
+type Bootstrap struct {
+	AdminUsername string `json:"adminUsername" yaml:"adminUsername"`
+	AdminPassword string `json:"adminPassword" yaml:"adminPassword"`
+	AdminEmail    string `json:"adminEmail" yaml:"adminEmail"`
+}
+
+// RateLimit sets the quotas applied outside the route table. Algorithm is
+// token-bucket or sliding-window.
+type RateLimit struct {
+	Algorithm string `json:"algorithm" yaml:"algorithm"`
+	// Global applies to every request under /api, per client IP.
+	Global Quota `json:"global" yaml:"global"`
+	// Login applies to login attempts, per client IP.
+	Login Quota `json:"login" yaml:"login"`
+}
+
+// Quota allows Requests per Per. A zero quota is no limit.
+type Quota struct {
+	Requests int      `json:"requests" yaml:"requests"`
+	Per      Duration `json:"per" yaml:"per"`
+}
+
+func (q Quota) valid() bool {
+	return q.Requests >= 0 && q.Per >= 0 && (q.Requests > 0) == (q.Per > 0)
+}
+
+// Upstream sets how proxied calls to upstream services fail. Retry applies
+// to routes that do not set their own, HealthCheck and Ejection to upstream
+// pools that do not set their own.
+type Upstream struct {
+	Breaker     Breaker     `json:"breaker" yaml:"breaker"`
+	Retry       Retry       `json:"retry" yaml:"retry"`
+	HealthCheck HealthCheck `json:"healthCheck" yaml:"healthCheck"`
+	Ejection    Ejection    `json:"ejection" yaml:"ejection"`
+}
+
+// Breaker opens the circuit of an upstream after FailureThreshold failed
+// calls in a row and fails fast for OpenTimeout. Then HalfOpenRequests trial
+// calls decide whether it closes again. A zero FailureThreshold disables it.
+type Breaker struct {
+	FailureThreshold int      `json:"failureThreshold" yaml:"failureThreshold"`
+	OpenTimeout      Duration `json:"openTimeout" yaml:"openTimeout"`
+	HalfOpenRequests int      `json:"halfOpenRequests" yaml:"halfOpenRequests"`
+}
+
+// Retry repeats idempotent requests up to Attempts more times when the
+// upstream cannot be reached or answers 502, 503 or 504. Waits start at
+// Backoff, double up to MaxBackoff and are jittered. PerTryTimeout bounds
+// the wait for the response headers of each attempt; 0 leaves it to the
+// route timeout.
+type Retry struct {
+	Attempts      int      `json:"attempts" yaml:"attempts"`
+	Backoff       Duration `json:"backoff" yaml:"backoff"`
+	MaxBackoff    Duration `json:"maxBackoff" yaml:"maxBackoff"`
+	PerTryTimeout Duration `json:"perTryTimeout" yaml:"perTryTimeout"`
+}
+
+// Problems lists what is wrong with r, for the config and route table
+// validations alike.
+func (r Retry) Problems() []string {
+	var problems []string
+	if r.Attempts < 0 {
+		problems = append(problems, "attempts cannot be negative")
+	}
+	if r.Backoff < 0 || r.MaxBackoff < 0 || r.PerTryTimeout < 0 {
+		problems = append(problems, "backoff, maxBackoff and perTryTimeout cannot be negative")
+	}
+	if r.MaxBackoff < r.Backoff {
+		problems = append(problems, "maxBackoff cannot be shorter than backoff")
+	}
+	return problems
+}
+
+// Discovery finds the instances of the services named by routes and pools.
+// Provider is static, which lists the URLs of each service in Static, file,
+// which reads them from File, or dns, which looks up SRV records. Instances
+// are looked up again every RefreshInterval.
+type Discovery struct {
+	Provider        string              `json:"provider" yaml:"provider"`
+	Static          map[string][]string `json:"static" yaml:"static"`
+	File            string              `json:"file" yaml:"file"`
+	DNS             DNSDiscovery        `json:"dns" yaml:"dns"`
+	RefreshInterval Duration            `json:"refreshInterval" yaml:"refreshInterval"`
+}
+
+// DNSDiscovery looks up _<service>._tcp.<Domain> and calls the instances
+// with Scheme, http by default.
+type DNSDiscovery struct {
+	Domain string `json:"domain" yaml:"domain"`
+	Scheme string `json:"scheme" yaml:"scheme"`
+}
+
+// Metrics serves Prometheus metrics at Path, outside /api. An empty Path
+// disables them.
+type Metrics struct {
+	Path string `json:"path" yaml:"path"`
+}
+
+// Tracing records a span for every request, database query and upstream
+// call, continuing the trace of callers that send a W3C traceparent header.
+// Exporter is none, stdout or otlp, which sends spans over HTTP to Endpoint.
+// SampleRatio of the traces started here are kept; traces continued from a
+// caller follow its sampling decision.
+type Tracing struct {
+	Exporter    string  `json:"exporter" yaml:"exporter"`
+	Endpoint    string  `json:"endpoint" yaml:"endpoint"`
+	ServiceName string  `json:"serviceName" yaml:"serviceName"`
+	SampleRatio float64 `json:"sampleRatio" yaml:"sampleRatio"`
+}
+
+// Logging writes one line per entry to stderr, as JSON or, with the console
+// format, for humans. Level applies to every package not listed in Levels,
+// which maps package names such as proxy or security to their own level.
+// Levels are debug, info, warn and error.
+type Logging struct {
+	Level  string            `json:"level" yaml:"level"`
+	Format string            `json:"format" yaml:"format"`
+	Levels map[string]string `json:"levels" yaml:"levels"`
+}
+
+// AccessLog writes one line per request to Output, stdout or a file, in the
+// common or combined log format or as JSON; the off format disables it. A
+// file is rotated once it reaches MaxSizeMB, keeping MaxBackups old files
+// for at most MaxAgeDays. SampleRatio of the requests are logged, server
+// errors always. The JSON format only logs the request headers listed in
+// Headers. The values of RedactHeaders, and of the JSON body fields and
+// query parameters named in RedactFields, are never written. Request bodies
+// are only logged with Body, in the JSON format.
+type AccessLog struct {
+	Format        string   `json:"format" yaml:"format"`
+	Output        string   `json:"output" yaml:"output"`
+	SampleRatio   float64  `json:"sampleRatio" yaml:"sampleRatio"`
+	Body          bool     `json:"body" yaml:"body"`
+	Headers       []string `json:"headers" yaml:"headers"`
+	RedactHeaders []string `json:"redactHeaders" yaml:"redactHeaders"`
+	RedactFields  []string `json:"redactFields" yaml:"redactFields"`
+	MaxSizeMB     int      `json:"maxSizeMb" yaml:"maxSizeMb"`
+	MaxBackups    int      `json:"maxBackups" yaml:"maxBackups"`
+	MaxAgeDays    int      `json:"maxAgeDays" yaml:"maxAgeDays"`
+	Compress      bool     `json:"compress" yaml:"compress"`
+}
+
+// LogLevels are the levels accepted by Logging.
+var LogLevels = []string{"debug", "info", "warn", "error"}
+
+// Readiness bounds each check of /readyz by Timeout. A failing check makes
+// the gateway not ready, unless it is listed in Degraded: then the gateway
+// only reports itself degraded and keeps taking traffic.
+type Readiness struct {
+	Timeout  Duration `json:"timeout" yaml:"timeout"`
+	Degraded []string `json:"degraded" yaml:"degraded"`
+}
+
+// HealthCheck probes every member of a pool with a GET on Path each
+// Interval. A member answering anything but 2xx or 3xx within Timeout
+// UnhealthyThreshold times in a row leaves the pool until it passes
+// HealthyThreshold probes in a row. An empty Path disables probing.
+type HealthCheck struct {
+	Path               string   `json:"path" yaml:"path"`
+	Interval           Duration `json:"interval" yaml:"interval"`
+	Timeout            Duration `json:"timeout" yaml:"timeout"`
+	HealthyThreshold   int      `json:"healthyThreshold" yaml:"healthyThreshold"`
+	UnhealthyThreshold int      `json:"unhealthyThreshold" yaml:"unhealthyThreshold"`
+}
+
+func (h HealthCheck) Problems() []string {
+	if h.Path == "" {
+		return nil
+	}
+
+	var problems []string
+	if !strings.HasPrefix(h.Path, "/") {
+		problems = append(problems, "path must start with /")
+	}
+	if h.Interval <= 0 || h.Timeout <= 0 {
+		problems = append(problems, "interval and timeout must be positive")
+	}
+	if h.HealthyThreshold <= 0 || h.UnhealthyThreshold <= 0 {
+		problems = append(problems, "healthyThreshold and unhealthyThreshold must be positive")
+	}
+	return problems
+}
+
+// Ejection takes a pool member out for Duration after Consecutive5xx failed
+// calls in a row, whether 5xx answers or unreachable. A zero Consecutive5xx
+// disables it.
+type Ejection struct {
+	Consecutive5xx int      `json:"consecutive5xx" yaml:"consecutive5xx"`
+	Duration       Duration `json:"duration" yaml:"duration"`
+}
+
+func (e Ejection) Problems() []string {
+	if e.Consecutive5xx < 0 || (e.Consecutive5xx > 0 && e.Duration <= 0) {
+		return []string{"duration must be positive when consecutive5xx is set"}
+	}
+	return nil
+}
+
+// Duration accepts Go duration strings ("5s", "1m30s") in config files.
+type Duration time.Duration
+
+// ValidationError lists every problem found in a configuration so that
+// operators can fix them all at once.
+type ValidationError struct {
+	Problems []string
+}
+
+func (e *ValidationError) Error() string {
+	return "Invalid configuration:\n  " + strings.Join(e.Problems, "\n  ")
+}
+
+// Default returns the settings used when nothing else is configured. It has
+// no JWT secret on purpose: one must always be provided.
+func Default() *Config {
+	return &Config{
+		Server: Server{
+			Addr:            ":3000",
+			DrainDelay:      Duration(5 * time.Second),
+			ShutdownTimeout: Duration(30 * time.Second),
+		},
+		Database: Database{DSN: "database.db", MaxIdleConns: 2, AutoMigrate: true},
+		Security: Security{
+			AccessTokenTTL:  Duration(15 * time.Minute),
+			RefreshTokenTTL: Duration(30 * 24 * time.Hour),
+			BcryptCost:      bcrypt.DefaultCost,
+
+			RevocationSyncInterval: Duration(30 * time.Second),
+			PermissionCacheTTL:     Duration(30 * time.Second),
+			Lockout: Lockout{
+				FreeAttempts: 3,
+				Delay:        Duration(time.Second),
+				Threshold:    10,
+				Duration:     Duration(15 * time.Minute),
+				IPThreshold:  100,
+				IPWindow:     Duration(15 * time.Minute),
+			},
+		},
+		Routing: Routing{WatchInterval: Duration(5 * time.Second)},
+		RateLimit: RateLimit{
+			Algorithm: "token-bucket",
+			Login:     Quota{Requests: 10, Per: Duration(time.Minute)},
+		},
+		Upstream: Upstream{
+			Breaker: Breaker{FailureThreshold: 5, OpenTimeout: Duration(30 * time.Second), HalfOpenRequests: 1},
+			Retry:   Retry{Attempts: 2, Backoff: Duration(100 * time.Millisecond), MaxBackoff: Duration(time.Second)},
+			HealthCheck: HealthCheck{
+				Interval:           Duration(10 * time.Second),
+				Timeout:            Duration(2 * time.Second),
+				HealthyThreshold:   2,
+				UnhealthyThreshold: 3,
+			},
+			Ejection: Ejection{Consecutive5xx: 5, Duration: Duration(30 * time.Second)},
+		},
+		Discovery: Discovery{Provider: "static", RefreshInterval: Duration(30 * time.Second)},
+		Readiness: Readiness{Timeout: Duration(2 * time.Second), Degraded: []string{"upstreams"}},
+		Metrics:   Metrics{Path: "/metrics"},
+		Tracing: Tracing{
+			Exporter:    "none",
+			Endpoint:    "http://localhost:4318",
+			ServiceName: "gateway",
+			SampleRatio: 1,
+		},
+		Logging: Logging{Level: "info", Format: "json"},
+		AccessLog: AccessLog{
+			Format:        "off",
+			Output:        "stdout",
+			SampleRatio:   1,
+			Headers:       []string{"Accept", "Content-Type", "Content-Length", "User-Agent", "Referer", "X-Forwarded-For", "X-Request-ID"},
+			RedactHeaders: []string{"Authorization", "Proxy-Authorization", "Cookie", "X-API-Key"},
+			RedactFields:  []string{"password", "repeatPassword", "currentPassword", "accessToken", "refreshToken"},
+			MaxSizeMB:     100,
+			MaxBackups:    5,
+			MaxAgeDays:    30,
+		},
+	}
+}
+
+func (c *Config) Validate() error {
+	var problems []string
+
+	if c.Server.Addr == "" {
+		problems = append(problems, "server.addr is required")
+	}
+	if c.Server.DrainDelay < 0 || c.Server.ShutdownTimeout <= 0 {
+		problems = append(problems, "server.drainDelay cannot be negative and server.shutdownTimeout must be positive")
+	}
+	problems = append(problems, c.databaseProblems()...)
+	if len(c.Security.Keys) == 0 && c.Security.JWTSecret == "" {
+		problems = append(problems, "security.jwtSecret is required when no security.keys are given")
+	}
+	if len(c.Security.Keys) > 0 {
+		ids := map[string]bool{}
+		for i, k := range c.Security.Keys {
+			if k.ID == "" || k.File == "" {
+				problems = append(problems, fmt.Sprintf("security.keys[%d] needs an id and a file", i))
+			}
+			if ids[k.ID] {
+				problems = append(problems, "security.keys has duplicate id "+k.ID)
+			}
+			ids[k.ID] = true
+		}
+		if !ids[c.Security.ActiveKeyID] {
+			problems = append(problems, "security.activeKeyId must be the id of one of security.keys")
+		}
+	}
+	if c.Security.AccessTokenTTL <= 0 {
+		problems = append(problems, "security.accessTokenTtl must be positive")
+	}
+	if c.Security.RefreshTokenTTL <= c.Security.AccessTokenTTL {
+		problems = append(problems, "security.refreshTokenTtl must be longer than security.accessTokenTtl")
+	}
+	if c.Security.RevocationSyncInterval <= 0 {
+		problems = append(problems, "security.revocationSyncInterval must be positive")
+	}
+	if c.Security.PermissionCacheTTL < 0 {
+		problems = append(problems, "security.permissionCacheTtl cannot be negative")
+	}
+	if l := c.Security.Lockout; l.FreeAttempts < 0 || l.Threshold < l.FreeAttempts || l.Delay < 0 || l.Duration < 0 {
+		problems = append(problems, "security.lockout needs 0 <= freeAttempts <= threshold and non-negative delay and duration")
+	}
+	if l := c.Security.Lockout; l.Duration > 0 && l.Threshold <= l.FreeAttempts {
+		problems = append(problems, "security.lockout.threshold must be above freeAttempts when duration is set")
+	}
+	if l := c.Security.Lockout; l.IPThreshold < 0 || (l.IPThreshold > 0 && l.IPWindow <= 0) {
+		problems = append(problems, "security.lockout.ipWindow must be positive when ipThreshold is set")
+	}
+	if c.Security.BcryptCost < bcrypt.MinCost || c.Security.BcryptCost > bcrypt.MaxCost {
+		problems = append(problems, "security.bcryptCost must be between 4 and 31")
+	}
+	if c.Routing.File != "" && c.Routing.WatchInterval < 0 {
+		problems = append(problems, "routing.watchInterval cannot be negative")
+	}
+
+	if c.Bootstrap.AdminUsername != "" && (len(c.Bootstrap.AdminPassword) < 5 || c.Bootstrap.AdminEmail == "") {
+		problems = append(problems, "bootstrap.adminPassword of at least 5 characters and bootstrap.adminEmail are required with bootstrap.adminUsername")
+	}
+
+	if a := c.RateLimit.Algorithm; a != "token-bucket" && a != "sliding-window" {
+		problems = append(problems, "rateLimit.algorithm must be token-bucket or sliding-window")
+	}
+	if !c.RateLimit.Global.valid() {
+		problems = append(problems, "rateLimit.global needs both requests and per, or neither")
+	}
+	if !c.RateLimit.Login.valid() {
+		problems = append(problems, "rateLimit.login needs both requests and per, or neither")
+	}
+
+	if b := c.Upstream.Breaker; b.FailureThreshold < 0 || (b.FailureThreshold > 0 && (b.OpenTimeout <= 0 || b.HalfOpenRequests <= 0)) {
+		problems = append(problems, "upstream.breaker needs positive openTimeout and halfOpenRequests when failureThreshold is set")
+	}
+	for _, problem := range c.Upstream.Retry.Problems() {
+		problems = append(problems, "upstream.retry: "+problem)
+	}
+	for _, problem := range c.Upstream.HealthCheck.Problems() {
+		problems = append(problems, "upstream.healthCheck: "+problem)
+	}
+	for _, problem := range c.Upstream.Ejection.Problems() {
+		problems = append(problems, "upstream.ejection: "+problem)
+	}
+
+	switch c.Discovery.Provider {
+	case "static":
+	case "file":
+		if c.Discovery.File == "" {
+			problems = append(problems, "discovery.file is required with the file provider")
+		}
+	case "dns":
+		if s := c.Discovery.DNS.Scheme; s != "" && s != "http" && s != "https" {
+			problems = append(problems, "discovery.dns.scheme must be http or https")
+		}
+	default:
+		problems = append(problems, "discovery.provider must be static, file or dns")
+	}
+	if c.Discovery.RefreshInterval <= 0 {
+		problems = append(problems, "discovery.refreshInterval must be positive")
+	}
+	if c.Readiness.Timeout <= 0 {
+		problems = append(problems, "readiness.timeout must be positive")
+	}
+	if p := c.Metrics.Path; p != "" && (!strings.HasPrefix(p, "/") || p == "/api" || strings.HasPrefix(p, "/api/")) {
+		problems = append(problems, "metrics.path must start with / and be outside /api")
+	}
+	switch c.Tracing.Exporter {
+	case "none", "stdout":
+	case "otlp":
+		if u, err := url.Parse(c.Tracing.Endpoint); err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			problems = append(problems, "tracing.endpoint must be an absolute http(s) URL with the otlp exporter")
+		}
+	default:
+		problems = append(problems, "tracing.exporter must be none, stdout or otlp")
+	}
+	if c.Tracing.ServiceName == "" {
+		problems = append(problems, "tracing.serviceName is required")
+	}
+	if r := c.Tracing.SampleRatio; r < 0 || r > 1 {
+		problems = append(problems, "tracing.sampleRatio must be between 0 and 1")
+	}
+	problems = append(problems, c.loggingProblems()...)
+	switch c.AccessLog.Format {
+	case "off", "common", "combined", "json":
+	default:
+		problems = append(problems, "accessLog.format must be off, common, combined or json")
+	}
+	if c.AccessLog.Format != "off" && c.AccessLog.Output == "" {
+		problems = append(problems, "accessLog.output must be stdout or a file")
+	}
+	if r := c.AccessLog.SampleRatio; r < 0 || r > 1 {
+		problems = append(problems, "accessLog.sampleRatio must be between 0 and 1")
+	}
+	if c.AccessLog.MaxSizeMB <= 0 || c.AccessLog.MaxBackups < 0 || c.AccessLog.MaxAgeDays < 0 {
+		problems = append(problems, "accessLog.maxSizeMb must be positive, maxBackups and maxAgeDays cannot be negative")
+	}
+
+	if len(problems) > 0 {
+		return &ValidationError{Problems: problems}
+	}
+
+	return nil
+}
+
+// ValidateMigrate checks only the sections the migrate subcommand uses, so
+// that schema changes need none of the settings for serving requests.
+func (c *Config) ValidateMigrate() error {
+	problems := append(c.databaseProblems(), c.loggingProblems()...)
+	if len(problems) > 0 {
+		return &ValidationError{Problems: problems}
+	}
+
+	return nil
+}
+
+func (c *Config) databaseProblems() []string {
+	var problems []string
+	if c.Database.DSN == "" {
+		problems = append(problems, "database.dsn is required")
+	}
+	if c.Database.MaxOpenConns < 0 || c.Database.MaxIdleConns < 0 {
+		problems = append(problems, "database connection limits cannot be negative")
+	}
+	if c.Database.ConnMaxLifetime < 0 || c.Database.ConnMaxIdleTime < 0 {
+		problems = append(problems, "database connection lifetimes cannot be negative")
+	}
+
+	return problems
+}
+
+func (c *Config) loggingProblems() []string {
+	var problems []string
+	if !validLogLevel(c.Logging.Level) {
+		problems = append(problems, "logging.level must be one of "+strings.Join(LogLevels, ", "))
+	}
+	packages := make([]string, 0, len(c.Logging.Levels))
+	for pkg := range c.Logging.Levels {
+		packages = append(packages, pkg)
+	}
+	sort.Strings(packages)
+	for _, pkg := range packages {
+		if !validLogLevel(c.Logging.Levels[pkg]) {
+			problems = append(problems, "logging.levels."+pkg+" must be one of "+strings.Join(LogLevels, ", "))
+		}
+	}
+	if f := c.Logging.Format; f != "json" && f != "console" {
+		problems = append(problems, "logging.format must be json or console")
+	}
+
+	return problems
+}
+
+func validLogLevel(level string) bool {
+	for _, l := range LogLevels {
+		if level == l {
+			return true
+		}
+	}
+	return false
+}
+
+func (d *Duration) Set(s string) error {
+	if s == "" {
+		*d = 0
+		return nil
+	}
+
+	v, err := time.ParseDuration(s)
+	if err != nil {
+		return errors.New("invalid duration " + s)
+	}
+	*d = Duration(v)
+
+	return nil
+}
+
+func (d Duration) String() string {
+	return time.Duration(d).String()
+}
+
+func (d *Duration) UnmarshalJSON(b []byte) error {
+	var s string
+	if err := json.Unmarshal(b, &s); err != nil {
+		return err
+	}
+
+	return d.Set(s)
+}
+
+func (d Duration) MarshalJSON() ([]byte, error) {
+	return json.Marshal(d.String())
+}
+
+func (d *Duration) UnmarshalYAML(n *yaml.Node) error {
+	return d.Set(n.Value)
+}
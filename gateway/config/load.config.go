@@ -0,0 +1,306 @@
+package config
+
+import (
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// setting binds one configuration value to its environment variable and
+// command line flag.
+type setting struct {
+	env   string
+	flag  string
+	usage string
+	set   func(string) error
+}
+
+func (c *Config) settings() []setting {
+	return []setting{
+		{"GATEWAY_ADDR", "addr", "address the HTTP server listens on", setString(&c.Server.Addr)},
+		{"GATEWAY_DRAIN_DELAY", "drain-delay", "how long readiness fails before the server stops accepting connections", c.Server.DrainDelay.Set},
+		{"GATEWAY_SHUTDOWN_TIMEOUT", "shutdown-timeout", "how long requests in flight may take to finish on shutdown", c.Server.ShutdownTimeout.Set},
+		{"GATEWAY_DB_DSN", "db", "PostgreSQL DSN or SQLite database file", setString(&c.Database.DSN)},
+		{"GATEWAY_DB_MAX_OPEN_CONNS", "db-max-open-conns", "maximum open database connections, 0 is unlimited", setInt(&c.Database.MaxOpenConns)},
+		{"GATEWAY_DB_MAX_IDLE_CONNS", "db-max-idle-conns", "maximum idle database connections", setInt(&c.Database.MaxIdleConns)},
+		{"GATEWAY_DB_CONN_MAX_LIFETIME", "db-conn-max-lifetime", "maximum lifetime of a database connection, 0 is unlimited", c.Database.ConnMaxLifetime.Set},
+		{"GATEWAY_DB_CONN_MAX_IDLE_TIME", "db-conn-max-idle-time", "maximum idle time of a database connection, 0 is unlimited", c.Database.ConnMaxIdleTime.Set},
+		{"GATEWAY_DB_AUTO_MIGRATE", "db-auto-migrate", "apply pending migrations at boot", setBool(&c.Database.AutoMigrate)},
+		{"GATEWAY_JWT_SECRET", "jwt-secret", "secret used to sign access tokens", setString(&c.Security.JWTSecret)},
+		{"GATEWAY_JWT_KEYS", "jwt-keys", "comma separated id=file list of PEM private keys", setKeys(&c.Security.Keys)},
+		{"GATEWAY_JWT_ACTIVE_KEY", "jwt-active-key", "id of the key signing new access tokens", setString(&c.Security.ActiveKeyID)},
+		{"GATEWAY_ACCESS_TOKEN_TTL", "access-token-ttl", "lifetime of access tokens", c.Security.AccessTokenTTL.Set},
+		{"GATEWAY_REFRESH_TOKEN_TTL", "refresh-token-ttl", "lifetime of refresh tokens", c.Security.RefreshTokenTTL.Set},
+		{"GATEWAY_BCRYPT_COST", "bcrypt-cost", "bcrypt cost used to hash passwords", setInt(&c.Security.BcryptCost)},
+		{"GATEWAY_REVOCATION_SYNC_INTERVAL", "revocation-sync-interval", "how often token revocations are reloaded from the database", c.Security.RevocationSyncInterval.Set},
+		{"GATEWAY_LOCKOUT_FREE_ATTEMPTS", "lockout-free-attempts", "failed passwords in a row before logins are delayed", setInt(&c.Security.Lockout.FreeAttempts)},
+		{"GATEWAY_LOCKOUT_DELAY", "lockout-delay", "first delay between login attempts, doubled with each failure", c.Security.Lockout.Delay.Set},
+		{"GATEWAY_LOCKOUT_THRESHOLD", "lockout-threshold", "failed passwords in a row before the account is locked", setInt(&c.Security.Lockout.Threshold)},
+		{"GATEWAY_LOCKOUT_DURATION", "lockout-duration", "how long an account stays locked, 0 disables the lockout", c.Security.Lockout.Duration.Set},
+		{"GATEWAY_LOCKOUT_IP_THRESHOLD", "lockout-ip-threshold", "failed logins per client IP within the window before it is refused, 0 disables", setInt(&c.Security.Lockout.IPThreshold)},
+		{"GATEWAY_LOCKOUT_IP_WINDOW", "lockout-ip-window", "window failed logins per client IP are counted in", c.Security.Lockout.IPWindow.Set},
+		{"GATEWAY_PERMISSION_CACHE_TTL", "permission-cache-ttl", "how long user permissions are cached, 0 disables", c.Security.PermissionCacheTTL.Set},
+		{"GATEWAY_ROUTES_FILE", "routes", "YAML or JSON route table file", setString(&c.Routing.File)},
+		{"GATEWAY_ROUTES_WATCH_INTERVAL", "routes-watch-interval", "how often the route table file is checked for changes, 0 disables", c.Routing.WatchInterval.Set},
+		{"GATEWAY_BOOTSTRAP_ADMIN_USERNAME", "bootstrap-admin-username", "administrator created on an empty database", setString(&c.Bootstrap.AdminUsername)},
+		{"GATEWAY_BOOTSTRAP_ADMIN_PASSWORD", "bootstrap-admin-password", "password of the bootstrap administrator", setString(&c.Bootstrap.AdminPassword)},
+		{"GATEWAY_BOOTSTRAP_ADMIN_EMAIL", "bootstrap-admin-email", "email of the bootstrap administrator", setString(&c.Bootstrap.AdminEmail)},
+		{"GATEWAY_BREAKER_FAILURE_THRESHOLD", "breaker-failure-threshold", "failed upstream calls in a row that open its circuit, 0 disables", setInt(&c.Upstream.Breaker.FailureThreshold)},
+		{"GATEWAY_BREAKER_OPEN_TIMEOUT", "breaker-open-timeout", "how long an open circuit fails fast before trial calls", c.Upstream.Breaker.OpenTimeout.Set},
+		{"GATEWAY_BREAKER_HALF_OPEN_REQUESTS", "breaker-half-open-requests", "trial calls let through a half-open circuit", setInt(&c.Upstream.Breaker.HalfOpenRequests)},
+		{"GATEWAY_RETRY_ATTEMPTS", "retry-attempts", "retries of idempotent upstream calls, 0 disables", setInt(&c.Upstream.Retry.Attempts)},
+		{"GATEWAY_RETRY_BACKOFF", "retry-backoff", "first wait between retries, doubled after each", c.Upstream.Retry.Backoff.Set},
+		{"GATEWAY_RETRY_MAX_BACKOFF", "retry-max-backoff", "longest wait between retries", c.Upstream.Retry.MaxBackoff.Set},
+		{"GATEWAY_RETRY_PER_TRY_TIMEOUT", "retry-per-try-timeout", "how long each attempt waits for response headers, 0 disables", c.Upstream.Retry.PerTryTimeout.Set},
+		{"GATEWAY_HEALTH_CHECK_PATH", "health-check-path", "path probed on every upstream pool member, empty disables", setString(&c.Upstream.HealthCheck.Path)},
+		{"GATEWAY_HEALTH_CHECK_INTERVAL", "health-check-interval", "how often upstream pool members are probed", c.Upstream.HealthCheck.Interval.Set},
+		{"GATEWAY_HEALTH_CHECK_TIMEOUT", "health-check-timeout", "how long a probe waits for an answer", c.Upstream.HealthCheck.Timeout.Set},
+		{"GATEWAY_HEALTH_CHECK_HEALTHY_THRESHOLD", "health-check-healthy-threshold", "passed probes in a row that re-admit a member", setInt(&c.Upstream.HealthCheck.HealthyThreshold)},
+		{"GATEWAY_HEALTH_CHECK_UNHEALTHY_THRESHOLD", "health-check-unhealthy-threshold", "failed probes in a row that take a member out", setInt(&c.Upstream.HealthCheck.UnhealthyThreshold)},
+		{"GATEWAY_EJECTION_CONSECUTIVE_5XX", "ejection-consecutive-5xx", "failed calls in a row that eject a pool member, 0 disables", setInt(&c.Upstream.Ejection.Consecutive5xx)},
+		{"GATEWAY_EJECTION_DURATION", "ejection-duration", "how long an ejected pool member stays out", c.Upstream.Ejection.Duration.Set},
+		{"GATEWAY_DISCOVERY_PROVIDER", "discovery-provider", "where service instances are found: static, file or dns", setString(&c.Discovery.Provider)},
+		{"GATEWAY_DISCOVERY_FILE", "discovery-file", "YAML or JSON service catalog read by the file provider", setString(&c.Discovery.File)},
+		{"GATEWAY_DISCOVERY_DNS_DOMAIN", "discovery-dns-domain", "domain of the SRV records looked up by the dns provider", setString(&c.Discovery.DNS.Domain)},
+		{"GATEWAY_DISCOVERY_DNS_SCHEME", "discovery-dns-scheme", "http or https, used to call instances found in DNS", setString(&c.Discovery.DNS.Scheme)},
+		{"GATEWAY_DISCOVERY_REFRESH_INTERVAL", "discovery-refresh-interval", "how often service instances are looked up again", c.Discovery.RefreshInterval.Set},
+		{"GATEWAY_READINESS_TIMEOUT", "readiness-timeout", "how long each readiness check may take", c.Readiness.Timeout.Set},
+		{"GATEWAY_READINESS_DEGRADED", "readiness-degraded", "comma separated readiness checks that only degrade the gateway when failing", setList(&c.Readiness.Degraded)},
+		{"GATEWAY_METRICS_PATH", "metrics-path", "path serving Prometheus metrics, empty disables", setString(&c.Metrics.Path)},
+		{"GATEWAY_TRACING_EXPORTER", "tracing-exporter", "where spans are sent: none, stdout or otlp", setString(&c.Tracing.Exporter)},
+		{"GATEWAY_TRACING_ENDPOINT", "tracing-endpoint", "OTLP/HTTP collector URL of the otlp exporter", setString(&c.Tracing.Endpoint)},
+		{"GATEWAY_TRACING_SERVICE_NAME", "tracing-service-name", "service name spans are reported under", setString(&c.Tracing.ServiceName)},
+		{"GATEWAY_TRACING_SAMPLE_RATIO", "tracing-sample-ratio", "share of the traces started by the gateway that are kept, from 0 to 1", setFloat(&c.Tracing.SampleRatio)},
+		{"GATEWAY_LOG_LEVEL", "log-level", "debug, info, warn or error", setString(&c.Logging.Level)},
+		{"GATEWAY_LOG_FORMAT", "log-format", "json or console", setString(&c.Logging.Format)},
+		{"GATEWAY_LOG_LEVELS", "log-levels", "comma separated package=level list overriding the log level, e.g. proxy=debug", setLevels(&c.Logging.Levels)},
+		{"GATEWAY_ACCESS_LOG_FORMAT", "access-log-format", "off, common, combined or json", setString(&c.AccessLog.Format)},
+		{"GATEWAY_ACCESS_LOG_OUTPUT", "access-log-output", "stdout or the file access logs are written and rotated in", setString(&c.AccessLog.Output)},
+		{"GATEWAY_ACCESS_LOG_SAMPLE_RATIO", "access-log-sample-ratio", "share of the requests logged, from 0 to 1; server errors always are", setFloat(&c.AccessLog.SampleRatio)},
+		{"GATEWAY_ACCESS_LOG_BODY", "access-log-body", "log request bodies in the json format", setBool(&c.AccessLog.Body)},
+		{"GATEWAY_ACCESS_LOG_HEADERS", "access-log-headers", "comma separated request headers logged in the json format", setList(&c.AccessLog.Headers)},
+		{"GATEWAY_ACCESS_LOG_REDACT_HEADERS", "access-log-redact-headers", "comma separated request headers whose values are not logged", setList(&c.AccessLog.RedactHeaders)},
+		{"GATEWAY_ACCESS_LOG_REDACT_FIELDS", "access-log-redact-fields", "comma separated JSON body fields and query parameters whose values are not logged", setList(&c.AccessLog.RedactFields)},
+		{"GATEWAY_ACCESS_LOG_MAX_SIZE_MB", "access-log-max-size-mb", "size in megabytes at which the access log file is rotated", setInt(&c.AccessLog.MaxSizeMB)},
+		{"GATEWAY_ACCESS_LOG_MAX_BACKUPS", "access-log-max-backups", "rotated access log files kept, 0 keeps all", setInt(&c.AccessLog.MaxBackups)},
+		{"GATEWAY_ACCESS_LOG_MAX_AGE_DAYS", "access-log-max-age-days", "days rotated access log files are kept, 0 keeps them forever", setInt(&c.AccessLog.MaxAgeDays)},
+		{"GATEWAY_ACCESS_LOG_COMPRESS", "access-log-compress", "gzip rotated access log files", setBool(&c.AccessLog.Compress)},
+		{"GATEWAY_RATE_LIMIT_ALGORITHM", "rate-limit-algorithm", "token-bucket or sliding-window", setString(&c.RateLimit.Algorithm)},
+		{"GATEWAY_RATE_LIMIT_GLOBAL", "rate-limit-global", "requests/period allowed per client IP under /api, e.g. 100/1m, empty disables", setQuota(&c.RateLimit.Global)},
+		{"GATEWAY_RATE_LIMIT_LOGIN", "rate-limit-login", "login attempts/period allowed per client IP, e.g. 10/1m, empty disables", setQuota(&c.RateLimit.Login)},
+	}
+}
+
+// Load builds the configuration from, in increasing order of precedence:
+// defaults, the config file, GATEWAY_* environment variables and flags. The
+// config file is given by -config or GATEWAY_CONFIG_FILE. Arguments left
+// after the flags are returned as is.
+func Load(name string, args []string) (*Config, []string, error) {
+	return load(name, args, (*Config).Validate)
+}
+
+// LoadMigrate is Load for the migrate subcommand, which only validates the
+// database and logging sections.
+func LoadMigrate(name string, args []string) (*Config, []string, error) {
+	return load(name, args, (*Config).ValidateMigrate)
+}
+
+func load(name string, args []string, validate func(*Config) error) (*Config, []string, error) {
+	cfg := Default()
+	settings := cfg.settings()
+
+	fs := flag.NewFlagSet(name, flag.ContinueOnError)
+	file := fs.String("config", os.Getenv("GATEWAY_CONFIG_FILE"), "YAML or JSON config file")
+	flags := map[string]string{}
+	for _, s := range settings {
+		fs.Var(recorder{flags, s.flag}, s.flag, s.usage+" ($"+s.env+")")
+	}
+	if err := fs.Parse(args); err != nil {
+		return nil, nil, err
+	}
+
+	if *file != "" {
+		if err := cfg.loadFile(*file); err != nil {
+			return nil, nil, err
+		}
+	}
+
+	for _, s := range settings {
+		if v, ok := os.LookupEnv(s.env); ok {
+			if err := s.set(v); err != nil {
+				return nil, nil, fmt.Errorf("%s: %w", s.env, err)
+			}
+		}
+	}
+	for _, s := range settings {
+		if v, ok := flags[s.flag]; ok {
+			if err := s.set(v); err != nil {
+				return nil, nil, fmt.Errorf("-%s: %w", s.flag, err)
+			}
+		}
+	}
+
+	if err := validate(cfg); err != nil {
+		return nil, nil, err
+	}
+
+	return cfg, fs.Args(), nil
+}
+
+func (c *Config) loadFile(path string) error {
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".yaml", ".yml":
+		err = yaml.Unmarshal(content, c)
+	case ".json":
+		err = json.Unmarshal(content, c)
+	default:
+		err = errors.New("Unsupported config format")
+	}
+	if err != nil {
+		return fmt.Errorf("%s: %w", path, err)
+	}
+
+	return nil
+}
+
+// recorder keeps the raw flag values so they can be applied after the file
+// and the environment.
+type recorder struct {
+	values map[string]string
+	name   string
+}
+
+func (r recorder) String() string {
+	if r.values == nil {
+		return ""
+	}
+
+	return r.values[r.name]
+}
+
+func (r recorder) Set(v string) error {
+	r.values[r.name] = v
+	return nil
+}
+
+func setString(p *string) func(string) error {
+	return func(v string) error {
+		*p = v
+		return nil
+	}
+}
+
+func setBool(p *bool) func(string) error {
+	return func(v string) error {
+		b, err := strconv.ParseBool(v)
+		if err != nil {
+			return errors.New("invalid boolean " + v)
+		}
+		*p = b
+		return nil
+	}
+}
+
+func setInt(p *int) func(string) error {
+	return func(v string) error {
+		i, err := strconv.Atoi(v)
+		if err != nil {
+			return errors.New("invalid integer " + v)
+		}
+		*p = i
+		return nil
+	}
+}
+
+func setFloat(p *float64) func(string) error {
+	return func(v string) error {
+		f, err := strconv.ParseFloat(v, 64)
+		if err != nil {
+			return errors.New("invalid number " + v)
+		}
+		*p = f
+		return nil
+	}
+}
+
+func setList(p *[]string) func(string) error {
+	return func(v string) error {
+		list := []string{}
+		for _, item := range strings.Split(v, ",") {
+			if item = strings.TrimSpace(item); item != "" {
+				list = append(list, item)
+			}
+		}
+		*p = list
+		return nil
+	}
+}
+
+func setQuota(p *Quota) func(string) error {
+	return func(v string) error {
+		if v == "" {
+			*p = Quota{}
+			return nil
+		}
+
+		requests, per, ok := strings.Cut(v, "/")
+		n, err := strconv.Atoi(requests)
+		if !ok || err != nil {
+			return errors.New("invalid quota " + v + ", expected requests/period")
+		}
+		q := Quota{Requests: n}
+		if err := q.Per.Set(per); err != nil {
+			return err
+		}
+		*p = q
+		return nil
+	}
+}
+
+func setLevels(p *map[string]string) func(string) error {
+	return func(v string) error {
+		levels := map[string]string{}
+		for _, entry := range strings.Split(v, ",") {
+			if entry = strings.TrimSpace(entry); entry == "" {
+				continue
+			}
+			pkg, level, ok := strings.Cut(entry, "=")
+			if !ok {
+				return errors.New("invalid log level " + entry + ", expected package=level")
+			}
+			levels[pkg] = level
+		}
+		*p = levels
+		return nil
+	}
+}
+
+func setKeys(p *[]SigningKey) func(string) error {
+	return func(v string) error {
+		var keys []SigningKey
+		for _, entry := range strings.Split(v, ",") {
+			if entry = strings.TrimSpace(entry); entry == "" {
+				continue
+			}
+			id, file, ok := strings.Cut(entry, "=")
+			if !ok {
+				return errors.New("invalid key " + entry + ", expected id=file")
+			}
+			keys = append(keys, SigningKey{ID: id, File: file})
+		}
+		*p = keys
+		return nil
+	}
+}
@@ -0,0 +1,146 @@
+package config
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestLoadConfig(t *testing.T) {
+	Convey("func Load(name string, args []string) (*Config, []string, error)", t, func() {
+		Convey("Given no JWT secret anywhere", func() {
+			Convey("When the function is called", func() {
+				_, _, err := Load("gateway", nil)
+
+				Convey("Then it refuses to boot", func() {
+					So(err, ShouldHaveSameTypeAs, &ValidationError{})
+					So(err.Error(), ShouldContainSubstring, "security.jwtSecret is required")
+				})
+			})
+		})
+
+		Convey("Given a config file, environment variables and flags", func() {
+			path := filepath.Join(t.TempDir(), "gateway.yaml")
+			os.WriteFile(path, []byte(`
+server:
+  addr: ":8000"
+database:
+  dsn: file.db
+security:
+  jwtSecret: from-file
+  accessTokenTtl: 1h
+`), 0600)
+			t.Setenv("GATEWAY_CONFIG_FILE", path)
+			t.Setenv("GATEWAY_DB_DSN", "env.db")
+			t.Setenv("GATEWAY_JWT_SECRET", "from-env")
+
+			Convey("When the function is called", func() {
+				cfg, _, err := Load("gateway", []string{"-jwt-secret", "from-flag", "-bcrypt-cost=12"})
+
+				Convey("Then flags beat the environment, which beats the file", func() {
+					So(err, ShouldBeNil)
+					So(cfg.Server.Addr, ShouldEqual, ":8000")
+					So(cfg.Database.DSN, ShouldEqual, "env.db")
+					So(cfg.Security.JWTSecret, ShouldEqual, "from-flag")
+					So(cfg.Security.BcryptCost, ShouldEqual, 12)
+					So(time.Duration(cfg.Security.AccessTokenTTL), ShouldEqual, time.Hour)
+				})
+
+				Convey("Then unset values keep their defaults", func() {
+					So(time.Duration(cfg.Routing.WatchInterval), ShouldEqual, 5*time.Second)
+					So(cfg.RateLimit.Login, ShouldResemble, Quota{Requests: 10, Per: Duration(time.Minute)})
+				})
+			})
+		})
+
+		Convey("Given malformed values", func() {
+			t.Setenv("GATEWAY_JWT_SECRET", "secret")
+
+			Convey("When a flag cannot be parsed", func() {
+				_, _, err := Load("gateway", []string{"-access-token-ttl", "soon"})
+
+				Convey("Then the flag is named in the error", func() {
+					So(err, ShouldNotBeNil)
+					So(err.Error(), ShouldStartWith, "-access-token-ttl")
+				})
+			})
+
+			Convey("When a value is out of range", func() {
+				_, _, err := Load("gateway", []string{"-bcrypt-cost", "64"})
+
+				Convey("Then validation fails", func() {
+					So(err, ShouldNotBeNil)
+					So(err.Error(), ShouldContainSubstring, "security.bcryptCost")
+				})
+			})
+
+			Convey("When a quota has no period", func() {
+				_, _, err := Load("gateway", []string{"-rate-limit-login", "10"})
+
+				Convey("Then the flag is named in the error", func() {
+					So(err, ShouldNotBeNil)
+					So(err.Error(), ShouldStartWith, "-rate-limit-login")
+				})
+			})
+
+			Convey("When a package is given an unknown log level", func() {
+				t.Setenv("GATEWAY_LOG_LEVELS", "proxy=debug, security=loud")
+				_, _, err := Load("gateway", nil)
+
+				Convey("Then validation names the package", func() {
+					So(err, ShouldNotBeNil)
+					So(err.Error(), ShouldContainSubstring, "logging.levels.security must be one of")
+					So(err.Error(), ShouldNotContainSubstring, "logging.levels.proxy")
+				})
+			})
+
+			Convey("When the access log has an unknown format", func() {
+				t.Setenv("GATEWAY_ACCESS_LOG_FORMAT", "apache")
+				_, _, err := Load("gateway", nil)
+
+				Convey("Then validation fails", func() {
+					So(err, ShouldNotBeNil)
+					So(err.Error(), ShouldContainSubstring, "accessLog.format")
+				})
+			})
+
+			Convey("When the lockout would lock every username on its first attempt", func() {
+				_, _, err := Load("gateway", []string{"-lockout-free-attempts", "0", "-lockout-threshold", "0"})
+
+				Convey("Then validation fails", func() {
+					So(err, ShouldNotBeNil)
+					So(err.Error(), ShouldContainSubstring, "security.lockout.threshold must be above freeAttempts")
+				})
+			})
+		})
+	})
+}
+
+func TestLoadMigrateConfig(t *testing.T) {
+	Convey("func LoadMigrate(name string, args []string) (*Config, []string, error)", t, func() {
+		Convey("Given no JWT secret anywhere", func() {
+			Convey("When the function is called", func() {
+				cfg, rest, err := LoadMigrate("gateway migrate", []string{"up"})
+
+				Convey("Then the migrate subcommand can still run", func() {
+					So(err, ShouldBeNil)
+					So(cfg.Database.DSN, ShouldEqual, "database.db")
+					So(rest, ShouldResemble, []string{"up"})
+				})
+			})
+
+			Convey("When the database section is invalid", func() {
+				_, _, err := LoadMigrate("gateway migrate", []string{"-db", ""})
+
+				Convey("Then validation fails on that section only", func() {
+					So(err, ShouldHaveSameTypeAs, &ValidationError{})
+					So(err.Error(), ShouldContainSubstring, "database.dsn is required")
+					So(err.Error(), ShouldNotContainSubstring, "security.jwtSecret")
+				})
+			})
+		})
+	})
+}
@@ -0,0 +1,18 @@
+package routes
+
+import (
+	"gateway/handlers/admin"
+	mw "gateway/middlewares"
+	"gateway/services/proxy"
+	"gateway/services/routing"
+	"gateway/services/security"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+func AssignAdminHandlers(api fiber.Router, auth *security.Auth, router *routing.Router, p *proxy.Proxy) {
+	group := api.Group("admin", mw.Protected(auth))
+
+	admin.AssignRoutingHandlers(group, router)
+	admin.AssignUpstreamHandlers(group, p)
+}
@@ -0,0 +1,33 @@
+package admin
+
+import (
+	mw "gateway/middlewares"
+	"gateway/models"
+	"gateway/services/routing"
+	"gateway/utils"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+func AssignRoutingHandlers(r fiber.Router, router *routing.Router) {
+	group := r.Group("/routes")
+
+	group.Get("/", mw.RequirePermission(models.PermissionRoutesRead), getRoutingStatus(router))
+	group.Post("/reload", mw.RequirePermission(models.PermissionRoutesWrite), reloadRouting(router))
+}
+
+func getRoutingStatus(router *routing.Router) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		return utils.JSON(c, router.Status())
+	}
+}
+
+func reloadRouting(router *routing.Router) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		if err := router.Reload(); err != nil {
+			return utils.JSONError(c, fiber.StatusUnprocessableEntity, err, router.Status())
+		}
+
+		return utils.JSON(c, router.Status())
+	}
+}
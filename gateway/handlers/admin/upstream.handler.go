@@ -0,0 +1,29 @@
+package admin
+
+import (
+	mw "gateway/middlewares"
+	"gateway/models"
+	"gateway/services/proxy"
+	"gateway/utils"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+func AssignUpstreamHandlers(r fiber.Router, p *proxy.Proxy) {
+	group := r.Group("/upstreams")
+
+	group.Get("/breakers", mw.RequirePermission(models.PermissionRoutesRead), getBreakers(p))
+	group.Get("/pools", mw.RequirePermission(models.PermissionRoutesRead), getPools(p))
+}
+
+func getBreakers(p *proxy.Proxy) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		return utils.JSON(c, p.Breakers())
+	}
+}
+
+func getPools(p *proxy.Proxy) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		return utils.JSON(c, p.Pools())
+	}
+}
@@ -0,0 +1,31 @@
+package routes
+
+import (
+	"gateway/services/health"
+	"gateway/utils"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// AssignHealthHandlers serves the probes of orchestrators and load
+// balancers, outside /api so that rate limits do not apply.
+func AssignHealthHandlers(app fiber.Router) {
+	app.Get("/healthz", getLiveness)
+	app.Get("/readyz", getReadiness)
+}
+
+// getLiveness only tells that the process serves requests; dependencies
+// belong to readiness, or a failing database would get the gateway
+// restarted.
+func getLiveness(c *fiber.Ctx) error {
+	return utils.JSON(c, fiber.Map{"status": health.Up})
+}
+
+func getReadiness(c *fiber.Ctx) error {
+	report := health.Check(c.UserContext())
+	if report.Status == health.Down {
+		return utils.JSONStatus(c, fiber.StatusServiceUnavailable, fiber.ErrServiceUnavailable.Message, report)
+	}
+
+	return utils.JSON(c, report)
+}
@@ -0,0 +1,13 @@
+package routes
+
+import (
+	"gateway/services/metrics"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// AssignMetricsHandlers serves Prometheus metrics at path, outside /api so
+// that scrapes are neither rate limited nor authenticated.
+func AssignMetricsHandlers(app fiber.Router, path string) {
+	app.Get(path, metrics.Handler())
+}
@@ -0,0 +1,118 @@
+package routes
+
+import (
+	"gateway/config"
+	mw "gateway/middlewares"
+	"gateway/services/metrics"
+	"gateway/services/proxy"
+	"gateway/services/ratelimit"
+	"gateway/services/routing"
+	"gateway/services/security"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// AssignProxyHandlers serves the routes of table, forwarding to the pools of
+// pools.
+func AssignProxyHandlers(api fiber.Router, p *proxy.Proxy, pools *proxy.PoolSet, auth *security.Auth, limits config.RateLimit, table *routing.Table) error {
+	for _, r := range table.Routes {
+		handlers, err := proxyHandlers(p, pools, auth, limits, r)
+		if err != nil {
+			return err
+		}
+
+		path := r.Path + "/*"
+		if method := r.MethodOrAll(); method == "*" {
+			api.All(path, handlers...)
+		} else {
+			api.Add(method, path, handlers...)
+		}
+	}
+
+	return nil
+}
+
+func proxyHandlers(p *proxy.Proxy, pools *proxy.PoolSet, auth *security.Auth, limits config.RateLimit, r routing.RouteDefinition) ([]fiber.Handler, error) {
+	forward, err := p.HandlerFor(pools, r.ProxyRoute())
+	if err != nil {
+		return nil, err
+	}
+
+	handlers := []fiber.Handler{func(c *fiber.Ctx) error {
+		metrics.SetRoute(c, c.Route().Path)
+		return c.Next()
+	}}
+	limit := routeRateLimit(r, mw.RateLimitAlgorithm(limits))
+	if limit != nil && r.RateLimit.Key != "user" {
+		handlers = append(handlers, limit)
+	}
+	if !r.Public {
+		handlers = append(handlers, mw.Protected(auth))
+	}
+	if len(r.Roles) > 0 {
+		handlers = append(handlers, mw.CheckRoles(r.Roles...))
+	}
+	if len(r.Permissions) > 0 {
+		handlers = append(handlers, mw.RequirePermission(r.Permissions...))
+	}
+	// Users are only known once Protected has run.
+	if limit != nil && r.RateLimit.Key == "user" {
+		handlers = append(handlers, limit)
+	}
+
+	return append(handlers, forward), nil
+}
+
+// routeRateLimit keeps counting across route table reloads, since the
+// counters are named after the route rather than held by the handler.
+func routeRateLimit(r routing.RouteDefinition, algorithm ratelimit.Algorithm) fiber.Handler {
+	if r.RateLimit == nil {
+		return nil
+	}
+
+	key := mw.RateLimitByIP
+	switch r.RateLimit.Key {
+	case "user":
+		key = mw.RateLimitByUser
+	case "apiKey":
+		key = mw.RateLimitByAPIKey
+	}
+	name := r.Name
+	if name == "" {
+		name = r.MethodOrAll() + " " + r.Path
+	}
+
+	return mw.RateLimit(mw.RateLimitConfig{
+		Name:      "route:" + name,
+		Limit:     ratelimit.Limit{Requests: r.RateLimit.Requests, Per: time.Duration(r.RateLimit.Per)},
+		Key:       key,
+		Algorithm: algorithm,
+	})
+}
+
+// ProxyTableCompiler builds each route table into its own Fiber app mounted
+// under prefix, so that a reload can replace all routes at once. The pools of
+// a table replace the current ones only once it serves. Protected routes
+// accept the tokens auth issued, and route rate limits use the algorithm of
+// limits.
+func ProxyTableCompiler(prefix string, p *proxy.Proxy, auth *security.Auth, limits config.RateLimit) routing.Compiler {
+	return func(table *routing.Table) (fiber.Handler, func(), error) {
+		pools, err := p.PreparePools(table.ProxyPools())
+		if err != nil {
+			return nil, nil, err
+		}
+		app := fiber.New()
+		if err := AssignProxyHandlers(app.Group(prefix), p, pools, auth, limits, table); err != nil {
+			return nil, nil, err
+		}
+		handler := app.Handler()
+
+		return func(c *fiber.Ctx) error {
+			// Routes of the table label requests with their own path.
+			metrics.SetRoute(c, metrics.Unmatched)
+			handler(c.Context())
+			return nil
+		}, pools.Commit, nil
+	}
+}
@@ -1,14 +1,19 @@
 package routes
 
 import (
+	"gateway/config"
 	"gateway/handlers/v1"
+	"gateway/services/security"
 
 	"github.com/gofiber/fiber/v2"
 )
 
-func AssignV1Handlers(api fiber.Router) {
+func AssignV1Handlers(api fiber.Router, auth *security.Auth, cfg config.Security, limits config.RateLimit) {
 	v1 := api.Group("v1")
 
 	handlers.AssignHelloHandlers(v1)
-	handlers.AssignUsersHandlers(v1)
+	handlers.AssignUsersHandlers(v1, auth, cfg, limits)
+	handlers.AssignRolesHandlers(v1, auth)
+	handlers.AssignPermissionsHandlers(v1, auth)
+	handlers.AssignAuditHandlers(v1, auth)
 }
@@ -0,0 +1,34 @@
+package handlers
+
+import (
+	mw "gateway/middlewares"
+	"gateway/models"
+	"gateway/services"
+	"gateway/services/security"
+	"gateway/utils"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+func AssignAuditHandlers(r fiber.Router, auth *security.Auth) {
+	group := r.Group("/audit", mw.Protected(auth), mw.RequirePermission(models.PermissionAuditRead))
+
+	group.Get("/", validateAuditQuery(), findAuditEvents)
+}
+
+func validateAuditQuery() fiber.Handler {
+	return mw.ValidateQueryFnFactory(func() interface{} {
+		return new(models.AuditQueryDto)
+	})
+}
+
+func findAuditEvents(c *fiber.Ctx) error {
+	query := c.Locals("query").(*models.AuditQueryDto)
+
+	page, err := services.FindAuditEvents(c.UserContext(), *query)
+	if err != nil {
+		return utils.JSONError(c, fiber.StatusInternalServerError, err, nil)
+	}
+
+	return utils.JSON(c, page)
+}
@@ -0,0 +1,161 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"gateway/models"
+	"gateway/services"
+	"gateway/services/db"
+	"gateway/utils"
+	"io"
+	"net/http"
+	"testing"
+
+	"github.com/gofiber/fiber/v2"
+	. "github.com/smartystreets/goconvey/convey"
+	"golang.org/x/crypto/bcrypt"
+)
+
+type AuditPageResponse struct {
+	utils.DefaultResponseBody
+	Data models.AuditPage `json:"data"`
+}
+
+func TestAuditModule(t *testing.T) {
+	t.Cleanup(cleanup)
+
+	app = setup()
+	adminToken := adminTokenForTest()
+	admin, _ := services.GetUserByUsername(context.Background(), "root")
+	userToken, _, _ := loginForTest()
+	audited, _ := services.CreateUser(context.Background(), models.CreateUserDto{Username: "audited", Password: "correctpassword", Email: "audited@example.com"}, bcrypt.MinCost)
+
+	Convey("GET /api/v1/audit", t, func() {
+		Convey("Given user does not have the audit:read permission", func() {
+			Convey("When user hit the API", func() {
+				res := requestWithToken("GET", "/api/v1/audit", *userToken, "")
+
+				Convey("Then server responds with HTTP status 403 (forbidden)", func() {
+					So(res.StatusCode, ShouldEqual, fiber.StatusForbidden)
+				})
+			})
+		})
+
+		Convey("Given a login failed", func() {
+			loginForTest(`{"username":"audited","password":"wrongpassword"}`)
+
+			Convey("When an administrator lists failed logins", func() {
+				res, body := auditRequestForTest("/api/v1/audit?action=user.login&success=false", *adminToken)
+
+				Convey("Then the attempt is listed with its reason", func() {
+					assertStatusCode(res, body.DefaultResponseBody, fiber.StatusOK)
+					So(body.Data.Total, ShouldEqual, 1)
+					event := body.Data.Events[0]
+					So(event.Success, ShouldBeFalse)
+					So(event.ActorID, ShouldBeNil)
+					So(*event.TargetID, ShouldEqual, audited.ID)
+					So(event.TargetName, ShouldEqual, "audited")
+					So(event.Reason, ShouldEqual, "Authentication failed")
+					So(event.IP, ShouldNotBeBlank)
+				})
+			})
+
+			Reset(func() {
+				services.UnlockUser(context.Background(), audited.ID)
+			})
+		})
+
+		Convey("Given an administrator changed a user's email and password", func() {
+			requestWithToken("PATCH", fmt.Sprintf("/api/v1/users/%d", audited.ID), *adminToken, `{"email":"changed@example.com","password":"newpassword","repeatPassword":"newpassword","isActive":true}`)
+
+			Convey("When the administrator lists the updates of that user", func() {
+				res, body := auditRequestForTest(fmt.Sprintf("/api/v1/audit?action=user.update&targetId=%d", audited.ID), *adminToken)
+
+				Convey("Then the event lists the changed fields, but no password hash", func() {
+					assertStatusCode(res, body.DefaultResponseBody, fiber.StatusOK)
+					So(body.Data.Total, ShouldEqual, 1)
+					event := body.Data.Events[0]
+					So(*event.ActorID, ShouldEqual, admin.ID)
+					So(event.ActorName, ShouldEqual, "root")
+					So(event.Changes["email"].From, ShouldEqual, "audited@example.com")
+					So(event.Changes["email"].To, ShouldEqual, "changed@example.com")
+					So(event.Changes, ShouldContainKey, "password")
+					So(event.Changes["password"], ShouldResemble, models.AuditChange{})
+					So(event.Changes, ShouldNotContainKey, "isActive")
+				})
+			})
+		})
+
+		Convey("Given an administrator assigned a role", func() {
+			role, _ := services.GetRoleByCode(context.Background(), models.AdminRoleCode)
+			requestWithToken("PUT", fmt.Sprintf("/api/v1/users/%d/roles/%d", audited.ID, role.ID), *adminToken, "")
+
+			Convey("When the administrator lists role assignments", func() {
+				_, body := auditRequestForTest(fmt.Sprintf("/api/v1/audit?action=user.role.assign&targetId=%d", audited.ID), *adminToken)
+
+				Convey("Then the roles before and after are listed", func() {
+					So(body.Data.Total, ShouldEqual, 1)
+					change := body.Data.Events[0].Changes["roles"]
+					So(change.From, ShouldBeEmpty)
+					So(change.To, ShouldResemble, []interface{}{models.AdminRoleCode})
+				})
+			})
+
+			Reset(func() {
+				services.RevokeRole(context.Background(), audited.ID, role.ID)
+			})
+		})
+
+		Convey("Given several events were recorded", func() {
+			Convey("When the administrator asks for the second page", func() {
+				_, first := auditRequestForTest("/api/v1/audit?pageSize=1", *adminToken)
+				res, second := auditRequestForTest("/api/v1/audit?pageSize=1&page=2", *adminToken)
+
+				Convey("Then it holds the event before the first page's", func() {
+					assertStatusCode(res, second.DefaultResponseBody, fiber.StatusOK)
+					So(second.Data.Page, ShouldEqual, 2)
+					So(second.Data.PageSize, ShouldEqual, 1)
+					So(second.Data.Total, ShouldEqual, first.Data.Total)
+					So(len(second.Data.Events), ShouldEqual, 1)
+					So(second.Data.Events[0].ID, ShouldBeLessThan, first.Data.Events[0].ID)
+				})
+			})
+
+			Convey("When the administrator filters with an invalid time", func() {
+				res, body := auditRequestForTest("/api/v1/audit?since=yesterday", *adminToken)
+
+				Convey("Then server responds with HTTP status 400 (bad request)", func() {
+					assertStatusCode(res, body.DefaultResponseBody, fiber.StatusBadRequest)
+				})
+			})
+		})
+	})
+
+	Convey("models.AuditEvent", t, func() {
+		Convey("Given a recorded event", func() {
+			event := models.AuditEvent{}
+			db.Conn.Order("id").First(&event)
+
+			Convey("When it is updated or deleted", func() {
+				updateErr := db.Conn.Model(&event).Update("reason", "rewritten").Error
+				deleteErr := db.Conn.Delete(&event).Error
+
+				Convey("Then both fail", func() {
+					So(updateErr, ShouldEqual, models.ErrAuditAppendOnly)
+					So(deleteErr, ShouldEqual, models.ErrAuditAppendOnly)
+				})
+			})
+		})
+	})
+}
+
+func auditRequestForTest(url, token string) (*http.Response, *AuditPageResponse) {
+	res := getWithToken(url, token)
+	raw, _ := io.ReadAll(res.Body)
+	decoded := AuditPageResponse{}
+	json.Unmarshal(raw, &decoded)
+	So(string(raw), ShouldNotContainSubstring, "$2a$")
+
+	return res, &decoded
+}
@@ -0,0 +1,44 @@
+package handlers
+
+import (
+	mw "gateway/middlewares"
+	"gateway/models"
+	"gateway/services"
+	"gateway/services/security"
+	"gateway/utils"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+func AssignPermissionsHandlers(r fiber.Router, auth *security.Auth) {
+	group := r.Group("/permissions", mw.Protected(auth))
+
+	group.Get("/", mw.RequirePermission(models.PermissionRolesRead), findPermissions)
+	group.Post("/", mw.RequirePermission(models.PermissionRolesWrite), validateCreatePermission(), createPermission)
+}
+
+func findPermissions(c *fiber.Ctx) error {
+	permissions, err := services.FindPermissions(c.UserContext())
+	if err != nil {
+		return utils.JSONError(c, fiber.StatusInternalServerError, err, nil)
+	}
+
+	return utils.JSON(c, permissions)
+}
+
+func validateCreatePermission() fiber.Handler {
+	return mw.ValidateBodyFnFactory(func() interface{} {
+		return new(models.CreatePermissionDto)
+	})
+}
+
+func createPermission(c *fiber.Ctx) error {
+	dto := c.Locals("body").(*models.CreatePermissionDto)
+	permission, err := services.CreatePermission(c.UserContext(), *dto)
+
+	if err != nil {
+		return utils.JSONError(c, fiber.StatusBadRequest, err, nil)
+	}
+
+	return utils.JSON(c, permission)
+}
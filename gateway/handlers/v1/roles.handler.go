@@ -0,0 +1,148 @@
+package handlers
+
+import (
+	"errors"
+	mw "gateway/middlewares"
+	"gateway/models"
+	"gateway/services"
+	"gateway/services/security"
+	"gateway/utils"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+func AssignRolesHandlers(r fiber.Router, auth *security.Auth) {
+	group := r.Group("/roles", mw.Protected(auth))
+	read := mw.RequirePermission(models.PermissionRolesRead)
+	write := mw.RequirePermission(models.PermissionRolesWrite)
+
+	group.Get("/", read, findRoles)
+	group.Post("/", write, validateCreateRole(), createRole)
+	group.Get("/:id", read, getRole)
+	group.Patch("/:id", write, validateUpdateRole(), updateRole)
+	group.Delete("/:id", write, deleteRole)
+	group.Put("/:id/permissions/:permissionId", write, grantPermission)
+	group.Delete("/:id/permissions/:permissionId", write, revokePermission)
+}
+
+func findRoles(c *fiber.Ctx) error {
+	roles, err := services.FindRoles(c.UserContext())
+	if err != nil {
+		return utils.JSONError(c, fiber.StatusInternalServerError, err, nil)
+	}
+
+	return utils.JSON(c, roles)
+}
+
+func validateCreateRole() fiber.Handler {
+	return mw.ValidateBodyFnFactory(func() interface{} {
+		return new(models.CreateRoleDto)
+	})
+}
+
+func createRole(c *fiber.Ctx) error {
+	dto := c.Locals("body").(*models.CreateRoleDto)
+	role, err := services.CreateRole(c.UserContext(), *dto)
+
+	if err != nil {
+		return utils.JSONError(c, fiber.StatusBadRequest, err, nil)
+	}
+
+	return utils.JSON(c, role)
+}
+
+func getRole(c *fiber.Ctx) error {
+	role, err := services.GetRoleByID(c.UserContext(), paramID(c, "id"))
+	if err != nil {
+		return utils.JSONError(c, fiber.StatusNotFound, err, nil)
+	}
+
+	return utils.JSON(c, role)
+}
+
+func validateUpdateRole() fiber.Handler {
+	return mw.ValidateBodyFnFactory(func() interface{} {
+		return new(models.UpdateRoleDto)
+	})
+}
+
+func updateRole(c *fiber.Ctx) error {
+	dto := c.Locals("body").(*models.UpdateRoleDto)
+
+	role, err := services.UpdateRole(c.UserContext(), paramID(c, "id"), *dto)
+	if err != nil {
+		return utils.JSONError(c, roleErrorStatus(err), err, nil)
+	}
+
+	return utils.JSON(c, role)
+}
+
+func deleteRole(c *fiber.Ctx) error {
+	if err := services.DeleteRole(c.UserContext(), paramID(c, "id")); err != nil {
+		return utils.JSONError(c, roleErrorStatus(err), err, nil)
+	}
+
+	return utils.JSONMessage(c, "Deleted", nil)
+}
+
+// assignRole hands out the admin role to administrators only, or roles:write
+// would be enough for anyone to make themselves one.
+func assignRole(c *fiber.Ctx) error {
+	role, err := services.GetRoleByID(c.UserContext(), paramID(c, "roleId"))
+	if err != nil {
+		return utils.JSONError(c, fiber.StatusBadRequest, err, nil)
+	}
+	if role.Code == models.AdminRoleCode && !security.GetUserFromLocals(c).HasRole(models.AdminRoleCode) {
+		return utils.JSONStatus(c, fiber.StatusForbidden, "Only administrators can assign the admin role", nil)
+	}
+
+	user, err := services.AssignRole(c.UserContext(), paramID(c, "id"), paramID(c, "roleId"))
+	if err != nil {
+		return utils.JSONError(c, fiber.StatusBadRequest, err, nil)
+	}
+
+	return utils.JSON(c, models.ToUserSafeDto(*user))
+}
+
+func revokeRole(c *fiber.Ctx) error {
+	user, err := services.RevokeRole(c.UserContext(), paramID(c, "id"), paramID(c, "roleId"))
+	if err != nil {
+		return utils.JSONError(c, roleErrorStatus(err), err, nil)
+	}
+
+	return utils.JSON(c, models.ToUserSafeDto(*user))
+}
+
+func grantPermission(c *fiber.Ctx) error {
+	role, err := services.GrantPermission(c.UserContext(), paramID(c, "id"), paramID(c, "permissionId"))
+	if err != nil {
+		return utils.JSONError(c, roleErrorStatus(err), err, nil)
+	}
+
+	return utils.JSON(c, role)
+}
+
+func revokePermission(c *fiber.Ctx) error {
+	role, err := services.RevokePermission(c.UserContext(), paramID(c, "id"), paramID(c, "permissionId"))
+	if err != nil {
+		return utils.JSONError(c, roleErrorStatus(err), err, nil)
+	}
+
+	return utils.JSON(c, role)
+}
+
+func roleErrorStatus(err error) int {
+	switch {
+	case errors.Is(err, services.ErrAdminRoleLocked):
+		return fiber.StatusForbidden
+	case errors.Is(err, services.ErrLastAdmin):
+		return fiber.StatusConflict
+	default:
+		return fiber.StatusBadRequest
+	}
+}
+
+func paramID(c *fiber.Ctx, key string) uint {
+	id, _ := c.ParamsInt(key)
+	return uint(id)
+}
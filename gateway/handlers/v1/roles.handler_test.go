@@ -0,0 +1,257 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"gateway/models"
+	"gateway/services"
+	"gateway/utils"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gofiber/fiber/v2"
+	. "github.com/smartystreets/goconvey/convey"
+	"golang.org/x/crypto/bcrypt"
+)
+
+type RoleResponse struct {
+	utils.DefaultResponseBody
+	Data models.Role `json:"data"`
+}
+
+type GetRolesResponse struct {
+	utils.DefaultResponseBody
+	Data []models.Role `json:"data"`
+}
+
+func TestRolesModule(t *testing.T) {
+	t.Cleanup(cleanup)
+
+	app = setup()
+	adminToken := adminTokenForTest()
+	adminRole, _ := services.GetRoleByCode(context.Background(), models.AdminRoleCode)
+	userToken, _, _ := loginForTest()
+
+	Convey("/api/v1/roles", t, func() {
+		Convey("Given user does not have the roles:read permission", func() {
+			Convey("When user hit the API", func() {
+				res := requestWithToken("GET", "/api/v1/roles", *userToken, "")
+
+				Convey("Then server responds with HTTP status 403 (forbidden)", func() {
+					So(res.StatusCode, ShouldEqual, fiber.StatusForbidden)
+				})
+			})
+
+			Convey("When an administrator grants it through a role", func() {
+				_, role := roleRequestForTest("POST", "/api/v1/roles", *adminToken, `{"code":"auditor"}`)
+				permission := permissionForTest(models.PermissionRolesRead)
+				user, _ := services.GetUserByUsername(context.Background(), "user")
+				requestWithToken("PUT", fmt.Sprintf("/api/v1/roles/%d/permissions/%d", role.Data.ID, permission.ID), *adminToken, "")
+				requestWithToken("PUT", fmt.Sprintf("/api/v1/users/%d/roles/%d", user.ID, role.Data.ID), *adminToken, "")
+
+				Convey("Then user can hit the API with the token they already have", func() {
+					res := requestWithToken("GET", "/api/v1/roles", *userToken, "")
+					So(res.StatusCode, ShouldEqual, fiber.StatusOK)
+				})
+
+				Convey("And the role is deleted", func() {
+					services.DeleteRole(context.Background(), role.Data.ID)
+
+					Convey("Then user is forbidden again", func() {
+						res := requestWithToken("GET", "/api/v1/roles", *userToken, "")
+						So(res.StatusCode, ShouldEqual, fiber.StatusForbidden)
+					})
+				})
+
+				Reset(func() {
+					services.DeleteRole(context.Background(), role.Data.ID)
+				})
+			})
+		})
+
+		Convey("Given user holds roles:write without being an administrator", func() {
+			_, role := roleRequestForTest("POST", "/api/v1/roles", *adminToken, `{"code":"rolemanager"}`)
+			permission := permissionForTest(models.PermissionRolesWrite)
+			user, _ := services.GetUserByUsername(context.Background(), "user")
+			requestWithToken("PUT", fmt.Sprintf("/api/v1/roles/%d/permissions/%d", role.Data.ID, permission.ID), *adminToken, "")
+			requestWithToken("PUT", fmt.Sprintf("/api/v1/users/%d/roles/%d", user.ID, role.Data.ID), *adminToken, "")
+
+			Convey("When user assigns the admin role to themselves", func() {
+				res := requestWithToken("PUT", fmt.Sprintf("/api/v1/users/%d/roles/%d", user.ID, adminRole.ID), *userToken, "")
+				unchanged, _ := services.GetUserByID(context.Background(), user.ID)
+
+				Convey("Then server responds with HTTP status 403 (forbidden)", func() {
+					So(res.StatusCode, ShouldEqual, fiber.StatusForbidden)
+					So(unchanged.HasRole(models.AdminRoleCode), ShouldBeFalse)
+				})
+			})
+
+			Convey("When user assigns another role", func() {
+				res := requestWithToken("PUT", fmt.Sprintf("/api/v1/users/%d/roles/%d", user.ID, role.Data.ID), *userToken, "")
+
+				Convey("Then server responds with HTTP status 200 (OK)", func() {
+					So(res.StatusCode, ShouldEqual, fiber.StatusOK)
+				})
+			})
+
+			Reset(func() {
+				services.DeleteRole(context.Background(), role.Data.ID)
+			})
+		})
+
+		Convey("Given user is an administrator", func() {
+			Convey("When user creates a role", func() {
+				res, body := roleRequestForTest("POST", "/api/v1/roles", *adminToken, `{"code":"editor"}`)
+
+				Convey("Then server responds with HTTP status 200 (OK) with the new role", func() {
+					assertStatusCode(res, body.DefaultResponseBody, fiber.StatusOK)
+					So(body.Data.Code, ShouldEqual, "editor")
+				})
+
+				Convey("And user creates the same role again", func() {
+					res, body := roleRequestForTest("POST", "/api/v1/roles", *adminToken, `{"code":"editor"}`)
+
+					Convey("Then server responds with HTTP status 400 (bad request)", func() {
+						assertStatusCode(res, body.DefaultResponseBody, fiber.StatusBadRequest)
+						So(body.Message, ShouldEqual, "Role already exists")
+					})
+				})
+
+				Convey("And user renames the role", func() {
+					url := fmt.Sprintf("/api/v1/roles/%d", body.Data.ID)
+					res, body := roleRequestForTest("PATCH", url, *adminToken, `{"code":"writer"}`)
+
+					Convey("Then server responds with HTTP status 200 (OK) with the renamed role", func() {
+						assertStatusCode(res, body.DefaultResponseBody, fiber.StatusOK)
+						So(body.Data.Code, ShouldEqual, "writer")
+					})
+				})
+
+				Convey("And user assigns the role to a user", func() {
+					user, _ := services.GetUserByUsername(context.Background(), "user")
+					url := fmt.Sprintf("/api/v1/users/%d/roles/%d", user.ID, body.Data.ID)
+					res := requestWithToken("PUT", url, *adminToken, "")
+					assigned, _ := services.GetUserByID(context.Background(), user.ID)
+
+					Convey("Then the user has the role", func() {
+						So(res.StatusCode, ShouldEqual, fiber.StatusOK)
+						So(len(assigned.Roles), ShouldEqual, 1)
+					})
+
+					Convey("And user deletes the role", func() {
+						res := requestWithToken("DELETE", fmt.Sprintf("/api/v1/roles/%d", body.Data.ID), *adminToken, "")
+						revoked, _ := services.GetUserByID(context.Background(), user.ID)
+
+						Convey("Then the role is removed from the user as well", func() {
+							So(res.StatusCode, ShouldEqual, fiber.StatusOK)
+							So(len(revoked.Roles), ShouldEqual, 0)
+						})
+					})
+				})
+
+				Reset(func() {
+					if role, err := services.GetRoleByCode(context.Background(), "editor"); err == nil {
+						services.DeleteRole(context.Background(), role.ID)
+					}
+					if role, err := services.GetRoleByCode(context.Background(), "writer"); err == nil {
+						services.DeleteRole(context.Background(), role.ID)
+					}
+				})
+			})
+
+			Convey("When user lists the roles", func() {
+				res := requestWithToken("GET", "/api/v1/roles", *adminToken, "")
+				body := GetRolesResponse{}
+				json.NewDecoder(res.Body).Decode(&body)
+
+				Convey("Then the seeded admin role is listed", func() {
+					assertStatusCode(res, body.DefaultResponseBody, fiber.StatusOK)
+					So(len(body.Data), ShouldEqual, 1)
+					So(body.Data[0].Code, ShouldEqual, models.AdminRoleCode)
+				})
+			})
+
+			Convey("When user changes another user's record", func() {
+				user, _ := services.GetUserByUsername(context.Background(), "user")
+				res := requestWithToken("PATCH", fmt.Sprintf("/api/v1/users/%d", user.ID), *adminToken, `{"email":"changed@example.com"}`)
+
+				Convey("Then the users:admin permission allows it", func() {
+					So(res.StatusCode, ShouldEqual, fiber.StatusOK)
+				})
+			})
+
+			Convey("When user deletes the admin role", func() {
+				url := fmt.Sprintf("/api/v1/roles/%d", adminRole.ID)
+				res, body := roleRequestForTest("DELETE", url, *adminToken, "")
+
+				Convey("Then server responds with HTTP status 403 (forbidden)", func() {
+					assertStatusCode(res, body.DefaultResponseBody, fiber.StatusForbidden)
+				})
+			})
+
+			Convey("When user revokes a permission from the admin role", func() {
+				url := fmt.Sprintf("/api/v1/roles/%d/permissions/%d", adminRole.ID, adminRole.Permissions[0].ID)
+				res, body := roleRequestForTest("DELETE", url, *adminToken, "")
+
+				Convey("Then server responds with HTTP status 403 (forbidden)", func() {
+					assertStatusCode(res, body.DefaultResponseBody, fiber.StatusForbidden)
+				})
+			})
+
+			Convey("When user is the last active administrator", func() {
+				root, _ := services.GetUserByUsername(context.Background(), "root")
+				revoked := requestWithToken("DELETE", fmt.Sprintf("/api/v1/users/%d/roles/%d", root.ID, adminRole.ID), *adminToken, "")
+				deactivated := requestWithToken("PATCH", fmt.Sprintf("/api/v1/users/%d", root.ID), *adminToken, `{"isActive":false}`)
+				unchanged, _ := services.GetUserByID(context.Background(), root.ID)
+
+				Convey("Then server responds with HTTP status 409 (conflict) to losing the admin role", func() {
+					So(revoked.StatusCode, ShouldEqual, fiber.StatusConflict)
+					So(deactivated.StatusCode, ShouldEqual, fiber.StatusConflict)
+					So(unchanged.IsActive, ShouldBeTrue)
+					So(unchanged.HasRole(models.AdminRoleCode), ShouldBeTrue)
+				})
+			})
+
+			Convey("When another active administrator is revoked the admin role", func() {
+				other, _ := services.CreateUser(context.Background(), models.CreateUserDto{Username: "deputy", Password: "correctpassword", Email: "deputy@example.com"}, bcrypt.MinCost)
+				services.AssignRole(context.Background(), other.ID, adminRole.ID)
+				res := requestWithToken("DELETE", fmt.Sprintf("/api/v1/users/%d/roles/%d", other.ID, adminRole.ID), *adminToken, "")
+
+				Convey("Then server responds with HTTP status 200 (OK)", func() {
+					So(res.StatusCode, ShouldEqual, fiber.StatusOK)
+				})
+			})
+		})
+	})
+}
+
+func requestWithToken(method, url, token, body string) *http.Response {
+	req := httptest.NewRequest(method, "http://localhost:3000"+url, strings.NewReader(body))
+	req.Header.Add("Authorization", "Bearer "+token)
+	req.Header.Add("Content-Type", "application/json")
+	res, _ := app.Test(req)
+
+	return res
+}
+
+func roleRequestForTest(method, url, token, body string) (*http.Response, *RoleResponse) {
+	res := requestWithToken(method, url, token, body)
+	decoded := RoleResponse{}
+	json.NewDecoder(res.Body).Decode(&decoded)
+
+	return res, &decoded
+}
+
+func permissionForTest(code string) *models.Permission {
+	permissions, _ := services.FindPermissions(context.Background())
+	for _, p := range permissions {
+		if p.Code == code {
+			return &p
+		}
+	}
+
+	return nil
+}
@@ -1,23 +1,33 @@
 package handlers
 
 import (
+	"errors"
+	"gateway/config"
 	mw "gateway/middlewares"
 	"gateway/models"
 	"gateway/services"
+	"gateway/services/policy"
 	"gateway/services/security"
 	"gateway/utils"
 
 	"github.com/gofiber/fiber/v2"
 )
 
-func AssignUsersHandlers(r fiber.Router) {
+func AssignUsersHandlers(r fiber.Router, auth *security.Auth, cfg config.Security, limits config.RateLimit) {
 	group := r.Group("/users")
-
-	group.Post("/login", validateLogin(), login)
-	group.Get("/me", mw.Protected(), getMyProfile)
-	group.Post("/", mw.Protected(), validateCreateUser(), createUser)
-	group.Get("/", mw.Protected(), findUsers)
-	group.Patch("/:id", mw.Protected(), validateUpdateUser(), updateUser)
+	protected := mw.Protected(auth)
+
+	group.Post("/login", mw.LoginRateLimit(limits), validateLogin(), login(auth))
+	group.Post("/token/refresh", validateRefreshToken(), refreshToken(auth))
+	group.Post("/logout", protected, validateLogout(), logout)
+	group.Post("/logout/all", protected, logoutEverywhere)
+	group.Get("/me", protected, getMyProfile)
+	group.Post("/", protected, mw.RequirePermission(models.PermissionUsersWrite), validateCreateUser(), createUser(cfg.BcryptCost))
+	group.Get("/", protected, mw.RequirePermission(models.PermissionUsersRead), findUsers)
+	group.Patch("/:id", protected, mw.Authorize(updateUserPolicy), validateUpdateUser(), updateUser(cfg.BcryptCost))
+	group.Post("/:id/unlock", protected, mw.RequirePermission(models.PermissionUsersAdmin), unlockUser)
+	group.Put("/:id/roles/:roleId", protected, mw.RequirePermission(models.PermissionRolesWrite), assignRole)
+	group.Delete("/:id/roles/:roleId", protected, mw.RequirePermission(models.PermissionRolesWrite), revokeRole)
 }
 
 func validateLogin() fiber.Handler {
@@ -26,10 +36,42 @@ func validateLogin() fiber.Handler {
 	})
 }
 
-func login(c *fiber.Ctx) error {
-	dto := c.Locals("body").(*models.LoginDto)
+func login(auth *security.Auth) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		dto := c.Locals("body").(*models.LoginDto)
+
+		return auth.DoLogin(c, *dto)
+	}
+}
+
+func validateRefreshToken() fiber.Handler {
+	return mw.ValidateBodyFnFactory(func() interface{} {
+		return new(models.RefreshTokenDto)
+	})
+}
+
+func refreshToken(auth *security.Auth) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		dto := c.Locals("body").(*models.RefreshTokenDto)
+
+		return auth.DoRefresh(c, *dto)
+	}
+}
 
-	return security.DoLogin(c, *dto)
+func validateLogout() fiber.Handler {
+	return mw.ValidateBodyFnFactory(func() interface{} {
+		return new(models.LogoutDto)
+	})
+}
+
+func logout(c *fiber.Ctx) error {
+	dto := c.Locals("body").(*models.LogoutDto)
+
+	return security.DoLogout(c, *dto)
+}
+
+func logoutEverywhere(c *fiber.Ctx) error {
+	return security.DoLogoutEverywhere(c)
 }
 
 func getMyProfile(c *fiber.Ctx) error {
@@ -38,15 +80,18 @@ func getMyProfile(c *fiber.Ctx) error {
 	return utils.JSON(c, user)
 }
 
-func createUser(c *fiber.Ctx) error {
-	dto := c.Locals("body").(*models.CreateUserDto)
-	user, err := services.CreateUser(*dto)
+// createUser hashes passwords with bcrypt at cost.
+func createUser(cost int) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		dto := c.Locals("body").(*models.CreateUserDto)
+		user, err := services.CreateUser(c.UserContext(), *dto, cost)
 
-	if err != nil {
-		return utils.JSONError(c, fiber.StatusBadRequest, err, nil)
-	}
+		if err != nil {
+			return utils.JSONError(c, fiber.StatusBadRequest, err, nil)
+		}
 
-	return utils.JSON(c, models.ToUserSafeDto(*user))
+		return utils.JSON(c, models.ToUserSafeDto(*user))
+	}
 }
 
 func validateCreateUser() fiber.Handler {
@@ -58,7 +103,7 @@ func validateCreateUser() fiber.Handler {
 func findUsers(c *fiber.Ctx) error {
 	username := c.Query("username")
 
-	users, err := services.FindUsers(username)
+	users, err := services.FindUsers(c.UserContext(), username)
 	if err != nil {
 		return utils.JSONError(c, fiber.StatusInternalServerError, err, nil)
 	}
@@ -71,14 +116,56 @@ func findUsers(c *fiber.Ctx) error {
 	return utils.JSON(c, dtos)
 }
 
-func updateUser(c *fiber.Ctx) error {
-	tmpId, _ := c.ParamsInt("id")
-	id := uint(tmpId)
-	dto := c.Locals("body").(*models.UpdateUserDto)
+// updateUserPolicy lets users change their own record only, unless they hold
+// users:admin.
+var updateUserPolicy = policy.Policy{
+	Name: "users.update",
+	Rules: []policy.Rule{
+		{Name: "self", Effect: policy.Allow, When: []policy.Condition{policy.ParamIsSubject("id")}},
+		{Name: "admin", Effect: policy.Allow, When: []policy.Condition{policy.HasPermission(models.PermissionUsersAdmin)}},
+	},
+}
 
-	user, err := services.UpdateUser(id, *dto)
+// updateUser hashes passwords with bcrypt at cost. Users changing their own
+// password confirm it with the current one, which a stolen token lacks.
+func updateUser(cost int) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		tmpId, _ := c.ParamsInt("id")
+		id := uint(tmpId)
+		dto := c.Locals("body").(*models.UpdateUserDto)
+
+		if dto.Password != "" && id == security.GetUserFromLocals(c).ID {
+			if err := services.VerifyPassword(c.UserContext(), id, dto.CurrentPassword); err != nil {
+				return utils.JSONError(c, updateUserErrorStatus(err), err, nil)
+			}
+		}
+
+		user, err := services.UpdateUser(c.UserContext(), id, *dto, cost)
+		if err != nil {
+			return utils.JSONError(c, updateUserErrorStatus(err), err, nil)
+		}
+
+		return utils.JSON(c, models.ToUserSafeDto(*user))
+	}
+}
+
+func updateUserErrorStatus(err error) int {
+	switch {
+	case errors.Is(err, services.ErrUserNotFound):
+		return fiber.StatusNotFound
+	case errors.Is(err, services.ErrWrongPassword):
+		return fiber.StatusForbidden
+	case errors.Is(err, services.ErrUsernameTaken), errors.Is(err, services.ErrEmailTaken), errors.Is(err, services.ErrLastAdmin):
+		return fiber.StatusConflict
+	default:
+		return fiber.StatusInternalServerError
+	}
+}
+
+func unlockUser(c *fiber.Ctx) error {
+	user, err := services.UnlockUser(c.UserContext(), paramID(c, "id"))
 	if err != nil {
-		return utils.JSONError(c, fiber.StatusInternalServerError, err, nil)
+		return utils.JSONError(c, fiber.StatusNotFound, err, nil)
 	}
 
 	return utils.JSON(c, models.ToUserSafeDto(*user))
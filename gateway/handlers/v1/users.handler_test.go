@@ -1,19 +1,26 @@
 package handlers
 
 import (
+	"context"
 	"encoding/json"
+	"fmt"
+	"gateway/config"
 	"gateway/models"
 	"gateway/services"
 	"gateway/services/db"
+	"gateway/services/db/migrations"
+	"gateway/services/security"
 	"gateway/utils"
 	"net/http"
 	"net/http/httptest"
 	"os"
 	"strings"
 	"testing"
+	"time"
 
 	"github.com/gofiber/fiber/v2"
 	. "github.com/smartystreets/goconvey/convey"
+	"golang.org/x/crypto/bcrypt"
 )
 
 var (
@@ -48,6 +55,202 @@ func TestUsersModule(t *testing.T) {
 		})
 	})
 
+	Convey("POST /api/v1/users/login lockout", t, func() {
+		Convey("Given user has failed their free attempts", func() {
+			services.CreateUser(context.Background(), models.CreateUserDto{Username: "guesser", Password: "correctpassword", Email: "guesser@example.com"}, bcrypt.MinCost)
+			loginForTest(`{"username":"guesser","password":"wrongpassword"}`)
+			_, res, body := loginForTest(`{"username":"guesser","password":"wrongpassword"}`)
+
+			Convey("Then the last failure still responds HTTP status 401 (unauthorized)", func() {
+				assertStatusCode(res, body.DefaultResponseBody, fiber.StatusUnauthorized)
+			})
+
+			Convey("When user tries again with the correct password", func() {
+				_, res, body := loginForTest(`{"username":"guesser","password":"correctpassword"}`)
+
+				Convey("Then server responds with HTTP status 429 (too many requests) and Retry-After", func() {
+					assertStatusCode(res, body.DefaultResponseBody, fiber.StatusTooManyRequests)
+					So(res.Header.Get("Retry-After"), ShouldEqual, "3600")
+				})
+			})
+
+			Convey("When an administrator unlocks the account", func() {
+				user, _ := services.GetUserByUsername(context.Background(), "guesser")
+				res := requestWithToken("POST", fmt.Sprintf("/api/v1/users/%d/unlock", user.ID), *adminTokenForTest(), "")
+				token, loginRes, _ := loginForTest(`{"username":"guesser","password":"correctpassword"}`)
+
+				Convey("Then user can log in again", func() {
+					So(res.StatusCode, ShouldEqual, fiber.StatusOK)
+					So(loginRes.StatusCode, ShouldEqual, fiber.StatusOK)
+
+					profile := decodeMyProfileFromResponse(getWithToken("/api/v1/users/me", *token))
+					So(profile.Data.LastLoginAt, ShouldNotBeNil)
+					So(profile.Data.LockedUntil, ShouldBeNil)
+				})
+			})
+
+			Convey("When a user without users:admin unlocks the account", func() {
+				user, _ := services.GetUserByUsername(context.Background(), "guesser")
+				token, _, _ := loginForTest()
+				res := requestWithToken("POST", fmt.Sprintf("/api/v1/users/%d/unlock", user.ID), *token, "")
+
+				Convey("Then server responds with HTTP status 403 (forbidden)", func() {
+					So(res.StatusCode, ShouldEqual, fiber.StatusForbidden)
+				})
+			})
+
+			Convey("When an administrator lists users", func() {
+				res := getWithToken("/api/v1/users?username=guesser", *adminTokenForTest())
+				body := GetUsersResponse{}
+				json.NewDecoder(res.Body).Decode(&body)
+
+				Convey("Then the account shows its failed logins and until when it is locked", func() {
+					So(len(body.Data), ShouldEqual, 1)
+					So(body.Data[0].FailedLoginCount, ShouldEqual, 2)
+					So(body.Data[0].LockedUntil, ShouldNotBeNil)
+				})
+			})
+
+			Reset(func() {
+				user, _ := services.GetUserByUsername(context.Background(), "guesser")
+				services.UnlockUser(context.Background(), user.ID)
+			})
+		})
+
+		Convey("Given a username that no user has", func() {
+			_, first, firstBody := loginForTest(`{"username":"nobody","password":"wrongpassword"}`)
+			loginForTest(`{"username":"nobody","password":"wrongpassword"}`)
+
+			Convey("When it is tried more often than the free attempts allow", func() {
+				_, res, body := loginForTest(`{"username":"nobody","password":"wrongpassword"}`)
+
+				Convey("Then it is locked out like an existing account", func() {
+					assertStatusCode(first, firstBody.DefaultResponseBody, fiber.StatusUnauthorized)
+					assertStatusCode(res, body.DefaultResponseBody, fiber.StatusTooManyRequests)
+					So(res.Header.Get("Retry-After"), ShouldEqual, "3600")
+				})
+			})
+
+			Convey("When login attempts are pruned after the lock has passed", func() {
+				lockout := config.Lockout{Duration: config.Duration(time.Hour)}
+				past := time.Now().Add(-2 * time.Hour)
+				for _, name := range []string{"nobody", "user"} {
+					db.Conn.Save(&models.LoginAttempt{Username: name, FailedCount: 2, LockedUntil: &past})
+				}
+				db.Conn.Model(&models.LoginAttempt{}).Where("username IN ?", []string{"nobody", "user"}).UpdateColumn("updated_at", past)
+				err := services.PruneLoginAttempts(context.Background(), lockout)
+
+				Convey("Then only the attempts of the unknown username are gone", func() {
+					So(err, ShouldBeNil)
+					var names []string
+					db.Conn.Model(&models.LoginAttempt{}).Where("username IN ?", []string{"nobody", "user"}).Pluck("username", &names)
+					So(names, ShouldResemble, []string{"user"})
+				})
+
+				Reset(func() {
+					db.Conn.Where("username = ?", "user").Delete(&models.LoginAttempt{})
+				})
+			})
+		})
+	})
+
+	Convey("POST /api/v1/users/token/refresh", t, func() {
+		Convey("Given user has logged in (has refresh token)", func() {
+			_, _, login := loginForTest()
+
+			Convey("When user hit the API with the refresh token", func() {
+				res, body := refreshForTest(login.Data.RefreshToken)
+
+				Convey("Then server responds with HTTP status 200 (OK) with a new token pair", func() {
+					assertStatusCode(res, body.DefaultResponseBody, fiber.StatusOK)
+					So(body.Data.AccessToken, ShouldNotBeBlank)
+					So(body.Data.RefreshToken, ShouldNotBeBlank)
+					So(body.Data.RefreshToken, ShouldNotEqual, login.Data.RefreshToken)
+				})
+
+				Convey("And user hit the API again with the already used refresh token", func() {
+					replayRes, replayBody := refreshForTest(login.Data.RefreshToken)
+
+					Convey("Then server responds with HTTP status 401 (unauthorized)", func() {
+						assertStatusCode(replayRes, replayBody.DefaultResponseBody, fiber.StatusUnauthorized)
+						So(replayBody.Message, ShouldEqual, "Refresh token reuse detected")
+					})
+
+					Convey("Then the rotated refresh token is revoked as well", func() {
+						res, body := refreshForTest(body.Data.RefreshToken)
+						assertStatusCode(res, body.DefaultResponseBody, fiber.StatusUnauthorized)
+					})
+				})
+			})
+		})
+
+		Convey("Given an unknown refresh token", func() {
+			Convey("When user hit the API", func() {
+				res, body := refreshForTest("not-a-token")
+
+				Convey("Then server responds with HTTP status 401 (unauthorized)", func() {
+					assertStatusCode(res, body.DefaultResponseBody, fiber.StatusUnauthorized)
+					So(body.Message, ShouldEqual, "Invalid refresh token")
+				})
+			})
+		})
+	})
+
+	Convey("POST /api/v1/users/logout", t, func() {
+		Convey("Given user has not logged in", func() {
+			req := httptest.NewRequest("POST", "http://localhost:3000/api/v1/users/logout", nil)
+			assertProtectedEndpoint(req)
+		})
+
+		Convey("Given user has logged in (has access and refresh token)", func() {
+			token, _, login := loginForTest()
+
+			Convey("When user hit the API", func() {
+				res := postWithToken("/api/v1/users/logout", *token, `{"refreshToken":"`+login.Data.RefreshToken+`"}`)
+
+				Convey("Then server responds with HTTP status 200 (OK)", func() {
+					So(res.StatusCode, ShouldEqual, fiber.StatusOK)
+				})
+
+				Convey("Then the access token is rejected afterwards", func() {
+					res := getWithToken("/api/v1/users/me", *token)
+					body := decodeMyProfileFromResponse(res)
+
+					assertStatusCode(res, body.DefaultResponseBody, fiber.StatusUnauthorized)
+					So(body.Message, ShouldEqual, "Token revoked")
+				})
+
+				Convey("Then the refresh token is rejected afterwards", func() {
+					res, body := refreshForTest(login.Data.RefreshToken)
+					assertStatusCode(res, body.DefaultResponseBody, fiber.StatusUnauthorized)
+				})
+			})
+		})
+	})
+
+	Convey("POST /api/v1/users/logout/all", t, func() {
+		services.CreateUser(context.Background(), models.CreateUserDto{Username: "everywhere", Password: "correctpassword", Email: "everywhere@example.com"}, bcrypt.MinCost)
+		cred := `{"username":"everywhere","password":"correctpassword"}`
+
+		Convey("Given user has logged in on two devices", func() {
+			token1, _, login1 := loginForTest(cred)
+			token2, _, _ := loginForTest(cred)
+
+			Convey("When user hit the API from one of them in the same second", func() {
+				res := postWithToken("/api/v1/users/logout/all", *token2, "")
+
+				Convey("Then every token of the user is rejected afterwards", func() {
+					So(res.StatusCode, ShouldEqual, fiber.StatusOK)
+					So(getWithToken("/api/v1/users/me", *token1).StatusCode, ShouldEqual, fiber.StatusUnauthorized)
+					So(getWithToken("/api/v1/users/me", *token2).StatusCode, ShouldEqual, fiber.StatusUnauthorized)
+
+					refreshRes, _ := refreshForTest(login1.Data.RefreshToken)
+					So(refreshRes.StatusCode, ShouldEqual, fiber.StatusUnauthorized)
+				})
+			})
+		})
+	})
+
 	Convey("GET /api/v1/users/me", t, func() {
 		Convey("Given user has not logged in", func() {
 			req := httptest.NewRequest("GET", "http://localhost:3000/api/v1/users/me", nil)
@@ -86,9 +289,21 @@ func TestUsersModule(t *testing.T) {
 			assertProtectedEndpoint(req)
 		})
 
-		Convey("Given user has logged in (has access token)", func() {
+		Convey("Given user does not have the users:write permission", func() {
 			token, _, _ := loginForTest()
 
+			Convey("When user hit the API", func() {
+				res := requestWithToken("POST", "/api/v1/users", *token, `{"username":"sneaky","password":"correctpassword","repeatPassword":"correctpassword","email":"sneaky@example.com"}`)
+
+				Convey("Then server responds with HTTP status 403 (forbidden)", func() {
+					So(res.StatusCode, ShouldEqual, fiber.StatusForbidden)
+				})
+			})
+		})
+
+		Convey("Given user has the users:write permission", func() {
+			token := adminTokenForTest()
+
 			Convey("When user hit the API", func() {
 				req := httptest.NewRequest("POST", "http://localhost:3000/api/v1/users", mock1)
 				req.Header.Add("Authorization", "Bearer "+*token)
@@ -131,9 +346,21 @@ func TestUsersModule(t *testing.T) {
 			assertProtectedEndpoint(req)
 		})
 
-		Convey("Given user has logged in (has access token)", func() {
+		Convey("Given user does not have the users:read permission", func() {
 			token, _, _ := loginForTest()
 
+			Convey("When user hit the API", func() {
+				res := getWithToken("/api/v1/users", *token)
+
+				Convey("Then server responds with HTTP status 403 (forbidden)", func() {
+					So(res.StatusCode, ShouldEqual, fiber.StatusForbidden)
+				})
+			})
+		})
+
+		Convey("Given user has the users:read permission", func() {
+			token := adminTokenForTest()
+
 			Convey("When user hit the API without query", func() {
 				req := httptest.NewRequest("GET", "http://localhost:3000/api/v1/users", nil)
 				req.Header.Add("Authorization", "Bearer "+*token)
@@ -166,10 +393,49 @@ func TestUsersModule(t *testing.T) {
 		})
 
 		Convey("Given user has logged in (has access token)", func() {
-			// token, _, _ := loginForTest()
+			token, _, _ := loginForTest()
+			user, _ := services.GetUserByUsername(context.Background(), "user")
 
 			Convey("When user hit the API with correct data", func() {
-				Convey("Then server responds with HTTP 200 and saved user data", nil)
+				res := requestWithToken("PATCH", fmt.Sprintf("/api/v1/users/%d", user.ID), *token, `{"email":"user@example.org"}`)
+				body := decodeMyProfileFromResponse(res)
+
+				Convey("Then server responds with HTTP 200 and saved user data", func() {
+					assertStatusCode(res, body.DefaultResponseBody, fiber.StatusOK)
+					So(body.Data.Email, ShouldEqual, "user@example.org")
+				})
+			})
+
+			Convey("When user hit the API for another user", func() {
+				services.CreateUser(context.Background(), models.CreateUserDto{Username: "other", Password: "correctpassword", Email: "other@example.com"}, bcrypt.MinCost)
+				other, _ := services.GetUserByUsername(context.Background(), "other")
+				res := requestWithToken("PATCH", fmt.Sprintf("/api/v1/users/%d", other.ID), *token, `{"email":"hijacked@example.com"}`)
+				body := decodeMyProfileFromResponse(res)
+				unchanged, _ := services.GetUserByID(context.Background(), other.ID)
+
+				Convey("Then server responds with HTTP 403 (forbidden) and the user is unchanged", func() {
+					assertStatusCode(res, body.DefaultResponseBody, fiber.StatusForbidden)
+					So(unchanged.Email, ShouldEqual, "other@example.com")
+				})
+			})
+
+			Convey("When user changes their own password", func() {
+				services.CreateUser(context.Background(), models.CreateUserDto{Username: "selfchanger", Password: "correctpassword", Email: "selfchanger@example.com"}, bcrypt.MinCost)
+				self, _ := services.GetUserByUsername(context.Background(), "selfchanger")
+				selfToken, _, _ := loginForTest(`{"username":"selfchanger","password":"correctpassword"}`)
+				url := fmt.Sprintf("/api/v1/users/%d", self.ID)
+
+				Convey("Then the current password is required", func() {
+					missing := requestWithToken("PATCH", url, *selfToken, `{"password":"newpassword","repeatPassword":"newpassword"}`)
+					wrong := requestWithToken("PATCH", url, *selfToken, `{"password":"newpassword","repeatPassword":"newpassword","currentPassword":"wrongpassword"}`)
+					So(missing.StatusCode, ShouldEqual, fiber.StatusForbidden)
+					So(wrong.StatusCode, ShouldEqual, fiber.StatusForbidden)
+					So(services.VerifyPassword(context.Background(), self.ID, "correctpassword"), ShouldBeNil)
+
+					res := requestWithToken("PATCH", url, *selfToken, `{"password":"newpassword","repeatPassword":"newpassword","currentPassword":"correctpassword"}`)
+					So(res.StatusCode, ShouldEqual, fiber.StatusOK)
+					So(services.VerifyPassword(context.Background(), self.ID, "newpassword"), ShouldBeNil)
+				})
 			})
 
 			Convey("When user hit the API with incorrect data", func() {
@@ -177,7 +443,54 @@ func TestUsersModule(t *testing.T) {
 			})
 
 			Convey("When user hit the API with duplicate data", func() {
-				Convey("Then server responds with HTTP 400", nil)
+				services.CreateUser(context.Background(), models.CreateUserDto{Username: "other", Password: "correctpassword", Email: "other@example.com"}, bcrypt.MinCost)
+				res := requestWithToken("PATCH", fmt.Sprintf("/api/v1/users/%d", user.ID), *token, `{"email":"other@example.com"}`)
+				body := decodeMyProfileFromResponse(res)
+
+				Convey("Then server responds with HTTP 409 (conflict)", func() {
+					assertStatusCode(res, body.DefaultResponseBody, fiber.StatusConflict)
+					So(body.Message, ShouldEqual, "Email already exists")
+				})
+			})
+		})
+
+		Convey("Given an administrator", func() {
+			token := adminTokenForTest()
+			user, _ := services.GetUserByUsername(context.Background(), "user")
+
+			Convey("When they deactivate a user", func() {
+				res := requestWithToken("PATCH", fmt.Sprintf("/api/v1/users/%d", user.ID), *token, `{"isActive":false}`)
+				body := decodeMyProfileFromResponse(res)
+				deactivated, _ := services.GetUserByID(context.Background(), user.ID)
+				events, _ := services.FindAuditEvents(context.Background(), models.AuditQueryDto{Action: models.AuditUserUpdate})
+
+				Convey("Then the user is inactive, with the change audited", func() {
+					assertStatusCode(res, body.DefaultResponseBody, fiber.StatusOK)
+					So(deactivated.IsActive, ShouldBeFalse)
+					So(deactivated.Email, ShouldEqual, user.Email)
+					So(events.Events[0].Changes, ShouldResemble, models.AuditChanges{"isActive": {From: true, To: false}})
+				})
+			})
+
+			Convey("When they change the password of a user in the second the user logged in", func() {
+				services.CreateUser(context.Background(), models.CreateUserDto{Username: "changer", Password: "correctpassword", Email: "changer@example.com"}, bcrypt.MinCost)
+				changer, _ := services.GetUserByUsername(context.Background(), "changer")
+				changerToken, _, _ := loginForTest(`{"username":"changer","password":"correctpassword"}`)
+				res := requestWithToken("PATCH", fmt.Sprintf("/api/v1/users/%d", changer.ID), *token, `{"password":"newpassword","repeatPassword":"newpassword"}`)
+
+				Convey("Then the token issued before the change is rejected", func() {
+					So(res.StatusCode, ShouldEqual, fiber.StatusOK)
+					So(getWithToken("/api/v1/users/me", *changerToken).StatusCode, ShouldEqual, fiber.StatusUnauthorized)
+				})
+			})
+
+			Convey("When they update a user that does not exist", func() {
+				res := requestWithToken("PATCH", "/api/v1/users/9999", *token, `{"email":"nobody@example.com"}`)
+				body := decodeMyProfileFromResponse(res)
+
+				Convey("Then server responds with HTTP 404", func() {
+					assertStatusCode(res, body.DefaultResponseBody, fiber.StatusNotFound)
+				})
 			})
 		})
 	})
@@ -222,12 +535,23 @@ type GetUsersResponse struct {
 }
 
 func setup() (app *fiber.App) {
-	db.InitDB()
+	cfg := config.Default()
+	cfg.Security.JWTSecret = "secret"
+	cfg.Security.BcryptCost = bcrypt.MinCost
+	cfg.Security.Lockout = config.Lockout{FreeAttempts: 1, Threshold: 2, Duration: config.Duration(time.Hour)}
+	db.InitDB(cfg.Database)
+	m, _ := db.NewMigrator(db.Conn, migrations.All())
+	m.Up()
+	auth, _ := security.New(cfg.Security)
+	// Tests log in far more often than the login quota allows.
+	cfg.RateLimit.Login = config.Quota{}
 	app = fiber.New()
 	router := app.Group("/api").Group("/v1")
-	AssignUsersHandlers(router)
+	AssignUsersHandlers(router, auth, cfg.Security, cfg.RateLimit)
+	AssignRolesHandlers(router, auth)
+	AssignAuditHandlers(router, auth)
 
-	services.CreateUser(models.CreateUserDto{Username: "user", Password: "correctpassword", Email: "user@example.com"})
+	services.CreateUser(context.Background(), models.CreateUserDto{Username: "user", Password: "correctpassword", Email: "user@example.com"}, bcrypt.MinCost)
 
 	return
 }
@@ -255,6 +579,45 @@ func loginForTest(cred ...string) (*string, *http.Response, *LoginResponse) {
 	return &token, res, &body
 }
 
+// adminTokenForTest logs in as root, who holds the admin role.
+func adminTokenForTest() *string {
+	admin, err := services.CreateUser(context.Background(), models.CreateUserDto{Username: "root", Password: "correctpassword", Email: "root@example.com"}, bcrypt.MinCost)
+	if err == nil {
+		role, _ := services.GetRoleByCode(context.Background(), models.AdminRoleCode)
+		services.AssignRole(context.Background(), admin.ID, role.ID)
+	}
+	token, _, _ := loginForTest(`{"username":"root","password":"correctpassword"}`)
+
+	return token
+}
+
+func refreshForTest(refreshToken string) (*http.Response, *LoginResponse) {
+	req := httptest.NewRequest("POST", "http://localhost:3000/api/v1/users/token/refresh", strings.NewReader(`{"refreshToken":"`+refreshToken+`"}`))
+	req.Header.Set("Content-Type", "application/json")
+	res, _ := app.Test(req)
+	body := LoginResponse{}
+
+	json.NewDecoder(res.Body).Decode(&body)
+	return res, &body
+}
+
+func getWithToken(url, token string) *http.Response {
+	req := httptest.NewRequest("GET", "http://localhost:3000"+url, nil)
+	req.Header.Add("Authorization", "Bearer "+token)
+	res, _ := app.Test(req)
+
+	return res
+}
+
+func postWithToken(url, token, body string) *http.Response {
+	req := httptest.NewRequest("POST", "http://localhost:3000"+url, strings.NewReader(body))
+	req.Header.Add("Authorization", "Bearer "+token)
+	req.Header.Add("Content-Type", "application/json")
+	res, _ := app.Test(req)
+
+	return res
+}
+
 func assertStatusCode(res *http.Response, d utils.DefaultResponseBody, s int) {
 	So(res.StatusCode, ShouldEqual, s)
 	So(d.Status, ShouldEqual, s)
@@ -282,7 +645,7 @@ func assertProtectedEndpoint(r *http.Request) {
 func doTestFindUser(res *http.Response, username string) {
 	body := GetUsersResponse{}
 	json.NewDecoder(res.Body).Decode(&body)
-	users, _ := services.FindUsers(username)
+	users, _ := services.FindUsers(context.Background(), username)
 
 	assertStatusCode(res, body.DefaultResponseBody, fiber.StatusOK)
 	So(body.Message, ShouldEqual, "Success")
@@ -0,0 +1,22 @@
+package routes
+
+import (
+	"gateway/services/security"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+func AssignWellKnownHandlers(app fiber.Router, auth *security.Auth) {
+	group := app.Group("/.well-known")
+
+	group.Get("/jwks.json", getJWKS(auth))
+}
+
+// getJWKS answers in the plain JWK Set format rather than the API envelope,
+// since JWT libraries fetch it directly.
+func getJWKS(auth *security.Auth) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		c.Set(fiber.HeaderCacheControl, "public, max-age=300")
+		return c.JSON(auth.JWKS())
+	}
+}
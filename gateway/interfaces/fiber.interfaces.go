@@ -6,6 +6,10 @@ type FiberBodyParser interface {
 	BodyParser(interface{}) error
 }
 
+type FiberQueryParser interface {
+	QueryParser(interface{}) error
+}
+
 type FiberJSONSender interface {
 	JSON(interface{}) error
 }
@@ -21,3 +25,15 @@ type FiberNextRunner interface {
 type FiberStatusSetter interface {
 	Status(int) *fiber.Ctx
 }
+
+type FiberMethodGetter interface {
+	Method(...string) string
+}
+
+type FiberPathGetter interface {
+	Path(...string) string
+}
+
+type FiberParamsGetter interface {
+	AllParams() map[string]string
+}
@@ -1,19 +1,185 @@
 package main
 
 import (
+	"context"
+	"fmt"
+	"gateway/config"
 	routes "gateway/handlers"
+	mw "gateway/middlewares"
+	"gateway/services"
+	"gateway/services/accesslog"
 	"gateway/services/db"
+	"gateway/services/discovery"
+	"gateway/services/health"
+	"gateway/services/logging"
+	"gateway/services/metrics"
+	"gateway/services/proxy"
+	"gateway/services/routing"
+	"gateway/services/security"
+	"gateway/services/tracing"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
 
 	"github.com/gofiber/fiber/v2"
+	"go.uber.org/zap"
 )
 
 func main() {
-	db.InitDB()
+	if len(os.Args) > 1 && os.Args[1] == "migrate" {
+		runMigrate(os.Args[2:])
+		return
+	}
+
+	if err := serve(os.Args[1:]); err != nil {
+		logging.Named("main").Error("Gateway stopped", zap.Error(err))
+		logging.Sync()
+		os.Exit(1)
+	}
+}
+
+// serve runs the gateway until SIGTERM or SIGINT and then drains it. It
+// fails when the gateway cannot start or requests are still in flight at
+// the shutdown deadline.
+func serve(args []string) error {
+	cfg, rest, err := config.Load(os.Args[0], args)
+	if err != nil {
+		return err
+	}
+	if len(rest) > 0 {
+		return fmt.Errorf("Unexpected argument %q", rest[0])
+	}
+	if err := logging.Init(cfg.Logging); err != nil {
+		return err
+	}
+	defer logging.Sync()
+
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGTERM, syscall.SIGINT)
+	defer stop()
+
+	shutdownTracing, err := tracing.Init(cfg.Tracing)
+	if err != nil {
+		return err
+	}
+	// Runs last, to export the spans of the requests drained on shutdown.
+	defer func() {
+		ctx, cancel := context.WithTimeout(context.Background(), time.Duration(cfg.Server.ShutdownTimeout))
+		defer cancel()
+		if err := shutdownTracing(ctx); err != nil {
+			logging.Named("main").Warn("Failed to export the last spans", zap.Error(err))
+		}
+	}()
+
+	// Route tables protect routes with auth, so it comes first.
+	auth, err := security.New(cfg.Security)
+	if err != nil {
+		return err
+	}
+	catalog, err := discovery.New(cfg.Discovery)
+	if err != nil {
+		return err
+	}
+	upstreams := proxy.New(nil).Configure(cfg.Upstream).UseDiscovery(catalog, time.Duration(cfg.Discovery.RefreshInterval))
+	defer upstreams.Close()
+	router, err := routing.NewRouter(cfg.Routing.File, routes.ProxyTableCompiler("/api", upstreams, auth, cfg.RateLimit))
+	if err != nil {
+		return err
+	}
+	go router.WatchSignal(ctx)
+	if interval := time.Duration(cfg.Routing.WatchInterval); interval > 0 {
+		go router.WatchFile(ctx, interval)
+	}
+
+	if err := db.InitDB(cfg.Database); err != nil {
+		return err
+	}
+	defer db.Close()
+	sqlDB, err := db.Conn.DB()
+	if err != nil {
+		return err
+	}
+	if err := metrics.RegisterDB(sqlDB, "gateway"); err != nil {
+		return fmt.Errorf("Failed to register the database metrics: %w", err)
+	}
+	if cfg.Database.AutoMigrate {
+		if err := migrateUp(); err != nil {
+			return err
+		}
+	}
+	if _, err := services.BootstrapAdmin(ctx, cfg.Bootstrap, cfg.Security.BcryptCost); err != nil {
+		return err
+	}
+	if err := security.SyncRevocations(ctx); err != nil {
+		return err
+	}
+	go security.WatchRevocations(ctx, time.Duration(cfg.Security.RevocationSyncInterval))
+	go services.WatchLoginAttempts(ctx, cfg.Security.Lockout)
+
+	migrator, err := newMigrator()
+	if err != nil {
+		return err
+	}
+	health.Init(cfg.Readiness)
+	health.Register("database", db.Ping)
+	health.Register("migrations", migrator.Check)
+	health.Register("upstreams", upstreams.Check)
+
 	app := fiber.New()
-	// api := app.Group("/api", logger.New())
-	api := app.Group("/api")
+	app.Use(mw.RequestID())
+	app.Use(metrics.Middleware())
+	app.Use(tracing.Middleware())
+	app.Use(logging.Middleware())
+	if cfg.AccessLog.Format != "off" {
+		accessLog, err := accesslog.New(cfg.AccessLog)
+		if err != nil {
+			return err
+		}
+		defer accessLog.Close()
+		app.Use(accessLog.Middleware())
+	}
+	api := app.Group("/api", mw.GlobalRateLimit(cfg.RateLimit))
+
+	if cfg.Metrics.Path != "" {
+		routes.AssignMetricsHandlers(app, cfg.Metrics.Path)
+	}
+	routes.AssignHealthHandlers(app)
+	routes.AssignWellKnownHandlers(app, auth)
+	routes.AssignV1Handlers(api, auth, cfg.Security, cfg.RateLimit)
+	routes.AssignAdminHandlers(api, auth, router, upstreams)
+	api.Use(router.Handler())
+
+	listening := make(chan error, 1)
+	go func() { listening <- app.Listen(cfg.Server.Addr) }()
+
+	select {
+	case err := <-listening:
+		return err
+	case <-ctx.Done():
+	}
+	// From now on a second signal kills the process right away.
+	stop()
+
+	return shutdown(app, cfg.Server)
+}
+
+// shutdown fails readiness, leaves load balancers cfg.DrainDelay to notice,
+// then stops accepting connections and waits for requests in flight.
+func shutdown(app *fiber.App, cfg config.Server) error {
+	logging.Named("main").Info("Shutting down", zap.Stringer("drainDelay", cfg.DrainDelay))
+	health.Drain()
+	time.Sleep(time.Duration(cfg.DrainDelay))
 
-	routes.AssignV1Handlers(api)
+	done := make(chan error, 1)
+	go func() { done <- app.Shutdown() }()
 
-	app.Listen(":3000")
+	select {
+	case err := <-done:
+		if err == nil {
+			logging.Named("main").Info("Shut down cleanly")
+		}
+		return err
+	case <-time.After(time.Duration(cfg.ShutdownTimeout)):
+		return fmt.Errorf("Shutdown timed out after %s with requests still in flight", cfg.ShutdownTimeout)
+	}
 }
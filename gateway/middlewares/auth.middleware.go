@@ -17,12 +17,13 @@ type roleChecker interface {
 	interfaces.FiberStatusSetter
 }
 
-func Protected() fiber.Handler {
+// Protected lets through requests bearing a valid access token issued by
+// auth. Keys are looked up when a request arrives, not when routes are built.
+func Protected(auth *security.Auth) fiber.Handler {
 	return jwtware.New(jwtware.Config{
-		SigningKey:     []byte(security.JWT_SECRET),
+		KeyFunc:        auth.KeyFunc,
 		ErrorHandler:   security.JwtError,
-		SuccessHandler: security.JwtSuccess,
-		SigningMethod:  "HS512",
+		SuccessHandler: auth.JwtSuccess,
 	})
 }
 
@@ -46,3 +47,22 @@ func checkRoles(c roleChecker, roles []string) error {
 
 	return utils.JSONStatus(c, fiber.StatusUnauthorized, fiber.ErrUnauthorized.Message, nil)
 }
+
+// RequirePermission lets the request through only when the user holds every
+// one of the permissions.
+func RequirePermission(permissions ...string) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		return requirePermission(c, permissions)
+	}
+}
+
+func requirePermission(c roleChecker, permissions []string) error {
+	user := c.Locals("user").(*models.UserSafeDto)
+	for _, p := range permissions {
+		if !user.HasPermission(p) {
+			return utils.JSONStatus(c, fiber.StatusForbidden, fiber.ErrForbidden.Message, nil)
+		}
+	}
+
+	return c.Next()
+}
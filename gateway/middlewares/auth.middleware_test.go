@@ -1,25 +1,49 @@
 package middlewares
 
 import (
+	"gateway/config"
 	"gateway/models"
+	"gateway/services/security"
 	"gateway/utils"
+	"net/http/httptest"
 	"testing"
+	"time"
 
 	"github.com/gofiber/fiber/v2"
+	"github.com/golang-jwt/jwt/v4"
 	. "github.com/smartystreets/goconvey/convey"
 )
 
 func TestAuthMiddleware(t *testing.T) {
-	Convey("func Protected() fiber.Handler", t, func() {
+	Convey("func Protected(auth *security.Auth) fiber.Handler", t, func() {
+		auth, _ := security.New(config.Security{JWTSecret: "secret"})
+
 		Convey("Given the function", func() {
 			Convey("When the function is called", func() {
-				r := Protected()
+				r := Protected(auth)
 
 				Convey("Then it returns fiber.Handler function", func() {
 					asserTypeIsFiberHandler(r)
 				})
 			})
 		})
+
+		Convey("Given a token signed with an empty HMAC key", func() {
+			claims := jwt.MapClaims{"jti": "forged", "sub": "1", "exp": time.Now().Add(time.Hour).Unix()}
+			forged, _ := jwt.NewWithClaims(jwt.SigningMethodHS512, claims).SignedString([]byte(""))
+
+			Convey("When it is sent to a protected route", func() {
+				app := fiber.New()
+				app.Get("/", Protected(auth), func(c *fiber.Ctx) error { return c.SendStatus(fiber.StatusNoContent) })
+				req := httptest.NewRequest("GET", "/", nil)
+				req.Header.Set("Authorization", "Bearer "+forged)
+				res, _ := app.Test(req)
+
+				Convey("Then it is rejected", func() {
+					So(res.StatusCode, ShouldEqual, fiber.StatusUnauthorized)
+				})
+			})
+		})
 	})
 
 	Convey("func CheckRoles(roles ...string) fiber.Handler", t, func() {
@@ -73,6 +97,37 @@ func TestAuthMiddleware(t *testing.T) {
 			})
 		})
 	})
+
+	Convey("func requirePermission(c roleChecker, permissions []string) error", t, func() {
+		Convey("Given user has some permissions", func() {
+
+			Convey("When the function is called with permissions the user has", func() {
+				c := new(checkRolesContextMock)
+				requirePermission(c, []string{"users:read", "users:write"})
+
+				Convey("Then it calls the next handler", func() {
+					So(len(c.nextCalls), ShouldEqual, 1)
+					So(len(c.statusCalls), ShouldEqual, 0)
+				})
+			})
+
+			Convey("When the function is called with a permission the user doesn't have", func() {
+				c := new(checkRolesContextMock)
+				requirePermission(c, []string{"users:read", "roles:write"})
+
+				Convey("Then it send HTTP Status 403 (Forbidden)", func() {
+					So(len(c.nextCalls), ShouldEqual, 0)
+
+					So(len(c.statusCalls), ShouldEqual, 1)
+					So(c.statusCalls[0].Params[0], ShouldEqual, fiber.StatusForbidden)
+
+					So(len(c.jsonCalls), ShouldEqual, 1)
+					b := c.jsonCalls[0].Params[0].(utils.DefaultResponseBody)
+					So(b.Message, ShouldEqual, fiber.ErrForbidden.Message)
+				})
+			})
+		})
+	})
 }
 
 type checkRolesContextMock struct {
@@ -89,7 +144,8 @@ func (c *checkRolesContextMock) JSON(o interface{}) error {
 
 func (c *checkRolesContextMock) Locals(k string, v ...interface{}) interface{} {
 	u := &models.UserSafeDto{
-		Roles: []models.Role{{Code: "role 1"}, {Code: "role 2"}},
+		Roles:       []models.Role{{Code: "role 1"}, {Code: "role 2"}},
+		Permissions: []string{"users:read", "users:write"},
 	}
 	c.localCalls = append(c.localCalls, new(models.FnCallData).SetParams(k, v).SetReturns(u))
 
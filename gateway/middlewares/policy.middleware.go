@@ -0,0 +1,58 @@
+package middlewares
+
+import (
+	"gateway/interfaces"
+	"gateway/models"
+	"gateway/services/policy"
+	"gateway/utils"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/golang-jwt/jwt/v4"
+)
+
+type policyChecker interface {
+	roleChecker
+	interfaces.FiberMethodGetter
+	interfaces.FiberPathGetter
+	interfaces.FiberParamsGetter
+}
+
+// Authorize evaluates p for every request and lets it through only when the
+// policy allows it. It must run after Protected.
+func Authorize(p policy.Policy) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		return authorize(c, p)
+	}
+}
+
+func authorize(c policyChecker, p policy.Policy) error {
+	decision := p.Evaluate(policyRequest(c))
+	if !decision.Allowed {
+		return utils.JSONStatus(c, fiber.StatusForbidden, fiber.ErrForbidden.Message, fiber.Map{"policy": decision.Policy})
+	}
+
+	return c.Next()
+}
+
+func policyRequest(c policyChecker) *policy.Request {
+	r := &policy.Request{
+		Method: c.Method(),
+		Path:   c.Path(),
+		Params: c.AllParams(),
+		Claims: map[string]interface{}{},
+	}
+
+	if user, ok := c.Locals("user").(*models.UserSafeDto); ok {
+		r.Subject = policy.Subject{ID: user.ID, Username: user.Username, Permissions: user.Permissions}
+		for _, role := range user.Roles {
+			r.Subject.Roles = append(r.Subject.Roles, role.Code)
+		}
+	}
+	if token, ok := c.Locals("token").(*jwt.Token); ok {
+		if claims, ok := token.Claims.(jwt.MapClaims); ok {
+			r.Claims = claims
+		}
+	}
+
+	return r
+}
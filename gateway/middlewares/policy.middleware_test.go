@@ -0,0 +1,116 @@
+package middlewares
+
+import (
+	"gateway/models"
+	"gateway/services/policy"
+	"gateway/utils"
+	"testing"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/golang-jwt/jwt/v4"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestPolicyMiddleware(t *testing.T) {
+	Convey("func Authorize(p policy.Policy) fiber.Handler", t, func() {
+		Convey("Given the function", func() {
+			Convey("When the function is called", func() {
+				r := Authorize(policy.Policy{})
+
+				Convey("Then it returns fiber.Handler function", func() {
+					asserTypeIsFiberHandler(r)
+				})
+			})
+		})
+	})
+
+	Convey("func authorize(c policyChecker, p policy.Policy) error", t, func() {
+		p := policy.Policy{
+			Name: "users.update",
+			Rules: []policy.Rule{
+				{Name: "self", Effect: policy.Allow, When: []policy.Condition{policy.ParamIsSubject("id"), policy.ClaimEquals("sub", "7")}},
+				{Name: "admin", Effect: policy.Allow, When: []policy.Condition{policy.HasRole("admin")}},
+			},
+		}
+
+		Convey("Given user changes their own record", func() {
+			c := newPolicyContextMock("7")
+
+			Convey("When the function is called", func() {
+				authorize(c, p)
+
+				Convey("Then it calls the next handler", func() {
+					So(len(c.nextCalls), ShouldEqual, 1)
+					So(len(c.statusCalls), ShouldEqual, 0)
+				})
+			})
+		})
+
+		Convey("Given user changes another user's record", func() {
+			c := newPolicyContextMock("8")
+
+			Convey("When the function is called", func() {
+				authorize(c, p)
+
+				Convey("Then it send HTTP Status 403 (Forbidden) naming the policy", func() {
+					So(len(c.nextCalls), ShouldEqual, 0)
+					So(c.statusCalls[0].Params[0], ShouldEqual, fiber.StatusForbidden)
+
+					b := c.jsonCalls[0].Params[0].(utils.DefaultResponseBody)
+					So(b.Message, ShouldEqual, fiber.ErrForbidden.Message)
+					So(b.Data, ShouldResemble, fiber.Map{"policy": "users.update"})
+				})
+			})
+
+			Convey("When user has the admin role", func() {
+				c.user.Roles = []models.Role{{Code: "admin"}}
+				authorize(c, p)
+
+				Convey("Then it calls the next handler", func() {
+					So(len(c.nextCalls), ShouldEqual, 1)
+				})
+			})
+		})
+	})
+}
+
+type policyContextMock struct {
+	checkRolesContextMock
+	user   *models.UserSafeDto
+	token  *jwt.Token
+	params map[string]string
+}
+
+func newPolicyContextMock(id string) *policyContextMock {
+	user := &models.UserSafeDto{Username: "user"}
+	user.ID = 7
+
+	return &policyContextMock{
+		user:   user,
+		token:  &jwt.Token{Claims: jwt.MapClaims{"sub": "7"}},
+		params: map[string]string{"id": id},
+	}
+}
+
+func (c *policyContextMock) Locals(k string, v ...interface{}) interface{} {
+	c.localCalls = append(c.localCalls, new(models.FnCallData).SetParams(k, v))
+	switch k {
+	case "user":
+		return c.user
+	case "token":
+		return c.token
+	}
+	return nil
+}
+
+func (c *policyContextMock) Method(override ...string) string {
+	return fiber.MethodPatch
+}
+
+func (c *policyContextMock) Path(override ...string) string {
+	return "/api/v1/users/" + c.params["id"]
+}
+
+func (c *policyContextMock) AllParams() map[string]string {
+	return c.params
+}
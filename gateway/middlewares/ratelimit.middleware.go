@@ -0,0 +1,126 @@
+package middlewares
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"gateway/config"
+	"gateway/models"
+	"gateway/services/logging"
+	"gateway/services/ratelimit"
+	"gateway/utils"
+	"math"
+	"strconv"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"go.uber.org/zap"
+)
+
+// RateLimitKey tells which client a request counts against.
+type RateLimitKey func(c *fiber.Ctx) string
+
+type RateLimitConfig struct {
+	// Name keeps the counters of different limits apart.
+	Name      string
+	Limit     ratelimit.Limit
+	Key       RateLimitKey
+	Algorithm ratelimit.Algorithm
+	Store     ratelimit.Store
+}
+
+// RateLimit rejects requests over cfg.Limit with HTTP 429 and reports the
+// quota in RateLimit-Limit, RateLimit-Remaining and RateLimit-Reset headers.
+// When the store fails, requests are let through. The algorithm defaults to
+// the token bucket.
+func RateLimit(cfg RateLimitConfig) fiber.Handler {
+	if !cfg.Limit.Enabled() {
+		return func(c *fiber.Ctx) error { return c.Next() }
+	}
+	if cfg.Key == nil {
+		cfg.Key = RateLimitByIP
+	}
+	if cfg.Algorithm == nil {
+		cfg.Algorithm = ratelimit.TokenBucket
+	}
+	if cfg.Store == nil {
+		cfg.Store = ratelimit.Default
+	}
+	limiter := &ratelimit.Limiter{Limit: cfg.Limit, Algorithm: cfg.Algorithm, Store: cfg.Store}
+
+	return func(c *fiber.Ctx) error {
+		result, err := limiter.Take(cfg.Name + "|" + cfg.Key(c))
+		if err != nil {
+			logging.Ctx(c.UserContext(), "ratelimit").Error("Rate limit store failed, letting the request through", zap.Error(err))
+			return c.Next()
+		}
+
+		c.Set("RateLimit-Limit", strconv.Itoa(result.Limit))
+		c.Set("RateLimit-Remaining", strconv.Itoa(result.Remaining))
+		c.Set("RateLimit-Reset", seconds(result.Reset))
+		if !result.Allowed {
+			c.Set(fiber.HeaderRetryAfter, seconds(maxDuration(result.RetryAfter, time.Second)))
+			return utils.JSONStatus(c, fiber.StatusTooManyRequests, fiber.ErrTooManyRequests.Message, nil)
+		}
+
+		return c.Next()
+	}
+}
+
+// GlobalRateLimit applies the global quota of cfg per client IP.
+func GlobalRateLimit(cfg config.RateLimit) fiber.Handler {
+	return RateLimit(RateLimitConfig{Name: "global", Limit: quotaLimit(cfg.Global), Algorithm: RateLimitAlgorithm(cfg)})
+}
+
+// LoginRateLimit applies the login quota of cfg per client IP.
+func LoginRateLimit(cfg config.RateLimit) fiber.Handler {
+	return RateLimit(RateLimitConfig{Name: "login", Limit: quotaLimit(cfg.Login), Algorithm: RateLimitAlgorithm(cfg)})
+}
+
+// RateLimitAlgorithm returns the algorithm cfg names. Validation already
+// rejected unknown names.
+func RateLimitAlgorithm(cfg config.RateLimit) ratelimit.Algorithm {
+	algorithm, _ := ratelimit.ParseAlgorithm(cfg.Algorithm)
+	return algorithm
+}
+
+func RateLimitByIP(c *fiber.Ctx) string {
+	return "ip:" + c.IP()
+}
+
+// RateLimitByUser counts requests per authenticated user, and per IP until
+// Protected has run.
+func RateLimitByUser(c *fiber.Ctx) string {
+	if user, ok := c.Locals("user").(*models.UserSafeDto); ok {
+		return "user:" + strconv.FormatUint(uint64(user.ID), 10)
+	}
+
+	return RateLimitByIP(c)
+}
+
+// RateLimitByAPIKey counts requests per X-API-Key header, and per IP when it
+// is missing. Keys are hashed so that they are not kept in the store.
+func RateLimitByAPIKey(c *fiber.Ctx) string {
+	key := c.Get("X-API-Key")
+	if key == "" {
+		return RateLimitByIP(c)
+	}
+
+	sum := sha256.Sum256([]byte(key))
+	return "key:" + hex.EncodeToString(sum[:16])
+}
+
+func quotaLimit(q config.Quota) ratelimit.Limit {
+	return ratelimit.Limit{Requests: q.Requests, Per: time.Duration(q.Per)}
+}
+
+// seconds rounds d up to whole seconds, as the headers require.
+func seconds(d time.Duration) string {
+	return strconv.Itoa(int(math.Ceil(d.Seconds())))
+}
+
+func maxDuration(a, b time.Duration) time.Duration {
+	if a > b {
+		return a
+	}
+	return b
+}
@@ -0,0 +1,144 @@
+package middlewares
+
+import (
+	"encoding/json"
+	"gateway/config"
+	"gateway/models"
+	"gateway/services/ratelimit"
+	"gateway/utils"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestRateLimitMiddleware(t *testing.T) {
+	Convey("func RateLimit(cfg RateLimitConfig) fiber.Handler", t, func() {
+		newApp := func(cfg RateLimitConfig) *fiber.App {
+			cfg.Store = ratelimit.NewMemoryStore()
+			app := fiber.New()
+			app.Get("/", func(c *fiber.Ctx) error {
+				if id := c.Get("X-User"); id != "" {
+					user := &models.UserSafeDto{}
+					user.ID = uint(len(id))
+					c.Locals("user", user)
+				}
+				return c.Next()
+			}, RateLimit(cfg), func(c *fiber.Ctx) error {
+				return c.SendString("ok")
+			})
+			return app
+		}
+
+		Convey("Given a limit of 2 requests per minute per IP", func() {
+			app := newApp(RateLimitConfig{Name: "test", Limit: ratelimit.Limit{Requests: 2, Per: time.Minute}})
+
+			Convey("When the limit is not reached", func() {
+				res, _ := app.Test(httptest.NewRequest("GET", "/", nil))
+
+				Convey("Then the quota is reported in the headers", func() {
+					So(res.StatusCode, ShouldEqual, fiber.StatusOK)
+					So(res.Header.Get("RateLimit-Limit"), ShouldEqual, "2")
+					So(res.Header.Get("RateLimit-Remaining"), ShouldEqual, "1")
+					So(res.Header.Get("RateLimit-Reset"), ShouldEqual, "30")
+				})
+			})
+
+			Convey("When the limit is exceeded", func() {
+				app.Test(httptest.NewRequest("GET", "/", nil))
+				app.Test(httptest.NewRequest("GET", "/", nil))
+				res, _ := app.Test(httptest.NewRequest("GET", "/", nil))
+				body := utils.DefaultResponseBody{}
+				json.NewDecoder(res.Body).Decode(&body)
+
+				Convey("Then it responds HTTP 429 with Retry-After", func() {
+					So(res.StatusCode, ShouldEqual, fiber.StatusTooManyRequests)
+					So(res.Header.Get("Retry-After"), ShouldEqual, "30")
+					So(body.Status, ShouldEqual, fiber.StatusTooManyRequests)
+					So(body.Message, ShouldEqual, fiber.ErrTooManyRequests.Message)
+				})
+			})
+		})
+
+		Convey("Given a limit of 1 request per minute per user", func() {
+			app := newApp(RateLimitConfig{Name: "test", Limit: ratelimit.Limit{Requests: 1, Per: time.Minute}, Key: RateLimitByUser})
+
+			Convey("When two users call from the same IP", func() {
+				first := httptest.NewRequest("GET", "/", nil)
+				first.Header.Set("X-User", "a")
+				second := httptest.NewRequest("GET", "/", nil)
+				second.Header.Set("X-User", "bb")
+				res1, _ := app.Test(first)
+				res2, _ := app.Test(second)
+
+				Convey("Then each has their own quota", func() {
+					So(res1.StatusCode, ShouldEqual, fiber.StatusOK)
+					So(res2.StatusCode, ShouldEqual, fiber.StatusOK)
+				})
+			})
+		})
+
+		Convey("Given no limit", func() {
+			app := newApp(RateLimitConfig{Name: "test"})
+
+			Convey("When a request is made", func() {
+				res, _ := app.Test(httptest.NewRequest("GET", "/", nil))
+
+				Convey("Then it passes without headers", func() {
+					So(res.StatusCode, ShouldEqual, fiber.StatusOK)
+					So(res.Header.Get("RateLimit-Limit"), ShouldBeBlank)
+				})
+			})
+		})
+	})
+
+	Convey("func GlobalRateLimit(cfg config.RateLimit) fiber.Handler", t, func() {
+		cfg := config.Default().RateLimit
+		cfg.Global = config.Quota{Requests: 2, Per: config.Duration(time.Minute)}
+		key := "global|ip:0.0.0.0"
+		state := func() (s ratelimit.State) {
+			ratelimit.Default.Update(key, time.Minute, func(current *ratelimit.State) { s = *current })
+			return
+		}
+		ratelimit.Default.Update(key, time.Minute, func(s *ratelimit.State) { *s = ratelimit.State{} })
+		serve := func() *http.Response {
+			app := fiber.New()
+			app.Get("/", GlobalRateLimit(cfg), func(c *fiber.Ctx) error {
+				return c.SendString("ok")
+			})
+			res, _ := app.Test(httptest.NewRequest("GET", "/", nil))
+			return res
+		}
+
+		Convey("Given the sliding-window algorithm", func() {
+			cfg.Algorithm = "sliding-window"
+
+			Convey("When a request is made", func() {
+				res := serve()
+
+				Convey("Then it is counted in a window", func() {
+					So(res.StatusCode, ShouldEqual, fiber.StatusOK)
+					So(state().Count, ShouldEqual, 1)
+					So(state().Tokens, ShouldBeZeroValue)
+				})
+			})
+		})
+
+		Convey("Given the token-bucket algorithm", func() {
+			cfg.Algorithm = "token-bucket"
+
+			Convey("When a request is made", func() {
+				res := serve()
+
+				Convey("Then it takes a token from a bucket", func() {
+					So(res.StatusCode, ShouldEqual, fiber.StatusOK)
+					So(state().Count, ShouldBeZeroValue)
+					So(state().Tokens, ShouldEqual, 1)
+				})
+			})
+		})
+	})
+}
@@ -0,0 +1,33 @@
+package middlewares
+
+import (
+	"gateway/utils"
+	"regexp"
+
+	"github.com/gofiber/fiber/v2"
+	fiberutils "github.com/gofiber/fiber/v2/utils"
+)
+
+// validRequestID keeps IDs sent by clients short and free of characters that
+// could forge log lines or headers.
+var validRequestID = regexp.MustCompile(`^[A-Za-z0-9._:-]{1,128}$`)
+
+// RequestID gives every request an ID, the X-Request-ID header sent by the
+// client when it is valid or a new UUID otherwise. The ID is stored under
+// utils.RequestIDKey and sent back in the X-Request-ID response header. Mount
+// it before anything else.
+func RequestID() fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		id := c.Get(fiber.HeaderXRequestID)
+		if validRequestID.MatchString(id) {
+			// Fiber reuses the bytes behind c.Get(); the ID outlives them.
+			id = fiberutils.CopyString(id)
+		} else {
+			id = fiberutils.UUIDv4()
+		}
+		c.Locals(utils.RequestIDKey, id)
+		c.Set(fiber.HeaderXRequestID, id)
+
+		return c.Next()
+	}
+}
@@ -0,0 +1,68 @@
+package middlewares
+
+import (
+	"encoding/json"
+	"gateway/utils"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gofiber/fiber/v2"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestRequestIDMiddleware(t *testing.T) {
+	Convey("func RequestID() fiber.Handler", t, func() {
+		app := fiber.New()
+		app.Use(RequestID())
+		app.Get("/", func(c *fiber.Ctx) error {
+			return utils.JSONStatus(c, fiber.StatusTeapot, "Short and stout", nil)
+		})
+		send := func(id string) (string, utils.DefaultResponseBody) {
+			req := httptest.NewRequest("GET", "/", nil)
+			if id != "" {
+				req.Header.Set(fiber.HeaderXRequestID, id)
+			}
+			res, _ := app.Test(req)
+			body := utils.DefaultResponseBody{}
+			json.NewDecoder(res.Body).Decode(&body)
+			return res.Header.Get(fiber.HeaderXRequestID), body
+		}
+
+		Convey("Given a request without ID", func() {
+			Convey("When it is served", func() {
+				header, body := send("")
+				other, _ := send("")
+
+				Convey("Then a new ID is sent back in the header and the body", func() {
+					So(len(header), ShouldEqual, 36)
+					So(body.RequestID, ShouldEqual, header)
+					So(other, ShouldNotEqual, header)
+				})
+			})
+		})
+
+		Convey("Given a request with a valid ID", func() {
+			Convey("When it is served", func() {
+				header, body := send("client-42:retry.1")
+
+				Convey("Then the ID is kept", func() {
+					So(header, ShouldEqual, "client-42:retry.1")
+					So(body.RequestID, ShouldEqual, "client-42:retry.1")
+				})
+			})
+		})
+
+		Convey("Given a request with an invalid ID", func() {
+			Convey("When it is served", func() {
+				spaced, _ := send("drop table")
+				long, _ := send(strings.Repeat("a", 129))
+
+				Convey("Then it is replaced", func() {
+					So(len(spaced), ShouldEqual, 36)
+					So(len(long), ShouldEqual, 36)
+				})
+			})
+		})
+	})
+}
@@ -21,11 +21,25 @@ type requestBodyParser interface {
 	interfaces.FiberStatusSetter
 }
 
-func ValidateBodyFnFactory(f bodyBuilder) fiber.Handler {
-	body := f()
+type requestQueryParser interface {
+	interfaces.FiberQueryParser
+	interfaces.FiberJSONSender
+	interfaces.FiberLocalsGetterSetter
+	interfaces.FiberNextRunner
+	interfaces.FiberStatusSetter
+}
+
+type validationErrorSender interface {
+	interfaces.FiberJSONSender
+	interfaces.FiberStatusSetter
+}
 
+// ValidateBodyFnFactory puts the body of the request in Locals as "body",
+// parsed into a value f builds for every request so that fields left out
+// never keep the values of an earlier request.
+func ValidateBodyFnFactory(f bodyBuilder) fiber.Handler {
 	return func(c *fiber.Ctx) error {
-		return validateBody(c, body)
+		return validateBody(c, f())
 	}
 }
 
@@ -35,14 +49,43 @@ func validateBody(c requestBodyParser, body interface{}) error {
 	}
 
 	if err := validate.Struct(body); err != nil {
-		m := fiber.Map{}
-		for _, err := range err.(validator.ValidationErrors) {
-			m[strings.ToLower(err.Field())] = err.Tag()
-		}
-		return utils.JSONStatus(c, fiber.StatusBadRequest, fiber.ErrBadRequest.Message, m)
+		return validationFailed(c, err)
 	}
 
 	c.Locals("body", body)
 
 	return c.Next()
 }
+
+// ValidateQueryFnFactory puts the query string of the request in Locals as
+// "query", parsed into a value f builds for every request so that parameters
+// left out never keep the values of an earlier request.
+func ValidateQueryFnFactory(f bodyBuilder) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		return validateQuery(c, f())
+	}
+}
+
+func validateQuery(c requestQueryParser, query interface{}) error {
+	if err := c.QueryParser(query); err != nil {
+		return utils.JSONStatus(c, fiber.StatusBadRequest, fiber.ErrBadRequest.Message, nil)
+	}
+
+	if err := validate.Struct(query); err != nil {
+		return validationFailed(c, err)
+	}
+
+	c.Locals("query", query)
+
+	return c.Next()
+}
+
+// validationFailed responds with the validation tag each invalid field failed.
+func validationFailed(c validationErrorSender, err error) error {
+	m := fiber.Map{}
+	for _, err := range err.(validator.ValidationErrors) {
+		m[strings.ToLower(err.Field())] = err.Tag()
+	}
+
+	return utils.JSONStatus(c, fiber.StatusBadRequest, fiber.ErrBadRequest.Message, m)
+}
@@ -4,6 +4,9 @@ import (
 	"errors"
 	"gateway/models"
 	"gateway/utils"
+	"net/http"
+	"net/http/httptest"
+	"strings"
 	"testing"
 
 	"github.com/gofiber/fiber/v2"
@@ -17,12 +20,37 @@ func TestValidatorMiddlewares(t *testing.T) {
 				bodyBuilderSpy := BodyBuilderFnSpy{}
 				handler := ValidateBodyFnFactory(bodyBuilderSpy.run)
 
-				Convey("Then the bodyBuilder is called once", func() {
-					So(bodyBuilderSpy.Calls, ShouldEqual, 1)
+				Convey("Then it returns fiber.Handler", func() {
+					So(handler, ShouldHaveSameTypeAs, func(c *fiber.Ctx) error { return nil })
 				})
 
-				Convey("And it returns fiber.Handler", func() {
-					So(handler, ShouldHaveSameTypeAs, func(c *fiber.Ctx) error { return nil })
+				Convey("Then the bodyBuilder is called once for every request", func() {
+					So(bodyBuilderSpy.Calls, ShouldEqual, 0)
+
+					app := fiber.New()
+					app.Post("/", handler, func(c *fiber.Ctx) error {
+						return c.JSON(c.Locals("body"))
+					})
+					first, _ := app.Test(jsonRequest(`{"id":1}`))
+					second, _ := app.Test(jsonRequest(`{"id":2}`))
+
+					So(bodyBuilderSpy.Calls, ShouldEqual, 2)
+					So(first.StatusCode, ShouldEqual, fiber.StatusOK)
+					So(second.StatusCode, ShouldEqual, fiber.StatusOK)
+				})
+
+				Convey("Then fields left out of a request are not kept from an earlier one", func() {
+					var seen []*bodyMock
+					app := fiber.New()
+					app.Post("/", ValidateBodyFnFactory(func() interface{} { return new(bodyMock) }), func(c *fiber.Ctx) error {
+						seen = append(seen, c.Locals("body").(*bodyMock))
+						return nil
+					})
+					app.Test(jsonRequest(`{"id":1}`))
+					res, _ := app.Test(jsonRequest(`{}`))
+
+					So(len(seen), ShouldEqual, 1)
+					So(res.StatusCode, ShouldEqual, fiber.StatusBadRequest)
 				})
 			})
 		})
@@ -112,6 +140,47 @@ func TestValidatorMiddlewares(t *testing.T) {
 	})
 }
 
+func TestQueryValidatorMiddleware(t *testing.T) {
+	Convey("func validateQuery(c requestQueryParser, query interface{}) error", t, func() {
+		Convey("Given everything is normal", func() {
+			Convey("When the function is called", func() {
+				query := bodyMock{Id: 1}
+				c := new(validatorContextMock)
+				validateQuery(c, query)
+
+				Convey("Then it puts the query in Locals and calls for the next handler", func() {
+					So(len(c.queryParserCalls), ShouldEqual, 1)
+					So(len(c.localsCalls), ShouldEqual, 1)
+					So(c.localsCalls[0].Params[0], ShouldEqual, "query")
+					So(c.localsCalls[0].Params[1], ShouldResemble, query)
+					So(len(c.nextCalls), ShouldEqual, 1)
+				})
+			})
+		})
+
+		Convey("Given query is invalid", func() {
+			Convey("When the function is called", func() {
+				c := new(validatorContextMock)
+				validateQuery(c, bodyMock{Id: 0})
+
+				Convey("Then it sends HTTP 400 error naming the field", func() {
+					So(len(c.nextCalls), ShouldEqual, 0)
+					So(c.statusCalls[0].Params[0], ShouldEqual, fiber.StatusBadRequest)
+					jsonBody := c.jsonCalls[0].Params[0].(utils.DefaultResponseBody)
+					So(jsonBody.Data, ShouldResemble, fiber.Map{"id": "gte"})
+				})
+			})
+		})
+	})
+}
+
+func jsonRequest(body string) *http.Request {
+	req := httptest.NewRequest("POST", "/", strings.NewReader(body))
+	req.Header.Set("Content-Type", "application/json")
+
+	return req
+}
+
 type BodyBuilderFnSpy struct {
 	Calls int
 }
@@ -126,11 +195,12 @@ type bodyMock struct {
 }
 
 type validatorContextMock struct {
-	bodyParserCalls []*models.FnCallData
-	jsonCalls       []*models.FnCallData
-	localsCalls     []*models.FnCallData
-	nextCalls       []*models.FnCallData
-	statusCalls     []*models.FnCallData
+	bodyParserCalls  []*models.FnCallData
+	queryParserCalls []*models.FnCallData
+	jsonCalls        []*models.FnCallData
+	localsCalls      []*models.FnCallData
+	nextCalls        []*models.FnCallData
+	statusCalls      []*models.FnCallData
 }
 
 func (c *validatorContextMock) BodyParser(o interface{}) error {
@@ -140,6 +210,13 @@ func (c *validatorContextMock) BodyParser(o interface{}) error {
 	return nil
 }
 
+func (c *validatorContextMock) QueryParser(o interface{}) error {
+	d := new(models.FnCallData).SetParams(o).SetReturns(nil)
+	c.queryParserCalls = append(c.queryParserCalls, d)
+
+	return nil
+}
+
 func (c *validatorContextMock) JSON(o interface{}) error {
 	d := new(models.FnCallData).SetParams(o).SetReturns(nil)
 	c.jsonCalls = append(c.jsonCalls, d)
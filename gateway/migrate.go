@@ -0,0 +1,122 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"gateway/config"
+	"gateway/services/db"
+	"gateway/services/db/migrations"
+	"gateway/services/logging"
+	"os"
+	"strconv"
+	"text/tabwriter"
+
+	"go.uber.org/zap"
+)
+
+const migrateUsage = `Usage: gateway migrate [flags] <command>
+
+Commands:
+  up        apply every pending migration
+  down [n]  roll back the last n migrations (default 1)
+  status    list migrations and when they were applied`
+
+func runMigrate(args []string) {
+	cfg, rest, err := config.LoadMigrate("gateway migrate", args)
+	if err == nil {
+		err = logging.Init(cfg.Logging)
+	}
+	if err != nil {
+		fatal("Invalid configuration", err)
+	}
+	if len(rest) == 0 {
+		fmt.Fprintln(os.Stderr, migrateUsage)
+		os.Exit(2)
+	}
+
+	if err := db.InitDB(cfg.Database); err != nil {
+		fatal("Database unavailable", err)
+	}
+	defer db.Close()
+
+	switch rest[0] {
+	case "up":
+		if err := migrateUp(); err != nil {
+			fatal("Migration failed", err)
+		}
+	case "down":
+		steps := 1
+		if len(rest) > 1 {
+			if steps, err = strconv.Atoi(rest[1]); err != nil || steps < 1 {
+				fatal("Invalid number of steps", fmt.Errorf("%q is not a positive integer", rest[1]))
+			}
+		}
+		migrateDown(steps)
+	case "status":
+		migrateStatus()
+	default:
+		fmt.Fprintln(os.Stderr, migrateUsage)
+		os.Exit(2)
+	}
+}
+
+// fatal ends the migrate command, which serves no requests.
+func fatal(msg string, err error) {
+	logging.Named("migrate").Fatal(msg, zap.Error(err))
+}
+
+func newMigrator() (*db.Migrator, error) {
+	return db.NewMigrator(db.Conn, migrations.All())
+}
+
+func migrateUp() error {
+	m, err := newMigrator()
+	if err != nil {
+		return err
+	}
+
+	applied, err := m.Up()
+	for _, m := range applied {
+		logging.Named("migrate").Info("Applied migration", zap.Uint("version", m.Version), zap.String("name", m.Name))
+	}
+
+	return err
+}
+
+func migrateDown(steps int) {
+	m, err := newMigrator()
+	if err != nil {
+		fatal("Migration failed", err)
+	}
+
+	rolledBack, err := m.Down(steps)
+	for _, m := range rolledBack {
+		logging.Named("migrate").Info("Rolled back migration", zap.Uint("version", m.Version), zap.String("name", m.Name))
+	}
+	if err != nil {
+		fatal("Rollback failed", err)
+	}
+}
+
+func migrateStatus() {
+	m, err := newMigrator()
+	if err != nil {
+		fatal("Migration failed", err)
+	}
+
+	statuses, err := m.Status(context.Background())
+	if err != nil {
+		fatal("Failed to read migrations", err)
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "VERSION\tNAME\tAPPLIED AT")
+	for _, s := range statuses {
+		appliedAt := "pending"
+		if s.AppliedAt != nil {
+			appliedAt = s.AppliedAt.Format("2006-01-02 15:04:05 MST")
+		}
+		fmt.Fprintf(w, "%d\t%s\t%s\n", s.Version, s.Name, appliedAt)
+	}
+	w.Flush()
+}
@@ -0,0 +1,114 @@
+package models
+
+import (
+	"database/sql/driver"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// Actions recorded in the audit trail.
+const (
+	AuditLogin             = "user.login"
+	AuditUserCreate        = "user.create"
+	AuditUserUpdate        = "user.update"
+	AuditRoleAssign        = "user.role.assign"
+	AuditRoleUnassign      = "user.role.unassign"
+	AuditSessionsRevoke    = "user.sessions.revoke"
+	AuditTokenRevoke       = "token.revoke"
+	AuditTokenFamilyRevoke = "token.family.revoke"
+	AuditRoleCreate        = "role.create"
+	AuditRoleUpdate        = "role.update"
+	AuditRoleDelete        = "role.delete"
+	AuditPermissionGrant   = "role.permission.grant"
+	AuditPermissionRevoke  = "role.permission.revoke"
+)
+
+// Kinds of records audit events target.
+const (
+	AuditTargetUser = "user"
+	AuditTargetRole = "role"
+)
+
+var ErrAuditAppendOnly = errors.New("Audit events cannot be changed")
+
+// AuditEvent records who did what to which record. Events are only ever
+// appended: updating or deleting one fails.
+type AuditEvent struct {
+	ID        uint      `gorm:"primarykey" json:"id"`
+	CreatedAt time.Time `gorm:"not null;index" json:"createdAt"`
+	Action    string    `gorm:"not null;index" json:"action"`
+	Success   bool      `gorm:"not null" json:"success"`
+	// The actor is nil for anonymous requests and the gateway itself.
+	ActorID    *uint  `gorm:"index" json:"actorId"`
+	ActorName  string `gorm:"not null;default:''" json:"actorName"`
+	TargetType string `gorm:"not null;default:''" json:"targetType"`
+	TargetID   *uint  `gorm:"index" json:"targetId"`
+	// TargetName is the username or role code, kept once the record is gone.
+	TargetName string       `gorm:"not null;default:''" json:"targetName"`
+	IP         string       `gorm:"not null;default:''" json:"ip"`
+	RequestID  string       `gorm:"not null;default:''" json:"requestId"`
+	Reason     string       `gorm:"not null;default:''" json:"reason,omitempty"`
+	Changes    AuditChanges `gorm:"type:text" json:"changes,omitempty"`
+}
+
+func (AuditEvent) BeforeUpdate(*gorm.DB) error { return ErrAuditAppendOnly }
+
+func (AuditEvent) BeforeDelete(*gorm.DB) error { return ErrAuditAppendOnly }
+
+// AuditChanges maps the fields an action changed to their values. Secrets
+// such as passwords are listed with neither value.
+type AuditChanges map[string]AuditChange
+
+// Value stores the changes as JSON.
+func (c AuditChanges) Value() (driver.Value, error) {
+	if len(c) == 0 {
+		return nil, nil
+	}
+	b, err := json.Marshal(c)
+
+	return string(b), err
+}
+
+func (c *AuditChanges) Scan(value interface{}) error {
+	switch v := value.(type) {
+	case nil:
+		*c = nil
+		return nil
+	case string:
+		return json.Unmarshal([]byte(v), c)
+	case []byte:
+		return json.Unmarshal(v, c)
+	default:
+		return fmt.Errorf("Cannot scan %T into AuditChanges", value)
+	}
+}
+
+type AuditChange struct {
+	From interface{} `json:"from,omitempty"`
+	To   interface{} `json:"to,omitempty"`
+}
+
+// AuditQueryDto filters the audit trail. Since and Until are RFC 3339 times.
+type AuditQueryDto struct {
+	Action     string `query:"action"`
+	ActorID    uint   `query:"actorId"`
+	TargetType string `query:"targetType"`
+	TargetID   uint   `query:"targetId"`
+	Success    *bool  `query:"success"`
+	Since      string `validate:"omitempty,datetime=2006-01-02T15:04:05Z07:00" query:"since"`
+	Until      string `validate:"omitempty,datetime=2006-01-02T15:04:05Z07:00" query:"until"`
+	Page       int    `validate:"min=0" query:"page"`
+	PageSize   int    `validate:"min=0,max=200" query:"pageSize"`
+}
+
+// AuditPage is one page of the audit trail, newest events first.
+type AuditPage struct {
+	Events   []AuditEvent `json:"events"`
+	Total    int64        `json:"total"`
+	Page     int          `json:"page"`
+	PageSize int          `json:"pageSize"`
+}
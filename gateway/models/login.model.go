@@ -1,10 +1,25 @@
 package models
 
+import "time"
+
+// LoginAttempt counts the failed passwords given for a username since its
+// last login, whether or not a user has that name. Attempts are refused until
+// LockedUntil.
+type LoginAttempt struct {
+	Username    string `gorm:"primaryKey"`
+	FailedCount int    `gorm:"not null;default:0"`
+	LockedUntil *time.Time
+	UpdatedAt   time.Time
+}
+
 type LoginDto struct {
 	Username string `json:"username" validate:"required"`
 	Password string `json:"password" validate:"required"`
+	DeviceID string `json:"deviceId" validate:"max=100"`
 }
 
 type LoginResponseDto struct {
-	AccessToken string `json:"accessToken"`
+	AccessToken  string `json:"accessToken"`
+	RefreshToken string `json:"refreshToken"`
+	ExpiresIn    int64  `json:"expiresIn"`
 }
@@ -0,0 +1,25 @@
+package models
+
+// Permissions the gateway itself checks. They are seeded and granted to the
+// admin role; route tables may require any other code once it is created.
+const (
+	PermissionUsersRead   = "users:read"
+	PermissionUsersWrite  = "users:write"
+	PermissionUsersAdmin  = "users:admin"
+	PermissionRolesRead   = "roles:read"
+	PermissionRolesWrite  = "roles:write"
+	PermissionRoutesRead  = "routes:read"
+	PermissionRoutesWrite = "routes:write"
+	PermissionAuditRead   = "audit:read"
+)
+
+type Permission struct {
+	Model
+	Code        string `gorm:"uniqueIndex;not null" json:"code"`
+	Description string `json:"description"`
+}
+
+type CreatePermissionDto struct {
+	Code        string `validate:"required,min=3,max=100,excludesall= " json:"code"`
+	Description string `validate:"max=255" json:"description"`
+}
@@ -1,6 +1,18 @@
 package models
 
+// AdminRoleCode is the role seeded with every permission the gateway checks.
+const AdminRoleCode = "admin"
+
 type Role struct {
 	Model
-	Code string `gorm:"unique,uniqueIndex,not null" json:"code"`
+	Code        string       `gorm:"uniqueIndex;not null" json:"code"`
+	Permissions []Permission `gorm:"many2many:role_permissions" json:"permissions,omitempty"`
+}
+
+type CreateRoleDto struct {
+	Code string `validate:"required,min=2,max=50,excludesall= " json:"code"`
+}
+
+type UpdateRoleDto struct {
+	Code string `validate:"required,min=2,max=50,excludesall= " json:"code"`
 }
@@ -0,0 +1,32 @@
+package models
+
+import "time"
+
+// RefreshToken is stored hashed. Every rotation creates a new token in the
+// same family; UsedAt marks the ones already exchanged.
+type RefreshToken struct {
+	Model
+	UserID    uint      `gorm:"not null;index"`
+	FamilyID  string    `gorm:"not null;index"`
+	TokenHash string    `gorm:"not null;uniqueIndex"`
+	DeviceID  string    `gorm:"not null;default:''"`
+	ExpiresAt time.Time `gorm:"not null"`
+	UsedAt    *time.Time
+	RevokedAt *time.Time
+}
+
+type RefreshTokenDto struct {
+	RefreshToken string `validate:"required" json:"refreshToken"`
+}
+
+// RevokedToken blocks an access token by its jti until it expires anyway.
+type RevokedToken struct {
+	Model
+	JTI       string    `gorm:"not null;uniqueIndex"`
+	UserID    uint      `gorm:"not null;index"`
+	ExpiresAt time.Time `gorm:"not null;index"`
+}
+
+type LogoutDto struct {
+	RefreshToken string `json:"refreshToken"`
+}
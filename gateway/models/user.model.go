@@ -1,5 +1,10 @@
 package models
 
+import (
+	"sort"
+	"time"
+)
+
 type User struct {
 	Model
 	Username string `gorm:"unique;not null;uniqueIndex"`
@@ -7,6 +12,12 @@ type User struct {
 	Email    string `gorm:"unique;not null"`
 	IsActive bool   `gorm:"not null;default:true"`
 	Roles    []Role `gorm:"many2many:user_roles"`
+	// Access tokens issued before either of these are rejected.
+	PasswordChangedAt *time.Time
+	SessionsRevokedAt *time.Time
+	LastLoginAt       *time.Time
+	// LoginAttempt is nil while the user has no failed password to count.
+	LoginAttempt *LoginAttempt `gorm:"foreignKey:Username;references:Username"`
 }
 
 type UserSafeDto struct {
@@ -15,6 +26,12 @@ type UserSafeDto struct {
 	Email    string `validate:"required" json:"email"`
 	IsActive bool   `json:"isActive"`
 	Roles    []Role `json:"roles"`
+	// Permissions granted by all of the roles.
+	Permissions []string   `json:"permissions"`
+	LastLoginAt *time.Time `json:"lastLoginAt"`
+	// Failed passwords in a row since the last login.
+	FailedLoginCount int        `json:"failedLoginCount"`
+	LockedUntil      *time.Time `json:"lockedUntil"`
 }
 
 type CreateUserDto struct {
@@ -25,19 +42,82 @@ type CreateUserDto struct {
 }
 
 type UpdateUserDto struct {
-	Password       string `validate:"min=5"`
-	RepeatPassword string `validate:"required_with:Password,eqfield=Password"`
-	Email          string `validate:"email"`
-	IsActive       bool
-	Roles          []uint
+	Password       string `validate:"omitempty,min=5" json:"password"`
+	RepeatPassword string `validate:"required_with=Password,eqfield=Password" json:"repeatPassword"`
+	Email          string `validate:"omitempty,email" json:"email"`
+	// IsActive is left as is when omitted.
+	IsActive *bool  `json:"isActive"`
+	Roles    []uint `json:"roles"`
+
+	// CurrentPassword confirms that users change their own password.
+	CurrentPassword string `json:"currentPassword"`
 }
 
 func ToUserSafeDto(user User) *UserSafeDto {
+	var failedCount int
+	var lockedUntil *time.Time
+	if user.LoginAttempt != nil {
+		failedCount = user.LoginAttempt.FailedCount
+		lockedUntil = user.LoginAttempt.LockedUntil
+	}
+
 	return &UserSafeDto{
-		Username: user.Username,
-		Email:    user.Email,
-		IsActive: user.IsActive,
-		Roles:    user.Roles,
-		Model:    user.Model,
+		Username:         user.Username,
+		Email:            user.Email,
+		IsActive:         user.IsActive,
+		Roles:            user.Roles,
+		Permissions:      user.PermissionCodes(),
+		LastLoginAt:      user.LastLoginAt,
+		FailedLoginCount: failedCount,
+		LockedUntil:      lockedUntil,
+		Model:            user.Model,
+	}
+}
+
+// PermissionCodes lists the permissions of the user's roles, which must have
+// been loaded along with their permissions.
+func (u User) PermissionCodes() []string {
+	seen := map[string]bool{}
+	codes := []string{}
+	for _, r := range u.Roles {
+		for _, p := range r.Permissions {
+			if !seen[p.Code] {
+				seen[p.Code] = true
+				codes = append(codes, p.Code)
+			}
+		}
+	}
+	sort.Strings(codes)
+
+	return codes
+}
+
+func (u User) HasRole(code string) bool {
+	for _, r := range u.Roles {
+		if r.Code == code {
+			return true
+		}
+	}
+
+	return false
+}
+
+func (u UserSafeDto) HasRole(code string) bool {
+	for _, r := range u.Roles {
+		if r.Code == code {
+			return true
+		}
 	}
+
+	return false
+}
+
+func (u UserSafeDto) HasPermission(code string) bool {
+	for _, p := range u.Permissions {
+		if p == code {
+			return true
+		}
+	}
+
+	return false
 }
@@ -0,0 +1,273 @@
+package accesslog
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"gateway/config"
+	"gateway/models"
+	"gateway/services/metrics"
+	"gateway/utils"
+	"io"
+	"math/rand"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"gopkg.in/natefinch/lumberjack.v2"
+)
+
+// Redacted replaces the values that must not be logged.
+const Redacted = "[REDACTED]"
+
+// maxBody is the size of the largest request body logged.
+const maxBody = 64 << 10
+
+// Logger writes access log lines, one Write per request.
+type Logger struct {
+	format      string
+	sampleRatio float64
+	body        bool
+	headers     map[string]bool
+	redacted    map[string]bool
+	fields      map[string]bool
+
+	mu  sync.Mutex
+	out io.Writer
+}
+
+// New opens the output of cfg, so that a file that cannot be written stops
+// the gateway at boot rather than losing logs.
+func New(cfg config.AccessLog) (*Logger, error) {
+	l := &Logger{
+		format:      cfg.Format,
+		sampleRatio: cfg.SampleRatio,
+		body:        cfg.Body,
+		headers:     lowered(cfg.Headers),
+		redacted:    lowered(cfg.RedactHeaders),
+		fields:      lowered(cfg.RedactFields),
+		out:         os.Stdout,
+	}
+	if cfg.Output != "stdout" {
+		file := &lumberjack.Logger{
+			Filename:   cfg.Output,
+			MaxSize:    cfg.MaxSizeMB,
+			MaxBackups: cfg.MaxBackups,
+			MaxAge:     cfg.MaxAgeDays,
+			Compress:   cfg.Compress,
+		}
+		if _, err := file.Write(nil); err != nil {
+			return nil, fmt.Errorf("Failed to open the access log: %w", err)
+		}
+		l.out = file
+	}
+
+	return l, nil
+}
+
+func lowered(names []string) map[string]bool {
+	set := make(map[string]bool, len(names))
+	for _, name := range names {
+		set[strings.ToLower(name)] = true
+	}
+
+	return set
+}
+
+// Close closes the access log file, if any.
+func (l *Logger) Close() error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if closer, ok := l.out.(io.Closer); ok {
+		return closer.Close()
+	}
+	return nil
+}
+
+// Middleware logs every request once served. Mount it after the RequestID
+// middleware.
+func (l *Logger) Middleware() fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		started := time.Now()
+
+		err := c.Next()
+
+		route, status := metrics.Outcome(c, err)
+		if status < fiber.StatusInternalServerError && l.sampleRatio < 1 && rand.Float64() >= l.sampleRatio {
+			return err
+		}
+
+		var line []byte
+		switch l.format {
+		case "common":
+			line = []byte(l.common(c, status, started) + "\n")
+		case "combined":
+			line = []byte(fmt.Sprintf("%s \"%s\" \"%s\"\n", l.common(c, status, started), escape(c.Get(fiber.HeaderReferer)), escape(c.Get(fiber.HeaderUserAgent))))
+		default:
+			line = l.json(c, route, status, started)
+		}
+		l.mu.Lock()
+		l.out.Write(line)
+		l.mu.Unlock()
+
+		return err
+	}
+}
+
+// common formats the request in the Common Log Format.
+func (l *Logger) common(c *fiber.Ctx, status int, started time.Time) string {
+	user := username(c)
+	if user == "" {
+		user = "-"
+	}
+	size := "-"
+	if n := responseSize(c); n > 0 {
+		size = strconv.Itoa(n)
+	}
+
+	return fmt.Sprintf("%s - %s [%s] \"%s %s %s\" %d %s",
+		c.IP(), escape(user), started.Format("02/Jan/2006:15:04:05 -0700"),
+		c.Method(), escape(l.uri(c)), c.Request().Header.Protocol(), status, size)
+}
+
+type entry struct {
+	Time       string            `json:"time"`
+	RequestID  string            `json:"requestId,omitempty"`
+	RemoteAddr string            `json:"remoteAddr"`
+	User       string            `json:"user,omitempty"`
+	Method     string            `json:"method"`
+	URI        string            `json:"uri"`
+	Proto      string            `json:"proto"`
+	Route      string            `json:"route"`
+	Status     int               `json:"status"`
+	Bytes      int               `json:"bytes"`
+	Latency    float64           `json:"latency"`
+	Referer    string            `json:"referer,omitempty"`
+	UserAgent  string            `json:"userAgent,omitempty"`
+	Headers    map[string]string `json:"headers"`
+	Body       json.RawMessage   `json:"body,omitempty"`
+}
+
+func (l *Logger) json(c *fiber.Ctx, route string, status int, started time.Time) []byte {
+	e := entry{
+		Time:       started.Format(time.RFC3339Nano),
+		RequestID:  utils.RequestID(c),
+		RemoteAddr: c.IP(),
+		User:       username(c),
+		Method:     c.Method(),
+		URI:        l.uri(c),
+		Proto:      string(c.Request().Header.Protocol()),
+		Route:      route,
+		Status:     status,
+		Bytes:      responseSize(c),
+		Latency:    time.Since(started).Seconds(),
+		Referer:    c.Get(fiber.HeaderReferer),
+		UserAgent:  c.Get(fiber.HeaderUserAgent),
+		Headers:    map[string]string{},
+	}
+	c.Request().Header.VisitAll(func(k, v []byte) {
+		name := string(k)
+		switch {
+		case l.redacted[strings.ToLower(name)]:
+			e.Headers[name] = Redacted
+		case !l.headers[strings.ToLower(name)]:
+			// Left out of the log.
+		case e.Headers[name] != "":
+			e.Headers[name] += ", " + string(v)
+		default:
+			e.Headers[name] = string(v)
+		}
+	})
+	if l.body {
+		e.Body = l.requestBody(c)
+	}
+
+	line, _ := json.Marshal(e)
+	return append(line, '\n')
+}
+
+// requestBody is the JSON body of the request with its sensitive fields
+// redacted, or nothing for other and oversized bodies.
+func (l *Logger) requestBody(c *fiber.Ctx) json.RawMessage {
+	body := c.Body()
+	if len(body) == 0 || len(body) > maxBody || !bytes.HasPrefix(c.Request().Header.ContentType(), []byte(fiber.MIMEApplicationJSON)) {
+		return nil
+	}
+
+	var v interface{}
+	if json.Unmarshal(body, &v) != nil {
+		return nil
+	}
+	redacted, _ := json.Marshal(l.redact(v))
+
+	return redacted
+}
+
+func (l *Logger) redact(v interface{}) interface{} {
+	switch v := v.(type) {
+	case map[string]interface{}:
+		for k, field := range v {
+			if l.fields[strings.ToLower(k)] {
+				v[k] = Redacted
+			} else {
+				v[k] = l.redact(field)
+			}
+		}
+	case []interface{}:
+		for i := range v {
+			v[i] = l.redact(v[i])
+		}
+	}
+
+	return v
+}
+
+// uri is the request URI with the values of sensitive query parameters
+// redacted.
+func (l *Logger) uri(c *fiber.Ctx) string {
+	uri := string(c.Request().RequestURI())
+	path, query, ok := strings.Cut(uri, "?")
+	if !ok {
+		return uri
+	}
+
+	params := strings.Split(query, "&")
+	for i, param := range params {
+		key, _, _ := strings.Cut(param, "=")
+		if name, err := url.QueryUnescape(key); err == nil && l.fields[strings.ToLower(name)] {
+			params[i] = key + "=" + Redacted
+		}
+	}
+
+	return path + "?" + strings.Join(params, "&")
+}
+
+func username(c *fiber.Ctx) string {
+	if user, ok := c.Locals("user").(*models.UserSafeDto); ok {
+		return user.Username
+	}
+	return ""
+}
+
+// responseSize is the size of the response body, without reading the bodies
+// streamed from upstreams; -1 when unknown.
+func responseSize(c *fiber.Ctx) int {
+	res := c.Response()
+	if res.IsBodyStream() {
+		return res.Header.ContentLength()
+	}
+
+	return len(res.Body())
+}
+
+// escape keeps quotes and control characters sent by clients from breaking
+// the fields of a log line.
+func escape(s string) string {
+	quoted := strconv.Quote(s)
+	return quoted[1 : len(quoted)-1]
+}
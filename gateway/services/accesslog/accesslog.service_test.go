@@ -0,0 +1,162 @@
+package accesslog
+
+import (
+	"bytes"
+	"encoding/json"
+	"gateway/config"
+	"gateway/models"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/gofiber/fiber/v2"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+// serve sends req through an app logging with cfg, and returns the lines
+// logged.
+func serve(cfg config.AccessLog, req *http.Request) []string {
+	l, _ := New(cfg)
+	buffer := &bytes.Buffer{}
+	l.out = buffer
+
+	app := fiber.New()
+	app.Use(l.Middleware())
+	app.Post("/v1/login", func(c *fiber.Ctx) error {
+		c.Locals("user", &models.UserSafeDto{Username: "alice"})
+		return c.SendString("welcome")
+	})
+	app.Get("/v1/broken", func(c *fiber.Ctx) error {
+		return fiber.ErrBadGateway
+	})
+	app.Test(req)
+
+	return strings.Split(strings.TrimSuffix(buffer.String(), "\n"), "\n")
+}
+
+func TestAccessLog(t *testing.T) {
+	Convey("func (l *Logger) Middleware() fiber.Handler", t, func() {
+		cfg := config.Default().AccessLog
+		login := func() *http.Request {
+			req := httptest.NewRequest("POST", "/v1/login?accessToken=secret&next=%2Fhome", strings.NewReader(`{"username":"alice","Password":"secret","devices":[{"refreshToken":"secret"}]}`))
+			req.Header.Set("Content-Type", "application/json")
+			req.Header.Set("Authorization", "Bearer secret")
+			req.Header.Set("Referer", "https://example.com/")
+			req.Header.Set("User-Agent", `curl "7.0"`)
+			req.Header.Set("X-API-Key", "secret")
+			req.Header.Set("X-Session", "secret")
+			return req
+		}
+
+		Convey("Given the common format", func() {
+			cfg.Format = "common"
+
+			Convey("When a request is served", func() {
+				lines := serve(cfg, login())
+
+				Convey("Then it logs one line with sensitive query parameters redacted", func() {
+					So(len(lines), ShouldEqual, 1)
+					So(lines[0], ShouldStartWith, "0.0.0.0 - alice [")
+					So(lines[0], ShouldEndWith, `] "POST /v1/login?accessToken=[REDACTED]&next=%2Fhome HTTP/1.1" 200 7`)
+				})
+			})
+		})
+
+		Convey("Given the combined format", func() {
+			cfg.Format = "combined"
+
+			Convey("When a request is served", func() {
+				lines := serve(cfg, login())
+
+				Convey("Then the referer and escaped user agent follow", func() {
+					So(lines[0], ShouldEndWith, ` 200 7 "https://example.com/" "curl \"7.0\""`)
+				})
+			})
+		})
+
+		Convey("Given the json format with bodies", func() {
+			cfg.Format = "json"
+			cfg.Body = true
+
+			Convey("When a request is served", func() {
+				lines := serve(cfg, login())
+				entry := map[string]interface{}{}
+				json.Unmarshal([]byte(lines[0]), &entry)
+
+				Convey("Then sensitive headers and body fields are redacted", func() {
+					So(entry["route"], ShouldEqual, "/v1/login")
+					So(entry["status"], ShouldEqual, 200)
+					So(entry["user"], ShouldEqual, "alice")
+					So(entry["headers"].(map[string]interface{})["Authorization"], ShouldEqual, Redacted)
+					So(entry["headers"].(map[string]interface{})["Referer"], ShouldEqual, "https://example.com/")
+					body := entry["body"].(map[string]interface{})
+					So(body["username"], ShouldEqual, "alice")
+					So(body["Password"], ShouldEqual, Redacted)
+					So(body["devices"].([]interface{})[0].(map[string]interface{})["refreshToken"], ShouldEqual, Redacted)
+					So(lines[0], ShouldNotContainSubstring, "secret")
+				})
+
+				Convey("Then only the listed headers are logged", func() {
+					headers := entry["headers"].(map[string]interface{})
+					So(headers["X-Api-Key"], ShouldEqual, Redacted)
+					So(headers["Content-Type"], ShouldEqual, "application/json")
+					So(headers, ShouldNotContainKey, "X-Session")
+				})
+			})
+		})
+
+		Convey("Given a sample ratio of 0", func() {
+			cfg.Format = "common"
+			cfg.SampleRatio = 0
+
+			Convey("When requests are served", func() {
+				served := serve(cfg, login())
+				failed := serve(cfg, httptest.NewRequest("GET", "/v1/broken", nil))
+
+				Convey("Then only server errors are logged", func() {
+					So(served, ShouldResemble, []string{""})
+					So(len(failed), ShouldEqual, 1)
+					So(failed[0], ShouldContainSubstring, `"GET /v1/broken HTTP/1.1" 502`)
+				})
+			})
+		})
+	})
+
+	Convey("func New(cfg config.AccessLog) (*Logger, error)", t, func() {
+		cfg := config.Default().AccessLog
+		cfg.Format = "common"
+
+		Convey("Given a file output", func() {
+			cfg.Output = filepath.Join(t.TempDir(), "access.log")
+
+			Convey("When requests are logged", func() {
+				l, err := New(cfg)
+				So(err, ShouldBeNil)
+				app := fiber.New()
+				app.Use(l.Middleware())
+				app.Test(httptest.NewRequest("GET", "/missing", nil))
+				So(l.Close(), ShouldBeNil)
+
+				Convey("Then they are appended to the file", func() {
+					logged, _ := os.ReadFile(cfg.Output)
+					So(string(logged), ShouldContainSubstring, `"GET /missing HTTP/1.1" 404`)
+				})
+			})
+		})
+
+		Convey("Given a file that cannot be created", func() {
+			cfg.Output = filepath.Join(t.TempDir(), "missing", "\x00", "access.log")
+
+			Convey("When New is called", func() {
+				_, err := New(cfg)
+
+				Convey("Then it returns an error", func() {
+					So(err, ShouldNotBeNil)
+				})
+			})
+		})
+	})
+}
@@ -0,0 +1,132 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"gateway/models"
+	"gateway/services/db"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+const defaultAuditPageSize = 50
+
+type auditActorKey struct{}
+
+// AuditActor is who the events recorded for a request are attributed to. The
+// user is zero for anonymous requests.
+type AuditActor struct {
+	UserID    uint
+	Username  string
+	IP        string
+	RequestID string
+}
+
+// WithAuditActor returns a copy of ctx whose audit events are attributed to
+// actor.
+func WithAuditActor(ctx context.Context, actor AuditActor) context.Context {
+	return context.WithValue(ctx, auditActorKey{}, actor)
+}
+
+// Audit appends event to the audit trail, attributed to the actor of ctx
+// unless it names its own. The action it records already happened, so a
+// failure is logged rather than returned.
+func Audit(ctx context.Context, event models.AuditEvent) {
+	actor, _ := ctx.Value(auditActorKey{}).(AuditActor)
+	if event.ActorID == nil && actor.UserID != 0 {
+		event.ActorID = &actor.UserID
+		event.ActorName = actor.Username
+	}
+	event.IP = actor.IP
+	event.RequestID = actor.RequestID
+
+	if result := db.Conn.WithContext(ctx).Create(&event); result.Error != nil {
+		logger(ctx).Error("Failed to record audit event", zap.String("action", event.Action), zap.Error(result.Error))
+	}
+}
+
+// FindAuditEvents returns the page of events matching query, newest first.
+func FindAuditEvents(ctx context.Context, query models.AuditQueryDto) (*models.AuditPage, error) {
+	page := models.AuditPage{Page: query.Page, PageSize: query.PageSize}
+	if page.Page < 1 {
+		page.Page = 1
+	}
+	if page.PageSize < 1 {
+		page.PageSize = defaultAuditPageSize
+	}
+
+	tx := db.Conn.WithContext(ctx).Model(&models.AuditEvent{})
+	if query.Action != "" {
+		tx = tx.Where("action = ?", query.Action)
+	}
+	if query.ActorID != 0 {
+		tx = tx.Where("actor_id = ?", query.ActorID)
+	}
+	if query.TargetType != "" {
+		tx = tx.Where("target_type = ?", query.TargetType)
+	}
+	if query.TargetID != 0 {
+		tx = tx.Where("target_id = ?", query.TargetID)
+	}
+	if query.Success != nil {
+		tx = tx.Where("success = ?", *query.Success)
+	}
+	if since, err := time.Parse(time.RFC3339, query.Since); err == nil {
+		tx = tx.Where("created_at >= ?", since)
+	}
+	if until, err := time.Parse(time.RFC3339, query.Until); err == nil {
+		tx = tx.Where("created_at < ?", until)
+	}
+
+	if err := tx.Count(&page.Total).Error; err != nil {
+		logDBError(ctx, err)
+		return nil, errors.New("Error when reading database")
+	}
+	page.Events = []models.AuditEvent{}
+	result := tx.Order("id DESC").Limit(page.PageSize).Offset((page.Page - 1) * page.PageSize).Find(&page.Events)
+	if result.Error != nil {
+		logDBError(ctx, result.Error)
+		return nil, errors.New("Error when reading database")
+	}
+
+	return &page, nil
+}
+
+// userChanges lists the fields of a user that differ between before and
+// after, with the password hash left out.
+func userChanges(before, after models.User) models.AuditChanges {
+	changes := models.AuditChanges{}
+	if before.Username != after.Username {
+		changes["username"] = models.AuditChange{From: before.Username, To: after.Username}
+	}
+	if before.Email != after.Email {
+		changes["email"] = models.AuditChange{From: before.Email, To: after.Email}
+	}
+	if before.IsActive != after.IsActive {
+		changes["isActive"] = models.AuditChange{From: before.IsActive, To: after.IsActive}
+	}
+	if before.Password != after.Password {
+		changes["password"] = models.AuditChange{}
+	}
+
+	return changes
+}
+
+func roleCodes(roles []models.Role) []string {
+	codes := make([]string, len(roles))
+	for i, r := range roles {
+		codes[i] = r.Code
+	}
+
+	return codes
+}
+
+func permissionCodes(permissions []models.Permission) []string {
+	codes := make([]string, len(permissions))
+	for i, p := range permissions {
+		codes[i] = p.Code
+	}
+
+	return codes
+}
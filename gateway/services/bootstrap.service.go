@@ -0,0 +1,48 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"gateway/config"
+	"gateway/models"
+	"gateway/services/db"
+
+	"go.uber.org/zap"
+)
+
+// BootstrapAdmin creates the first administrator when the database has no
+// users yet, so that everything else can be managed through the API. It does
+// nothing once any user exists. Its password is hashed with bcrypt at cost.
+func BootstrapAdmin(ctx context.Context, cfg config.Bootstrap, cost int) (*models.User, error) {
+	var count int64
+	if result := db.Conn.WithContext(ctx).Model(&models.User{}).Count(&count); result.Error != nil {
+		logDBError(ctx, result.Error)
+		return nil, errors.New("Error when reading database")
+	}
+	if count > 0 {
+		return nil, nil
+	}
+	if cfg.AdminUsername == "" {
+		logger(ctx).Warn("The database has no users; set GATEWAY_BOOTSTRAP_ADMIN_USERNAME, _PASSWORD and _EMAIL to create an administrator")
+		return nil, nil
+	}
+
+	// The migrations seed the admin role with its permissions; a role made
+	// up here would have none.
+	role, err := GetRoleByCode(ctx, models.AdminRoleCode)
+	if err != nil {
+		return nil, errors.New("The admin role is missing; apply the migrations before bootstrapping an administrator")
+	}
+
+	user, err := CreateUser(ctx, models.CreateUserDto{
+		Username: cfg.AdminUsername,
+		Password: cfg.AdminPassword,
+		Email:    cfg.AdminEmail,
+	}, cost)
+	if err != nil {
+		return nil, err
+	}
+
+	logger(ctx).Info("Created administrator", zap.String("username", user.Username))
+	return AssignRole(ctx, user.ID, role.ID)
+}
@@ -0,0 +1,100 @@
+package db
+
+import (
+	"errors"
+	"regexp"
+	"strings"
+
+	"github.com/jackc/pgconn"
+	"github.com/mattn/go-sqlite3"
+)
+
+type ConstraintKind int
+
+const (
+	UniqueViolation ConstraintKind = iota + 1
+	ForeignKeyViolation
+	NotNullViolation
+)
+
+// ConstraintError is a database constraint violation with the dialect
+// specific details reduced to the table and column involved.
+type ConstraintError struct {
+	Kind   ConstraintKind
+	Table  string
+	Column string
+	Err    error
+}
+
+func (e *ConstraintError) Error() string {
+	return e.Err.Error()
+}
+
+func (e *ConstraintError) Unwrap() error {
+	return e.Err
+}
+
+var (
+	// UNIQUE constraint failed: users.username
+	sqliteColumn = regexp.MustCompile(`constraint failed: (\w+)\.(\w+)`)
+	// Key (username)=(user) already exists.
+	postgresKey = regexp.MustCompile(`Key \(([^)]+)\)`)
+)
+
+// ClassifyError turns constraint violations from SQLite or PostgreSQL into a
+// *ConstraintError. Other errors are returned unchanged.
+func ClassifyError(err error) error {
+	var sqliteErr sqlite3.Error
+	if errors.As(err, &sqliteErr) {
+		kind := sqliteKinds[sqliteErr.ExtendedCode]
+		if kind == 0 {
+			return err
+		}
+
+		c := &ConstraintError{Kind: kind, Err: err}
+		if m := sqliteColumn.FindStringSubmatch(sqliteErr.Error()); m != nil {
+			c.Table, c.Column = m[1], m[2]
+		}
+		return c
+	}
+
+	var pgErr *pgconn.PgError
+	if errors.As(err, &pgErr) {
+		kind := postgresKinds[pgErr.Code]
+		if kind == 0 {
+			return err
+		}
+
+		c := &ConstraintError{Kind: kind, Table: pgErr.TableName, Column: pgErr.ColumnName, Err: err}
+		if m := postgresKey.FindStringSubmatch(pgErr.Detail); m != nil && c.Column == "" {
+			c.Column = strings.TrimSpace(strings.Split(m[1], ",")[0])
+		}
+		return c
+	}
+
+	return err
+}
+
+// IsUniqueViolation reports whether err violates a unique constraint on
+// column. An empty column matches any column.
+func IsUniqueViolation(err error, column string) bool {
+	var c *ConstraintError
+	if !errors.As(ClassifyError(err), &c) || c.Kind != UniqueViolation {
+		return false
+	}
+
+	return column == "" || c.Column == column
+}
+
+var sqliteKinds = map[sqlite3.ErrNoExtended]ConstraintKind{
+	sqlite3.ErrConstraintUnique:     UniqueViolation,
+	sqlite3.ErrConstraintPrimaryKey: UniqueViolation,
+	sqlite3.ErrConstraintForeignKey: ForeignKeyViolation,
+	sqlite3.ErrConstraintNotNull:    NotNullViolation,
+}
+
+var postgresKinds = map[string]ConstraintKind{
+	"23505": UniqueViolation,
+	"23503": ForeignKeyViolation,
+	"23502": NotNullViolation,
+}
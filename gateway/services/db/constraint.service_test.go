@@ -0,0 +1,84 @@
+package db
+
+import (
+	"errors"
+	"fmt"
+	"gateway/models"
+	"testing"
+
+	"github.com/jackc/pgconn"
+	. "github.com/smartystreets/goconvey/convey"
+	"gorm.io/driver/sqlite"
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+)
+
+func TestClassifyError(t *testing.T) {
+	Convey("func ClassifyError(err error) error", t, func() {
+		Convey("Given a unique violation from SQLite", func() {
+			conn, _ := gorm.Open(sqlite.Open("file::memory:"), &gorm.Config{Logger: logger.Default.LogMode(logger.Silent)})
+			conn.AutoMigrate(&models.User{})
+			conn.Create(&models.User{Username: "user", Password: "x", Email: "a@example.com"})
+			err := conn.Create(&models.User{Username: "other", Password: "x", Email: "a@example.com"}).Error
+
+			Convey("When the function is called", func() {
+				c := new(ConstraintError)
+				ok := errors.As(ClassifyError(err), &c)
+
+				Convey("Then the table and column are extracted", func() {
+					So(ok, ShouldBeTrue)
+					So(c.Kind, ShouldEqual, UniqueViolation)
+					So(c.Table, ShouldEqual, "users")
+					So(c.Column, ShouldEqual, "email")
+					So(IsUniqueViolation(err, "email"), ShouldBeTrue)
+					So(IsUniqueViolation(err, "username"), ShouldBeFalse)
+				})
+			})
+		})
+
+		Convey("Given a unique violation from PostgreSQL", func() {
+			err := fmt.Errorf("insert: %w", &pgconn.PgError{
+				Code:           "23505",
+				TableName:      "users",
+				ConstraintName: "idx_users_username",
+				Detail:         "Key (username)=(user) already exists.",
+			})
+
+			Convey("When the function is called", func() {
+				c := new(ConstraintError)
+				ok := errors.As(ClassifyError(err), &c)
+
+				Convey("Then the table and column are extracted", func() {
+					So(ok, ShouldBeTrue)
+					So(c.Kind, ShouldEqual, UniqueViolation)
+					So(c.Table, ShouldEqual, "users")
+					So(c.Column, ShouldEqual, "username")
+					So(IsUniqueViolation(err, "username"), ShouldBeTrue)
+				})
+			})
+		})
+
+		Convey("Given an error that is not a constraint violation", func() {
+			err := errors.New("connection refused")
+
+			Convey("When the function is called", func() {
+				Convey("Then it is returned unchanged", func() {
+					So(ClassifyError(err), ShouldEqual, err)
+					So(IsUniqueViolation(err, ""), ShouldBeFalse)
+				})
+			})
+		})
+	})
+
+	Convey("func IsPostgres(dsn string) bool", t, func() {
+		Convey("Given PostgreSQL and SQLite DSNs", func() {
+			Convey("Then only PostgreSQL ones are detected", func() {
+				So(IsPostgres("postgres://u:p@localhost/db"), ShouldBeTrue)
+				So(IsPostgres("postgresql://localhost/db"), ShouldBeTrue)
+				So(IsPostgres("host=localhost user=u dbname=db"), ShouldBeTrue)
+				So(IsPostgres("database.db"), ShouldBeFalse)
+				So(IsPostgres("file::memory:?cache=shared"), ShouldBeFalse)
+			})
+		})
+	})
+}
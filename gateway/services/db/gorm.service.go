@@ -1,9 +1,14 @@
 package db
 
 import (
-	"gateway/models"
-	"log"
+	"context"
+	"fmt"
+	"gateway/config"
+	"gateway/services/tracing"
+	"strings"
+	"time"
 
+	"gorm.io/driver/postgres"
 	"gorm.io/driver/sqlite"
 	"gorm.io/gorm"
 	"gorm.io/gorm/logger"
@@ -11,18 +16,62 @@ import (
 
 var Conn *gorm.DB
 
-func InitDB() {
+func InitDB(cfg config.Database) error {
 	var err error
-	Conn, err = gorm.Open(sqlite.Open("database.db"), &gorm.Config{
+	Conn, err = gorm.Open(Dialector(cfg.DSN), &gorm.Config{
 		Logger: logger.Default.LogMode(logger.Silent),
 	})
+	if err != nil {
+		return fmt.Errorf("Failed to connect database: %w", err)
+	}
+	if err := Conn.Use(tracing.GormPlugin()); err != nil {
+		return fmt.Errorf("Failed to trace database calls: %w", err)
+	}
+
+	sqlDB, err := Conn.DB()
+	if err != nil {
+		return fmt.Errorf("Failed to configure database pool: %w", err)
+	}
+	sqlDB.SetMaxOpenConns(cfg.MaxOpenConns)
+	sqlDB.SetMaxIdleConns(cfg.MaxIdleConns)
+	sqlDB.SetConnMaxLifetime(time.Duration(cfg.ConnMaxLifetime))
+	sqlDB.SetConnMaxIdleTime(time.Duration(cfg.ConnMaxIdleTime))
+
+	return nil
+}
 
+// Close closes every connection of the pool, once requests are done with it.
+func Close() error {
+	sqlDB, err := Conn.DB()
 	if err != nil {
-		log.Panic("Failed to connect database")
+		return err
 	}
 
-	Conn.AutoMigrate(
-		&models.User{},
-		&models.Role{},
-	)
+	return sqlDB.Close()
+}
+
+// Ping tells whether the database answers.
+func Ping(ctx context.Context) error {
+	sqlDB, err := Conn.DB()
+	if err != nil {
+		return err
+	}
+
+	return sqlDB.PingContext(ctx)
+}
+
+// Dialector picks the driver from the DSN: postgres:// URLs and key=value
+// connection strings go to PostgreSQL, anything else is a SQLite file.
+func Dialector(dsn string) gorm.Dialector {
+	if IsPostgres(dsn) {
+		return postgres.Open(dsn)
+	}
+
+	return sqlite.Open(dsn)
+}
+
+func IsPostgres(dsn string) bool {
+	return strings.HasPrefix(dsn, "postgres://") ||
+		strings.HasPrefix(dsn, "postgresql://") ||
+		strings.Contains(dsn, "host=")
 }
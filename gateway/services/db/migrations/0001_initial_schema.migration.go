@@ -0,0 +1,47 @@
+package migrations
+
+import (
+	"gateway/services/db"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// Snapshots of the models as they were when this migration was written, so
+// that later model changes cannot alter it.
+type user0001 struct {
+	ID        uint `gorm:"primarykey,not null,autoIncrement"`
+	CreatedAt time.Time
+	UpdatedAt time.Time
+	DeletedAt *gorm.DeletedAt `gorm:"index"`
+	Username  string          `gorm:"unique;not null;uniqueIndex"`
+	Password  string          `gorm:"not null"`
+	Email     string          `gorm:"unique;not null"`
+	IsActive  bool            `gorm:"not null;default:true"`
+	Roles     []role0001      `gorm:"many2many:user_roles;joinForeignKey:UserID;joinReferences:RoleID"`
+}
+
+func (user0001) TableName() string { return "users" }
+
+type role0001 struct {
+	ID        uint `gorm:"primarykey,not null,autoIncrement"`
+	CreatedAt time.Time
+	UpdatedAt time.Time
+	DeletedAt *gorm.DeletedAt `gorm:"index"`
+	Code      string          `gorm:"unique,uniqueIndex,not null"`
+}
+
+func (role0001) TableName() string { return "roles" }
+
+// Databases created by the former AutoMigrate already have these tables, so
+// this migration only creates what is missing.
+var initialSchema = db.Migration{
+	Version: 1,
+	Name:    "initial_schema",
+	Up: func(tx *gorm.DB) error {
+		return tx.AutoMigrate(&user0001{}, &role0001{})
+	},
+	Down: func(tx *gorm.DB) error {
+		return tx.Migrator().DropTable("user_roles", "roles", "users")
+	},
+}
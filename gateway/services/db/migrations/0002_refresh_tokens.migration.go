@@ -0,0 +1,35 @@
+package migrations
+
+import (
+	"gateway/services/db"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+type refreshToken0002 struct {
+	ID        uint `gorm:"primarykey,not null,autoIncrement"`
+	CreatedAt time.Time
+	UpdatedAt time.Time
+	DeletedAt *gorm.DeletedAt `gorm:"index"`
+	UserID    uint            `gorm:"not null;index"`
+	FamilyID  string          `gorm:"not null;index"`
+	TokenHash string          `gorm:"not null;uniqueIndex"`
+	DeviceID  string          `gorm:"not null;default:''"`
+	ExpiresAt time.Time       `gorm:"not null"`
+	UsedAt    *time.Time
+	RevokedAt *time.Time
+}
+
+func (refreshToken0002) TableName() string { return "refresh_tokens" }
+
+var refreshTokens = db.Migration{
+	Version: 2,
+	Name:    "refresh_tokens",
+	Up: func(tx *gorm.DB) error {
+		return tx.Migrator().CreateTable(&refreshToken0002{})
+	},
+	Down: func(tx *gorm.DB) error {
+		return tx.Migrator().DropTable(&refreshToken0002{})
+	},
+}
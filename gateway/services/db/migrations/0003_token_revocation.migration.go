@@ -0,0 +1,53 @@
+package migrations
+
+import (
+	"gateway/services/db"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+type user0003 struct {
+	ID                uint
+	PasswordChangedAt *time.Time
+	SessionsRevokedAt *time.Time
+}
+
+func (user0003) TableName() string { return "users" }
+
+type revokedToken0003 struct {
+	ID        uint `gorm:"primarykey,not null,autoIncrement"`
+	CreatedAt time.Time
+	UpdatedAt time.Time
+	DeletedAt *gorm.DeletedAt `gorm:"index"`
+	JTI       string          `gorm:"not null;uniqueIndex"`
+	UserID    uint            `gorm:"not null;index"`
+	ExpiresAt time.Time       `gorm:"not null;index"`
+}
+
+func (revokedToken0003) TableName() string { return "revoked_tokens" }
+
+var tokenRevocation = db.Migration{
+	Version: 3,
+	Name:    "token_revocation",
+	Up: func(tx *gorm.DB) error {
+		m := tx.Migrator()
+		if err := m.AddColumn(&user0003{}, "PasswordChangedAt"); err != nil {
+			return err
+		}
+		if err := m.AddColumn(&user0003{}, "SessionsRevokedAt"); err != nil {
+			return err
+		}
+		return m.CreateTable(&revokedToken0003{})
+	},
+	Down: func(tx *gorm.DB) error {
+		m := tx.Migrator()
+		if err := m.DropTable(&revokedToken0003{}); err != nil {
+			return err
+		}
+		if err := m.DropColumn(&user0003{}, "SessionsRevokedAt"); err != nil {
+			return err
+		}
+		return m.DropColumn(&user0003{}, "PasswordChangedAt")
+	},
+}
@@ -0,0 +1,20 @@
+package migrations
+
+import (
+	"gateway/services/db"
+
+	"gorm.io/gorm"
+)
+
+// The original roles.code tag used commas instead of semicolons, so the
+// column was created without its unique index.
+var uniqueRoleCode = db.Migration{
+	Version: 4,
+	Name:    "unique_role_code",
+	Up: func(tx *gorm.DB) error {
+		return tx.Exec("CREATE UNIQUE INDEX IF NOT EXISTS idx_roles_code ON roles (code)").Error
+	},
+	Down: func(tx *gorm.DB) error {
+		return tx.Exec("DROP INDEX IF EXISTS idx_roles_code").Error
+	},
+}
@@ -0,0 +1,24 @@
+package migrations
+
+import (
+	"gateway/services/db"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+var seedAdminRole = db.Migration{
+	Version: 5,
+	Name:    "seed_admin_role",
+	Up: func(tx *gorm.DB) error {
+		var count int64
+		if err := tx.Table("roles").Where("code = ?", "admin").Count(&count).Error; err != nil || count > 0 {
+			return err
+		}
+		now := time.Now()
+		return tx.Table("roles").Create(map[string]interface{}{"code": "admin", "created_at": now, "updated_at": now}).Error
+	},
+	Down: func(tx *gorm.DB) error {
+		return tx.Exec("DELETE FROM roles WHERE code = ? AND id NOT IN (SELECT role_id FROM user_roles)", "admin").Error
+	},
+}
@@ -0,0 +1,69 @@
+package migrations
+
+import (
+	"gateway/services/db"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+type permission0006 struct {
+	ID          uint `gorm:"primarykey,not null,autoIncrement"`
+	CreatedAt   time.Time
+	UpdatedAt   time.Time
+	DeletedAt   *gorm.DeletedAt `gorm:"index"`
+	Code        string          `gorm:"uniqueIndex;not null"`
+	Description string
+}
+
+func (permission0006) TableName() string { return "permissions" }
+
+type rolePermission0006 struct {
+	RoleID       uint `gorm:"primaryKey"`
+	PermissionID uint `gorm:"primaryKey"`
+}
+
+func (rolePermission0006) TableName() string { return "role_permissions" }
+
+var seededPermissions0006 = []permission0006{
+	{Code: "users:read", Description: "List and view users"},
+	{Code: "users:write", Description: "Create and change any user"},
+	{Code: "roles:read", Description: "List roles and permissions"},
+	{Code: "roles:write", Description: "Manage roles, permissions and role assignments"},
+	{Code: "routes:read", Description: "View the route table"},
+	{Code: "routes:write", Description: "Reload the route table"},
+}
+
+// permissions grants every seeded permission to the admin role, which keeps
+// the access it had when routes checked the role itself.
+var permissions = db.Migration{
+	Version: 6,
+	Name:    "permissions",
+	Up: func(tx *gorm.DB) error {
+		m := tx.Migrator()
+		if err := m.CreateTable(&permission0006{}); err != nil {
+			return err
+		}
+		if err := m.CreateTable(&rolePermission0006{}); err != nil {
+			return err
+		}
+
+		seeded := append([]permission0006{}, seededPermissions0006...)
+		if err := tx.Create(&seeded).Error; err != nil {
+			return err
+		}
+
+		var adminID uint
+		if err := tx.Table("roles").Select("id").Where("code = ?", "admin").Scan(&adminID).Error; err != nil || adminID == 0 {
+			return err
+		}
+		grants := make([]rolePermission0006, len(seeded))
+		for i, p := range seeded {
+			grants[i] = rolePermission0006{RoleID: adminID, PermissionID: p.ID}
+		}
+		return tx.Create(&grants).Error
+	},
+	Down: func(tx *gorm.DB) error {
+		return tx.Migrator().DropTable(&rolePermission0006{}, &permission0006{})
+	},
+}
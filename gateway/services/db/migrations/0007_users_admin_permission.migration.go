@@ -0,0 +1,32 @@
+package migrations
+
+import (
+	"gateway/services/db"
+
+	"gorm.io/gorm"
+)
+
+// usersAdminPermission adds users:admin, which allows changing other users'
+// records, and grants it to the admin role.
+var usersAdminPermission = db.Migration{
+	Version: 7,
+	Name:    "users_admin_permission",
+	Up: func(tx *gorm.DB) error {
+		permission := permission0006{Code: "users:admin", Description: "Change other users' records"}
+		if err := tx.Create(&permission).Error; err != nil {
+			return err
+		}
+
+		var adminID uint
+		if err := tx.Table("roles").Select("id").Where("code = ?", "admin").Scan(&adminID).Error; err != nil || adminID == 0 {
+			return err
+		}
+		return tx.Create(&rolePermission0006{RoleID: adminID, PermissionID: permission.ID}).Error
+	},
+	Down: func(tx *gorm.DB) error {
+		if err := tx.Exec("DELETE FROM role_permissions WHERE permission_id IN (SELECT id FROM permissions WHERE code = ?)", "users:admin").Error; err != nil {
+			return err
+		}
+		return tx.Exec("DELETE FROM permissions WHERE code = ?", "users:admin").Error
+	},
+}
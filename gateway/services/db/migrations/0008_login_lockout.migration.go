@@ -0,0 +1,40 @@
+package migrations
+
+import (
+	"gateway/services/db"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+type user0008 struct {
+	ID               uint
+	LastLoginAt      *time.Time
+	FailedLoginCount int `gorm:"not null;default:0"`
+	LockedUntil      *time.Time
+}
+
+func (user0008) TableName() string { return "users" }
+
+var loginLockout = db.Migration{
+	Version: 8,
+	Name:    "login_lockout",
+	Up: func(tx *gorm.DB) error {
+		m := tx.Migrator()
+		for _, column := range []string{"LastLoginAt", "FailedLoginCount", "LockedUntil"} {
+			if err := m.AddColumn(&user0008{}, column); err != nil {
+				return err
+			}
+		}
+		return nil
+	},
+	Down: func(tx *gorm.DB) error {
+		m := tx.Migrator()
+		for _, column := range []string{"LockedUntil", "FailedLoginCount", "LastLoginAt"} {
+			if err := m.DropColumn(&user0008{}, column); err != nil {
+				return err
+			}
+		}
+		return nil
+	},
+}
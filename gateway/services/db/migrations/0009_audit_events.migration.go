@@ -0,0 +1,58 @@
+package migrations
+
+import (
+	"gateway/services/db"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+type auditEvent0009 struct {
+	ID         uint      `gorm:"primarykey,not null,autoIncrement"`
+	CreatedAt  time.Time `gorm:"not null;index"`
+	Action     string    `gorm:"not null;index"`
+	Success    bool      `gorm:"not null"`
+	ActorID    *uint     `gorm:"index"`
+	ActorName  string    `gorm:"not null;default:''"`
+	TargetType string    `gorm:"not null;default:''"`
+	TargetID   *uint     `gorm:"index"`
+	TargetName string    `gorm:"not null;default:''"`
+	IP         string    `gorm:"not null;default:''"`
+	RequestID  string    `gorm:"not null;default:''"`
+	Reason     string    `gorm:"not null;default:''"`
+	Changes    string    `gorm:"type:text"`
+}
+
+func (auditEvent0009) TableName() string { return "audit_events" }
+
+// auditEvents creates the audit trail and grants audit:read, which allows
+// reading it, to the admin role.
+var auditEvents = db.Migration{
+	Version: 9,
+	Name:    "audit_events",
+	Up: func(tx *gorm.DB) error {
+		if err := tx.Migrator().CreateTable(&auditEvent0009{}); err != nil {
+			return err
+		}
+
+		permission := permission0006{Code: "audit:read", Description: "Read the audit trail"}
+		if err := tx.Create(&permission).Error; err != nil {
+			return err
+		}
+
+		var adminID uint
+		if err := tx.Table("roles").Select("id").Where("code = ?", "admin").Scan(&adminID).Error; err != nil || adminID == 0 {
+			return err
+		}
+		return tx.Create(&rolePermission0006{RoleID: adminID, PermissionID: permission.ID}).Error
+	},
+	Down: func(tx *gorm.DB) error {
+		if err := tx.Exec("DELETE FROM role_permissions WHERE permission_id IN (SELECT id FROM permissions WHERE code = ?)", "audit:read").Error; err != nil {
+			return err
+		}
+		if err := tx.Exec("DELETE FROM permissions WHERE code = ?", "audit:read").Error; err != nil {
+			return err
+		}
+		return tx.Migrator().DropTable(&auditEvent0009{})
+	},
+}
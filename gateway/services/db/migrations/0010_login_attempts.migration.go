@@ -0,0 +1,62 @@
+package migrations
+
+import (
+	"gateway/services/db"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+type loginAttempt0010 struct {
+	Username    string `gorm:"primaryKey"`
+	FailedCount int    `gorm:"not null;default:0"`
+	LockedUntil *time.Time
+	UpdatedAt   time.Time
+}
+
+func (loginAttempt0010) TableName() string { return "login_attempts" }
+
+// loginAttempts moves the login lockout from users to a table keyed by
+// username, so that failures are also counted for names no user has.
+var loginAttempts = db.Migration{
+	Version: 10,
+	Name:    "login_attempts",
+	Up: func(tx *gorm.DB) error {
+		m := tx.Migrator()
+		if err := m.CreateTable(&loginAttempt0010{}); err != nil {
+			return err
+		}
+
+		err := tx.Exec(`INSERT INTO login_attempts (username, failed_count, locked_until, updated_at)
+			SELECT username, failed_login_count, locked_until, ? FROM users
+			WHERE failed_login_count > 0 OR locked_until IS NOT NULL`, time.Now()).Error
+		if err != nil {
+			return err
+		}
+
+		for _, column := range []string{"LockedUntil", "FailedLoginCount"} {
+			if err := m.DropColumn(&user0008{}, column); err != nil {
+				return err
+			}
+		}
+		return nil
+	},
+	Down: func(tx *gorm.DB) error {
+		m := tx.Migrator()
+		for _, column := range []string{"FailedLoginCount", "LockedUntil"} {
+			if err := m.AddColumn(&user0008{}, column); err != nil {
+				return err
+			}
+		}
+
+		err := tx.Exec(`UPDATE users SET
+			failed_login_count = (SELECT failed_count FROM login_attempts WHERE login_attempts.username = users.username),
+			locked_until = (SELECT locked_until FROM login_attempts WHERE login_attempts.username = users.username)
+			WHERE username IN (SELECT username FROM login_attempts)`).Error
+		if err != nil {
+			return err
+		}
+
+		return m.DropTable(&loginAttempt0010{})
+	},
+}
@@ -0,0 +1,20 @@
+package migrations
+
+import "gateway/services/db"
+
+// All returns the gateway schema history. Append new migrations at the end
+// and never edit one that has been released.
+func All() []db.Migration {
+	return []db.Migration{
+		initialSchema,
+		refreshTokens,
+		tokenRevocation,
+		uniqueRoleCode,
+		seedAdminRole,
+		permissions,
+		usersAdminPermission,
+		loginLockout,
+		auditEvents,
+		loginAttempts,
+	}
+}
@@ -0,0 +1,272 @@
+package db
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"sort"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// Migration is one versioned schema change. Versions must be unique and are
+// applied in ascending order. Down may be nil for irreversible changes.
+type Migration struct {
+	Version uint
+	Name    string
+	Up      func(tx *gorm.DB) error
+	Down    func(tx *gorm.DB) error
+}
+
+type MigrationStatus struct {
+	Version   uint       `json:"version"`
+	Name      string     `json:"name"`
+	AppliedAt *time.Time `json:"appliedAt"`
+}
+
+type schemaMigration struct {
+	Version   uint `gorm:"primaryKey;autoIncrement:false"`
+	Name      string
+	AppliedAt time.Time
+}
+
+func (schemaMigration) TableName() string {
+	return "schema_migrations"
+}
+
+// migrationLock has at most one row. Whoever inserts it owns the lock.
+type migrationLock struct {
+	ID       uint `gorm:"primaryKey;autoIncrement:false"`
+	Owner    string
+	LockedAt time.Time
+}
+
+func (migrationLock) TableName() string {
+	return "schema_migrations_lock"
+}
+
+var ErrMigrationLocked = errors.New("Another instance is running migrations")
+
+// Migrator applies and rolls back migrations, holding a table based lock so
+// that only one instance changes the schema at a time.
+type Migrator struct {
+	conn        *gorm.DB
+	migrations  []Migration
+	owner       string
+	LockTimeout time.Duration
+	StaleAfter  time.Duration
+}
+
+func NewMigrator(conn *gorm.DB, migrations []Migration) (*Migrator, error) {
+	sorted := append([]Migration(nil), migrations...)
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i].Version < sorted[j].Version })
+	for i := 1; i < len(sorted); i++ {
+		if sorted[i].Version == sorted[i-1].Version {
+			return nil, fmt.Errorf("Duplicate migration version %d", sorted[i].Version)
+		}
+	}
+
+	host, _ := os.Hostname()
+	return &Migrator{
+		conn:        conn,
+		migrations:  sorted,
+		owner:       fmt.Sprintf("%s:%d", host, os.Getpid()),
+		LockTimeout: time.Minute,
+		StaleAfter:  15 * time.Minute,
+	}, nil
+}
+
+// Up applies every pending migration, each in its own transaction.
+func (m *Migrator) Up() ([]Migration, error) {
+	var done []Migration
+
+	err := m.locked(func(applied map[uint]schemaMigration) error {
+		for _, mg := range m.migrations {
+			if _, ok := applied[mg.Version]; ok {
+				continue
+			}
+
+			err := m.conn.Transaction(func(tx *gorm.DB) error {
+				if err := mg.Up(tx); err != nil {
+					return err
+				}
+				return tx.Create(&schemaMigration{Version: mg.Version, Name: mg.Name, AppliedAt: time.Now()}).Error
+			})
+			if err != nil {
+				return fmt.Errorf("Migration %d %s failed: %w", mg.Version, mg.Name, err)
+			}
+			done = append(done, mg)
+		}
+		return nil
+	})
+
+	return done, err
+}
+
+// Down rolls back the last steps applied migrations, newest first.
+func (m *Migrator) Down(steps int) ([]Migration, error) {
+	var done []Migration
+
+	err := m.locked(func(applied map[uint]schemaMigration) error {
+		for i := len(m.migrations) - 1; i >= 0 && len(done) < steps; i-- {
+			mg := m.migrations[i]
+			if _, ok := applied[mg.Version]; !ok {
+				continue
+			}
+			if mg.Down == nil {
+				return fmt.Errorf("Migration %d %s cannot be rolled back", mg.Version, mg.Name)
+			}
+
+			err := m.conn.Transaction(func(tx *gorm.DB) error {
+				if err := mg.Down(tx); err != nil {
+					return err
+				}
+				return tx.Delete(&schemaMigration{Version: mg.Version}).Error
+			})
+			if err != nil {
+				return fmt.Errorf("Rollback of %d %s failed: %w", mg.Version, mg.Name, err)
+			}
+			done = append(done, mg)
+		}
+		return nil
+	})
+
+	return done, err
+}
+
+// Status lists every known migration with the time it was applied, if ever.
+func (m *Migrator) Status(ctx context.Context) ([]MigrationStatus, error) {
+	applied, err := m.applied(m.conn.WithContext(ctx))
+	if err != nil {
+		return nil, err
+	}
+
+	var statuses []MigrationStatus
+	for _, mg := range m.migrations {
+		s := MigrationStatus{Version: mg.Version, Name: mg.Name}
+		if a, ok := applied[mg.Version]; ok {
+			at := a.AppliedAt
+			s.AppliedAt = &at
+		}
+		statuses = append(statuses, s)
+	}
+
+	return statuses, nil
+}
+
+// Pending returns the number of migrations not applied yet.
+func (m *Migrator) Pending(ctx context.Context) (int, error) {
+	statuses, err := m.Status(ctx)
+	if err != nil {
+		return 0, err
+	}
+
+	pending := 0
+	for _, s := range statuses {
+		if s.AppliedAt == nil {
+			pending++
+		}
+	}
+
+	return pending, nil
+}
+
+// Check fails while migrations are pending, for instance when they are left
+// to the migrate subcommand and it has not run yet. It only reads the schema.
+func (m *Migrator) Check(ctx context.Context) error {
+	pending, err := m.Pending(ctx)
+	if err != nil {
+		return err
+	}
+	if pending > 0 {
+		return fmt.Errorf("%d migrations pending", pending)
+	}
+
+	return nil
+}
+
+// applied reads the migrations applied so far. A database whose bookkeeping
+// tables were never created has none.
+func (m *Migrator) applied(conn *gorm.DB) (map[uint]schemaMigration, error) {
+	applied := map[uint]schemaMigration{}
+	if !conn.Migrator().HasTable(&schemaMigration{}) {
+		return applied, nil
+	}
+
+	var rows []schemaMigration
+	if err := conn.Find(&rows).Error; err != nil {
+		return nil, err
+	}
+	for _, r := range rows {
+		applied[r.Version] = r
+	}
+
+	return applied, nil
+}
+
+func (m *Migrator) locked(fn func(applied map[uint]schemaMigration) error) error {
+	if err := m.conn.AutoMigrate(&schemaMigration{}, &migrationLock{}); err != nil {
+		return err
+	}
+	if err := m.lock(); err != nil {
+		return err
+	}
+	defer m.conn.Where("id = ? AND owner = ?", 1, m.owner).Delete(&migrationLock{})
+
+	// Long migrations keep the lock fresh so that it is never taken for the
+	// lock of a crashed instance.
+	if interval := m.StaleAfter / 3; interval > 0 {
+		stop := make(chan struct{})
+		defer close(stop)
+		go m.heartbeat(interval, stop)
+	}
+
+	// Re-read after locking: another instance may have just finished.
+	applied, err := m.applied(m.conn)
+	if err != nil {
+		return err
+	}
+
+	return fn(applied)
+}
+
+// heartbeat refreshes the lock every interval until stop is closed.
+func (m *Migrator) heartbeat(interval time.Duration, stop <-chan struct{}) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+			m.conn.Model(&migrationLock{}).Where("id = ? AND owner = ?", 1, m.owner).Update("locked_at", time.Now())
+		}
+	}
+}
+
+func (m *Migrator) lock() error {
+	deadline := time.Now().Add(m.LockTimeout)
+
+	for {
+		err := m.conn.Create(&migrationLock{ID: 1, Owner: m.owner, LockedAt: time.Now()}).Error
+		if err == nil {
+			return nil
+		}
+		if !IsUniqueViolation(err, "") {
+			return err
+		}
+
+		// A crashed instance must not block migrations forever.
+		if m.conn.Where("id = ? AND locked_at < ?", 1, time.Now().Add(-m.StaleAfter)).Delete(&migrationLock{}).RowsAffected > 0 {
+			continue
+		}
+
+		if time.Now().After(deadline) {
+			return ErrMigrationLocked
+		}
+		time.Sleep(250 * time.Millisecond)
+	}
+}
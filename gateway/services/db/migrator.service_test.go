@@ -0,0 +1,161 @@
+package db
+
+import (
+	"context"
+	"path/filepath"
+	"testing"
+	"time"
+
+	. "github.com/smartystreets/goconvey/convey"
+	"gorm.io/driver/sqlite"
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+)
+
+type widget struct {
+	ID   uint
+	Name string
+}
+
+type coloredWidget struct {
+	widget
+	Color string
+}
+
+func (coloredWidget) TableName() string {
+	return "widgets"
+}
+
+func testMigrations() []Migration {
+	return []Migration{
+		{
+			Version: 2,
+			Name:    "add_widget_color",
+			Up:      func(tx *gorm.DB) error { return tx.Migrator().AddColumn(&coloredWidget{}, "Color") },
+			Down:    func(tx *gorm.DB) error { return tx.Migrator().DropColumn(&coloredWidget{}, "Color") },
+		},
+		{
+			Version: 1,
+			Name:    "create_widgets",
+			Up:      func(tx *gorm.DB) error { return tx.Migrator().CreateTable(&widget{}) },
+			Down:    func(tx *gorm.DB) error { return tx.Migrator().DropTable(&widget{}) },
+		},
+	}
+}
+
+func TestMigrator(t *testing.T) {
+	Convey("type Migrator", t, func() {
+		conn, _ := gorm.Open(sqlite.Open(filepath.Join(t.TempDir(), "migrations.db")), &gorm.Config{Logger: logger.Default.LogMode(logger.Silent)})
+		m, err := NewMigrator(conn, testMigrations())
+		So(err, ShouldBeNil)
+
+		Convey("Given a fresh database", func() {
+			Convey("When Check is called", func() {
+				err := m.Check(context.Background())
+
+				Convey("Then it reports the pending migrations", func() {
+					So(err, ShouldNotBeNil)
+					So(err.Error(), ShouldEqual, "2 migrations pending")
+				})
+
+				Convey("Then it leaves the schema alone", func() {
+					So(conn.Migrator().HasTable(&schemaMigration{}), ShouldBeFalse)
+				})
+			})
+
+			Convey("When Up is called", func() {
+				applied, err := m.Up()
+
+				Convey("Then every migration is applied in version order", func() {
+					So(err, ShouldBeNil)
+					So(len(applied), ShouldEqual, 2)
+					So(applied[0].Version, ShouldEqual, 1)
+					So(conn.Migrator().HasColumn("widgets", "color"), ShouldBeTrue)
+
+					pending, _ := m.Pending(context.Background())
+					So(pending, ShouldEqual, 0)
+					So(m.Check(context.Background()), ShouldBeNil)
+				})
+
+				Convey("Then calling Up again applies nothing", func() {
+					again, err := m.Up()
+					So(err, ShouldBeNil)
+					So(len(again), ShouldBeZeroValue)
+				})
+
+				Convey("Then Down rolls back the newest migration only", func() {
+					rolledBack, err := m.Down(1)
+					So(err, ShouldBeNil)
+					So(len(rolledBack), ShouldEqual, 1)
+					So(rolledBack[0].Version, ShouldEqual, 2)
+					So(conn.Migrator().HasTable("widgets"), ShouldBeTrue)
+
+					statuses, _ := m.Status(context.Background())
+					So(statuses[0].AppliedAt, ShouldNotBeNil)
+					So(statuses[1].AppliedAt, ShouldBeNil)
+				})
+			})
+		})
+
+		Convey("Given a migration that outlives StaleAfter", func() {
+			m.StaleAfter = 60 * time.Millisecond
+			var lock migrationLock
+			m.migrations = []Migration{{
+				Version: 1,
+				Name:    "slow",
+				Up: func(tx *gorm.DB) error {
+					time.Sleep(150 * time.Millisecond)
+					return tx.First(&lock).Error
+				},
+			}}
+
+			Convey("When Up is called", func() {
+				started := time.Now()
+				_, err := m.Up()
+
+				Convey("Then the lock is kept fresh while it runs", func() {
+					So(err, ShouldBeNil)
+					So(lock.Owner, ShouldEqual, m.owner)
+					So(lock.LockedAt, ShouldHappenAfter, started.Add(m.StaleAfter/3))
+				})
+			})
+		})
+
+		Convey("Given another instance holds the lock", func() {
+			conn.AutoMigrate(&schemaMigration{}, &migrationLock{})
+			conn.Create(&migrationLock{ID: 1, Owner: "other", LockedAt: time.Now()})
+			m.LockTimeout = 10 * time.Millisecond
+
+			Convey("When Up is called", func() {
+				_, err := m.Up()
+
+				Convey("Then it gives up without migrating", func() {
+					So(err, ShouldEqual, ErrMigrationLocked)
+					So(conn.Migrator().HasTable("widgets"), ShouldBeFalse)
+				})
+			})
+
+			Convey("When the lock is stale", func() {
+				m.StaleAfter = 0
+				_, err := m.Up()
+
+				Convey("Then it is taken over", func() {
+					So(err, ShouldBeNil)
+					So(conn.Migrator().HasTable("widgets"), ShouldBeTrue)
+				})
+			})
+		})
+	})
+
+	Convey("func NewMigrator(conn *gorm.DB, migrations []Migration) (*Migrator, error)", t, func() {
+		Convey("Given two migrations with the same version", func() {
+			Convey("When the function is called", func() {
+				_, err := NewMigrator(nil, []Migration{{Version: 1}, {Version: 1}})
+
+				Convey("Then it returns an error", func() {
+					So(err, ShouldNotBeNil)
+				})
+			})
+		})
+	})
+}
@@ -0,0 +1,36 @@
+package discovery
+
+import (
+	"context"
+	"errors"
+	"gateway/config"
+)
+
+var ErrUnknownService = errors.New("Unknown service")
+
+// Instance is one reachable copy of a service. Weight only matters to
+// weighted pools and defaults to 1.
+type Instance struct {
+	URL    string `json:"url" yaml:"url"`
+	Weight int    `json:"weight" yaml:"weight"`
+}
+
+// Provider looks up the instances of a service by name. Registries such as
+// Consul plug in by implementing it.
+type Provider interface {
+	Instances(ctx context.Context, service string) ([]Instance, error)
+}
+
+// New builds the provider named by cfg.Provider: static, file or dns.
+func New(cfg config.Discovery) (Provider, error) {
+	switch cfg.Provider {
+	case "", "static":
+		return NewStatic(cfg.Static), nil
+	case "file":
+		return NewFile(cfg.File), nil
+	case "dns":
+		return NewDNS(cfg.DNS.Domain, cfg.DNS.Scheme, nil), nil
+	default:
+		return nil, errors.New("Unknown service discovery provider: " + cfg.Provider)
+	}
+}
@@ -0,0 +1,151 @@
+package discovery
+
+import (
+	"context"
+	"errors"
+	"gateway/config"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+type fakeResolver struct {
+	records []*net.SRV
+	err     error
+	asked   string
+}
+
+func (r *fakeResolver) LookupSRV(ctx context.Context, service, proto, name string) (string, []*net.SRV, error) {
+	r.asked = "_" + service + "._" + proto + "." + name
+	return r.asked, r.records, r.err
+}
+
+func TestDiscovery(t *testing.T) {
+	ctx := context.Background()
+
+	Convey("func New(cfg config.Discovery) (Provider, error)", t, func() {
+		Convey("Given an unknown provider", func() {
+			Convey("When the function is called", func() {
+				_, err := New(config.Discovery{Provider: "consul"})
+
+				Convey("Then it returns an error", func() {
+					So(err, ShouldNotBeNil)
+				})
+			})
+		})
+	})
+
+	Convey("type Static", t, func() {
+		Convey("Given services listed in the config", func() {
+			p, _ := New(config.Discovery{Provider: "static", Static: map[string][]string{"orders": {"http://a", "http://b"}}})
+
+			Convey("When a listed service is looked up", func() {
+				instances, err := p.Instances(ctx, "orders")
+
+				Convey("Then its URLs are returned", func() {
+					So(err, ShouldBeNil)
+					So(instances, ShouldResemble, []Instance{{URL: "http://a", Weight: 1}, {URL: "http://b", Weight: 1}})
+				})
+			})
+
+			Convey("When another service is looked up", func() {
+				_, err := p.Instances(ctx, "catalog")
+
+				Convey("Then it is unknown", func() {
+					So(err, ShouldEqual, ErrUnknownService)
+				})
+			})
+		})
+	})
+
+	Convey("type File", t, func() {
+		Convey("Given a service catalog file", func() {
+			path := filepath.Join(t.TempDir(), "services.yaml")
+			os.WriteFile(path, []byte("services:\n  orders:\n    - url: http://a\n      weight: 2\n"), 0600)
+			p := NewFile(path)
+
+			Convey("When it changes between lookups", func() {
+				before, _ := p.Instances(ctx, "orders")
+				os.WriteFile(path, []byte("services:\n  orders:\n    - url: http://b\n"), 0600)
+				after, err := p.Instances(ctx, "orders")
+
+				Convey("Then every lookup sees the current content", func() {
+					So(err, ShouldBeNil)
+					So(before, ShouldResemble, []Instance{{URL: "http://a", Weight: 2}})
+					So(after, ShouldResemble, []Instance{{URL: "http://b"}})
+				})
+			})
+
+			Convey("When a service missing from it is looked up", func() {
+				_, err := p.Instances(ctx, "catalog")
+
+				Convey("Then it is unknown", func() {
+					So(err, ShouldEqual, ErrUnknownService)
+				})
+			})
+		})
+
+		Convey("Given a missing file", func() {
+			p := NewFile(filepath.Join(t.TempDir(), "services.json"))
+
+			Convey("When a service is looked up", func() {
+				_, err := p.Instances(ctx, "orders")
+
+				Convey("Then it returns an error", func() {
+					So(err, ShouldNotBeNil)
+				})
+			})
+		})
+	})
+
+	Convey("type DNS", t, func() {
+		Convey("Given SRV records of several priorities", func() {
+			resolver := &fakeResolver{records: []*net.SRV{
+				{Target: "orders-3.example.", Port: 8080, Priority: 20, Weight: 1},
+				{Target: "orders-1.example.", Port: 8080, Priority: 10, Weight: 3},
+				{Target: "orders-2.example.", Port: 8081, Priority: 10, Weight: 1},
+			}}
+			p := NewDNS("example", "https", resolver)
+
+			Convey("When a service is looked up", func() {
+				instances, err := p.Instances(ctx, "orders")
+
+				Convey("Then the preferred records become weighted instances", func() {
+					So(err, ShouldBeNil)
+					So(resolver.asked, ShouldEqual, "_orders._tcp.example")
+					So(instances, ShouldResemble, []Instance{
+						{URL: "https://orders-1.example:8080", Weight: 3},
+						{URL: "https://orders-2.example:8081", Weight: 1},
+					})
+				})
+			})
+		})
+
+		Convey("Given a name without records", func() {
+			p := NewDNS("example", "", &fakeResolver{err: &net.DNSError{Err: "no such host", IsNotFound: true}})
+
+			Convey("When a service is looked up", func() {
+				_, err := p.Instances(ctx, "orders")
+
+				Convey("Then it is unknown", func() {
+					So(err, ShouldEqual, ErrUnknownService)
+				})
+			})
+		})
+
+		Convey("Given a failing resolver", func() {
+			p := NewDNS("example", "", &fakeResolver{err: errors.New("timeout")})
+
+			Convey("When a service is looked up", func() {
+				_, err := p.Instances(ctx, "orders")
+
+				Convey("Then the error is returned", func() {
+					So(err.Error(), ShouldEqual, "timeout")
+				})
+			})
+		})
+	})
+}
@@ -0,0 +1,72 @@
+package discovery
+
+import (
+	"context"
+	"errors"
+	"net"
+	"strconv"
+	"strings"
+)
+
+// Resolver is the part of net.Resolver DNS discovery needs.
+type Resolver interface {
+	LookupSRV(ctx context.Context, service, proto, name string) (string, []*net.SRV, error)
+}
+
+// DNS looks up the SRV records _<service>._tcp.<domain>. Records of the
+// lowest priority are the instances, weighted as published.
+type DNS struct {
+	domain   string
+	scheme   string
+	resolver Resolver
+}
+
+// NewDNS uses net.DefaultResolver when resolver is nil and http when scheme
+// is empty.
+func NewDNS(domain, scheme string, resolver Resolver) *DNS {
+	if resolver == nil {
+		resolver = net.DefaultResolver
+	}
+	if scheme == "" {
+		scheme = "http"
+	}
+
+	return &DNS{domain: domain, scheme: scheme, resolver: resolver}
+}
+
+func (d *DNS) Instances(ctx context.Context, service string) ([]Instance, error) {
+	_, records, err := d.resolver.LookupSRV(ctx, service, "tcp", d.domain)
+	var dnsErr *net.DNSError
+	if errors.As(err, &dnsErr) && dnsErr.IsNotFound {
+		return nil, ErrUnknownService
+	}
+	if err != nil {
+		return nil, err
+	}
+	if len(records) == 0 {
+		return nil, ErrUnknownService
+	}
+
+	// Records with a higher priority value are backups; the pool ejects
+	// failing instances by itself, so only the preferred ones are used.
+	priority := records[0].Priority
+	for _, r := range records {
+		if r.Priority < priority {
+			priority = r.Priority
+		}
+	}
+
+	instances := []Instance{}
+	for _, r := range records {
+		if r.Priority != priority {
+			continue
+		}
+		host := strings.TrimSuffix(r.Target, ".")
+		instances = append(instances, Instance{
+			URL:    d.scheme + "://" + net.JoinHostPort(host, strconv.Itoa(int(r.Port))),
+			Weight: int(r.Weight),
+		})
+	}
+
+	return instances, nil
+}
@@ -0,0 +1,59 @@
+package discovery
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// File reads the instances from a YAML or JSON file on every lookup, so that
+// whatever keeps the file up to date needs no signal to the gateway:
+//
+//	services:
+//	  orders:
+//	    - url: http://10.0.0.7:8080
+//	      weight: 2
+type File struct {
+	path string
+}
+
+type catalog struct {
+	Services map[string][]Instance `json:"services" yaml:"services"`
+}
+
+func NewFile(path string) *File {
+	return &File{path: path}
+}
+
+func (f *File) Instances(ctx context.Context, service string) ([]Instance, error) {
+	content, err := os.ReadFile(f.path)
+	if err != nil {
+		return nil, err
+	}
+
+	c := catalog{}
+	switch strings.ToLower(filepath.Ext(f.path)) {
+	case ".yaml", ".yml":
+		err = yaml.Unmarshal(content, &c)
+	case ".json":
+		err = json.Unmarshal(content, &c)
+	default:
+		err = errors.New("Unsupported service catalog format")
+	}
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", f.path, err)
+	}
+
+	instances, ok := c.Services[service]
+	if !ok {
+		return nil, ErrUnknownService
+	}
+
+	return instances, nil
+}
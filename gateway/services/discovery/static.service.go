@@ -0,0 +1,28 @@
+package discovery
+
+import "context"
+
+// Static serves the instances listed in the gateway config.
+type Static struct {
+	services map[string][]Instance
+}
+
+func NewStatic(services map[string][]string) *Static {
+	s := &Static{services: map[string][]Instance{}}
+	for name, urls := range services {
+		for _, u := range urls {
+			s.services[name] = append(s.services[name], Instance{URL: u, Weight: 1})
+		}
+	}
+
+	return s
+}
+
+func (s *Static) Instances(ctx context.Context, service string) ([]Instance, error) {
+	instances, ok := s.services[service]
+	if !ok {
+		return nil, ErrUnknownService
+	}
+
+	return instances, nil
+}
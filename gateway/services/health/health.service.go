@@ -0,0 +1,151 @@
+package health
+
+import (
+	"context"
+	"gateway/config"
+	"sort"
+	"sync"
+	"time"
+)
+
+type Status string
+
+const (
+	Up       Status = "up"
+	Degraded Status = "degraded"
+	Down     Status = "down"
+)
+
+// Checker tells whether something the gateway depends on works.
+type Checker func(ctx context.Context) error
+
+type check struct {
+	name    string
+	checker Checker
+}
+
+var (
+	mu       sync.RWMutex
+	checks   []check
+	settings = config.Default().Readiness
+	draining bool
+)
+
+type Report struct {
+	Status Status            `json:"status"`
+	Checks map[string]Result `json:"checks"`
+}
+
+type Result struct {
+	Status Status `json:"status"`
+	// Critical checks make the gateway not ready when they fail, the others
+	// only degraded.
+	Critical bool   `json:"critical"`
+	Error    string `json:"error,omitempty"`
+	Duration string `json:"duration"`
+}
+
+func Init(cfg config.Readiness) {
+	mu.Lock()
+	defer mu.Unlock()
+
+	settings = cfg
+}
+
+// Register adds a readiness check, replacing any other of the same name.
+func Register(name string, checker Checker) {
+	mu.Lock()
+	defer mu.Unlock()
+
+	for i, c := range checks {
+		if c.name == name {
+			checks[i].checker = checker
+			return
+		}
+	}
+	checks = append(checks, check{name: name, checker: checker})
+	sort.Slice(checks, func(i, j int) bool { return checks[i].name < checks[j].name })
+}
+
+// Drain makes the gateway not ready for good, so that load balancers stop
+// sending it traffic before it shuts down.
+func Drain() {
+	mu.Lock()
+	defer mu.Unlock()
+
+	draining = true
+}
+
+// Check runs every check concurrently, each within the readiness timeout.
+// The gateway is down when a critical check fails and degraded when only
+// others do.
+func Check(ctx context.Context) Report {
+	mu.RLock()
+	registered := append([]check{}, checks...)
+	cfg := settings
+	shuttingDown := draining
+	mu.RUnlock()
+
+	if shuttingDown {
+		return Report{Status: Down, Checks: map[string]Result{
+			"shutdown": {Status: Down, Critical: true, Error: "Shutting down", Duration: "0s"},
+		}}
+	}
+
+	degraded := map[string]bool{}
+	for _, name := range cfg.Degraded {
+		degraded[name] = true
+	}
+
+	results := make([]Result, len(registered))
+	var wg sync.WaitGroup
+	for i, c := range registered {
+		wg.Add(1)
+		go func(i int, c check) {
+			defer wg.Done()
+			results[i] = run(ctx, c.checker, time.Duration(cfg.Timeout))
+			results[i].Critical = !degraded[c.name]
+		}(i, c)
+	}
+	wg.Wait()
+
+	report := Report{Status: Up, Checks: map[string]Result{}}
+	for i, c := range registered {
+		r := results[i]
+		report.Checks[c.name] = r
+		if r.Status == Up {
+			continue
+		}
+		if r.Critical {
+			report.Status = Down
+		} else if report.Status == Up {
+			report.Status = Degraded
+		}
+	}
+
+	return report
+}
+
+func run(ctx context.Context, checker Checker, timeout time.Duration) Result {
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	started := time.Now()
+	done := make(chan error, 1)
+	go func() { done <- checker(ctx) }()
+
+	var err error
+	select {
+	case err = <-done:
+	case <-ctx.Done():
+		// Checkers ignoring ctx must not hold the probe up.
+		err = ctx.Err()
+	}
+
+	r := Result{Status: Up, Duration: time.Since(started).Round(time.Microsecond).String()}
+	if err != nil {
+		r.Status, r.Error = Down, err.Error()
+	}
+
+	return r
+}
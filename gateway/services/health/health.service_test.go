@@ -0,0 +1,112 @@
+package health
+
+import (
+	"context"
+	"errors"
+	"gateway/config"
+	"testing"
+	"time"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func up(ctx context.Context) error { return nil }
+
+func failing(ctx context.Context) error { return errors.New("connection refused") }
+
+func TestHealth(t *testing.T) {
+	Convey("func Check(ctx context.Context) Report", t, func() {
+		checks, draining = nil, false
+		Init(config.Readiness{Timeout: config.Duration(50 * time.Millisecond), Degraded: []string{"upstreams"}})
+
+		Convey("Given every check passes", func() {
+			Register("database", up)
+			Register("upstreams", up)
+
+			Convey("When the function is called", func() {
+				report := Check(context.Background())
+
+				Convey("Then the gateway is up with each check listed", func() {
+					So(report.Status, ShouldEqual, Up)
+					So(len(report.Checks), ShouldEqual, 2)
+					So(report.Checks["database"].Critical, ShouldBeTrue)
+					So(report.Checks["upstreams"].Critical, ShouldBeFalse)
+				})
+			})
+		})
+
+		Convey("Given a check configured as degraded fails", func() {
+			Register("database", up)
+			Register("upstreams", failing)
+
+			Convey("When the function is called", func() {
+				report := Check(context.Background())
+
+				Convey("Then the gateway is degraded", func() {
+					So(report.Status, ShouldEqual, Degraded)
+					So(report.Checks["upstreams"].Status, ShouldEqual, Down)
+					So(report.Checks["upstreams"].Error, ShouldEqual, "connection refused")
+				})
+			})
+		})
+
+		Convey("Given a critical check fails", func() {
+			Register("database", failing)
+			Register("upstreams", failing)
+
+			Convey("When the function is called", func() {
+				report := Check(context.Background())
+
+				Convey("Then the gateway is down", func() {
+					So(report.Status, ShouldEqual, Down)
+				})
+			})
+		})
+
+		Convey("Given a check that hangs", func() {
+			Register("database", func(ctx context.Context) error {
+				time.Sleep(time.Second)
+				return nil
+			})
+
+			Convey("When the function is called", func() {
+				started := time.Now()
+				report := Check(context.Background())
+
+				Convey("Then it fails after the timeout", func() {
+					So(time.Since(started), ShouldBeLessThan, 500*time.Millisecond)
+					So(report.Status, ShouldEqual, Down)
+					So(report.Checks["database"].Error, ShouldEqual, context.DeadlineExceeded.Error())
+				})
+			})
+		})
+
+		Convey("Given the gateway is draining", func() {
+			Register("database", up)
+			Drain()
+
+			Convey("When the function is called", func() {
+				report := Check(context.Background())
+
+				Convey("Then it is down whatever the checks say", func() {
+					So(report.Status, ShouldEqual, Down)
+					So(report.Checks["shutdown"].Error, ShouldEqual, "Shutting down")
+				})
+			})
+		})
+
+		Convey("Given a check registered twice", func() {
+			Register("database", failing)
+			Register("database", up)
+
+			Convey("When the function is called", func() {
+				report := Check(context.Background())
+
+				Convey("Then the last checker is used", func() {
+					So(len(report.Checks), ShouldEqual, 1)
+					So(report.Status, ShouldEqual, Up)
+				})
+			})
+		})
+	})
+}
@@ -0,0 +1,160 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"gateway/config"
+	"gateway/models"
+	"gateway/services/db"
+	"time"
+
+	"go.uber.org/zap"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+var ErrLoginLocked = errors.New("Too many failed login attempts")
+
+// claimRetries bounds how often ClaimLoginAttempt retries when concurrent
+// attempts for the same username keep changing its count.
+const claimRetries = 5
+
+// pruneInterval is how often WatchLoginAttempts prunes login attempts.
+const pruneInterval = time.Minute
+
+// ClaimLoginAttempt counts an attempt to log in as username before its
+// password is checked, and locks the username for as long as the attempt
+// would if it failed, so that concurrent attempts cannot all slip in before
+// the lock. RecordSuccessfulLogin lifts it. While the username is locked, it
+// returns ErrLoginLocked and until when.
+func ClaimLoginAttempt(ctx context.Context, username string, lockout config.Lockout) (time.Time, error) {
+	conn := db.Conn.WithContext(ctx)
+	if err := conn.Clauses(clause.OnConflict{DoNothing: true}).Create(&models.LoginAttempt{Username: username}).Error; err != nil {
+		logDBError(ctx, err)
+		return time.Time{}, errors.New("Error writing to database")
+	}
+
+	for i := 0; i < claimRetries; i++ {
+		var attempt models.LoginAttempt
+		if err := conn.Where("username = ?", username).First(&attempt).Error; err != nil {
+			logDBError(ctx, err)
+			return time.Time{}, errors.New("Error when reading database")
+		}
+		now := time.Now()
+		if attempt.LockedUntil != nil && now.Before(*attempt.LockedUntil) {
+			return *attempt.LockedUntil, ErrLoginLocked
+		}
+
+		updates := map[string]interface{}{"failed_count": attempt.FailedCount + 1, "locked_until": nil}
+		if wait := LoginBackoff(attempt.FailedCount+1, lockout); wait > 0 {
+			updates["locked_until"] = now.Add(wait)
+		}
+		// The count only changes through claims, so an unchanged count
+		// means nobody claimed or locked the username since it was read.
+		result := conn.Model(&models.LoginAttempt{}).
+			Where("username = ? AND failed_count = ?", username, attempt.FailedCount).
+			Updates(updates)
+		if result.Error != nil {
+			logDBError(ctx, result.Error)
+			return time.Time{}, errors.New("Error writing to database")
+		}
+		if result.RowsAffected == 1 {
+			return time.Time{}, nil
+		}
+	}
+
+	return time.Now().Add(time.Second), ErrLoginLocked
+}
+
+// PruneLoginAttempts deletes the attempts counted for usernames that no user
+// has once they are no longer locked and were last tried lockout.Duration
+// ago, so that trying random usernames cannot grow the table without bound.
+func PruneLoginAttempts(ctx context.Context, lockout config.Lockout) error {
+	now := time.Now()
+	err := db.Conn.WithContext(ctx).
+		Where("username NOT IN (?)", db.Conn.Model(&models.User{}).Select("username")).
+		Where("locked_until IS NULL OR locked_until < ?", now).
+		Where("updated_at < ?", now.Add(-time.Duration(lockout.Duration))).
+		Delete(&models.LoginAttempt{}).Error
+	if err != nil {
+		logDBError(ctx, err)
+		return errors.New("Error writing to database")
+	}
+
+	return nil
+}
+
+// WatchLoginAttempts prunes login attempts every minute until ctx is done.
+func WatchLoginAttempts(ctx context.Context, lockout config.Lockout) {
+	ticker := time.NewTicker(pruneInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if err := PruneLoginAttempts(ctx, lockout); err != nil {
+				logger(ctx).Warn("Failed to prune login attempts", zap.Error(err))
+			}
+		}
+	}
+}
+
+// LoginBackoff is how long an account waits after failures failed passwords
+// in a row: nothing for the free attempts, then the lockout delay doubled
+// with every failure, and the full lockout duration from the threshold on.
+func LoginBackoff(failures int, l config.Lockout) time.Duration {
+	max := time.Duration(l.Duration)
+
+	switch {
+	case failures <= l.FreeAttempts:
+		return 0
+	case failures >= l.Threshold:
+		return max
+	}
+
+	wait := time.Duration(l.Delay)
+	for i := l.FreeAttempts + 1; i < failures && wait < max; i++ {
+		wait *= 2
+	}
+	if wait > max {
+		return max
+	}
+	return wait
+}
+
+// RecordSuccessfulLogin clears the failed logins of user.
+func RecordSuccessfulLogin(ctx context.Context, user *models.User) error {
+	err := db.Conn.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		if err := tx.Model(user).Update("last_login_at", time.Now()).Error; err != nil {
+			return err
+		}
+		return tx.Where("username = ?", user.Username).Delete(&models.LoginAttempt{}).Error
+	})
+	if err != nil {
+		logDBError(ctx, err)
+		return errors.New("Error writing to database")
+	}
+	evictPrincipal(user.ID)
+
+	return nil
+}
+
+// UnlockUser clears the failed logins of the user so that they can log in
+// again right away.
+func UnlockUser(ctx context.Context, id uint) (*models.User, error) {
+	user, err := GetUserByID(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+
+	result := db.Conn.WithContext(ctx).Where("username = ?", user.Username).Delete(&models.LoginAttempt{})
+	if result.Error != nil {
+		logDBError(ctx, result.Error)
+		return nil, errors.New("Error writing to database")
+	}
+	evictPrincipal(id)
+
+	return GetUserByID(ctx, id)
+}
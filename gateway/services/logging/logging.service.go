@@ -0,0 +1,151 @@
+package logging
+
+import (
+	"context"
+	"fmt"
+	"gateway/config"
+	"gateway/models"
+	"gateway/services/metrics"
+	"gateway/utils"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	fiberutils "github.com/gofiber/fiber/v2/utils"
+	"go.opentelemetry.io/otel/trace"
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+)
+
+// loggers holds the root logger, which lets every entry through, and the
+// level of each package, applied on top of it by Named.
+var loggers = struct {
+	sync.Mutex
+	root   *zap.Logger
+	level  zapcore.Level
+	levels map[string]zapcore.Level
+	named  map[string]*zap.Logger
+}{}
+
+// output is where entries go, swapped by tests.
+var output zapcore.WriteSyncer = zapcore.Lock(os.Stderr)
+
+type fieldsKey struct{}
+
+func init() {
+	if err := Init(config.Default().Logging); err != nil {
+		panic(err)
+	}
+}
+
+// Init replaces every logger with ones configured by cfg. Until it is called,
+// info entries and above are written as JSON.
+func Init(cfg config.Logging) error {
+	level, err := zapcore.ParseLevel(cfg.Level)
+	if err != nil {
+		return err
+	}
+	levels := make(map[string]zapcore.Level, len(cfg.Levels))
+	for pkg, l := range cfg.Levels {
+		if levels[pkg], err = zapcore.ParseLevel(l); err != nil {
+			return fmt.Errorf("Invalid log level for %s: %w", pkg, err)
+		}
+	}
+
+	encoding := zap.NewProductionEncoderConfig()
+	encoding.TimeKey = "time"
+	encoding.EncodeTime = zapcore.ISO8601TimeEncoder
+	encoder := zapcore.NewJSONEncoder(encoding)
+	if cfg.Format == "console" {
+		encoding.EncodeLevel = zapcore.CapitalLevelEncoder
+		encoder = zapcore.NewConsoleEncoder(encoding)
+	}
+	core := zapcore.NewCore(encoder, output, zapcore.DebugLevel)
+
+	loggers.Lock()
+	defer loggers.Unlock()
+	loggers.root = zap.New(core, zap.AddCaller(), zap.ErrorOutput(zapcore.Lock(os.Stderr)))
+	loggers.level = level
+	loggers.levels = levels
+	loggers.named = map[string]*zap.Logger{}
+
+	return nil
+}
+
+// Named is the logger of package pkg, at the level configured for it.
+func Named(pkg string) *zap.Logger {
+	loggers.Lock()
+	defer loggers.Unlock()
+
+	if logger, ok := loggers.named[pkg]; ok {
+		return logger
+	}
+	level, ok := loggers.levels[pkg]
+	if !ok {
+		level = loggers.level
+	}
+	logger := loggers.root.Named(pkg).WithOptions(zap.IncreaseLevel(level))
+	loggers.named[pkg] = logger
+
+	return logger
+}
+
+// Ctx is the logger of package pkg with the fields of the request ctx belongs
+// to, including the trace it is part of.
+func Ctx(ctx context.Context, pkg string) *zap.Logger {
+	fields, _ := ctx.Value(fieldsKey{}).([]zap.Field)
+	if span := trace.SpanContextFromContext(ctx); span.IsValid() {
+		fields = append(fields[:len(fields):len(fields)], zap.String("traceId", span.TraceID().String()))
+	}
+
+	return Named(pkg).With(fields...)
+}
+
+// With returns a copy of ctx whose loggers add fields to every entry.
+func With(ctx context.Context, fields ...zap.Field) context.Context {
+	existing, _ := ctx.Value(fieldsKey{}).([]zap.Field)
+
+	return context.WithValue(ctx, fieldsKey{}, append(existing[:len(existing):len(existing)], fields...))
+}
+
+// Sync flushes the entries buffered by the loggers.
+func Sync() {
+	loggers.Lock()
+	root := loggers.root
+	loggers.Unlock()
+
+	root.Sync()
+}
+
+// Middleware adds the request ID to every entry logged for the request
+// through c.UserContext(), and logs the request once served: at debug level,
+// or as an error when it failed with a 5xx status. Mount it after the
+// RequestID and tracing middlewares, so that entries carry both IDs.
+func Middleware() fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		started := time.Now()
+		// Fiber reuses the bytes behind these strings; entries outlive requests.
+		c.SetUserContext(With(c.UserContext(),
+			zap.String("requestId", utils.RequestID(c)),
+			zap.String("method", fiberutils.CopyString(c.Method())),
+			zap.String("path", fiberutils.CopyString(c.Path())),
+		))
+
+		err := c.Next()
+
+		route, status := metrics.Outcome(c, err)
+		fields := []zap.Field{zap.String("route", route), zap.Int("status", status), zap.Duration("latency", time.Since(started))}
+		if user, ok := c.Locals("user").(*models.UserSafeDto); ok {
+			fields = append(fields, zap.Uint("userId", user.ID))
+		}
+		logger := Ctx(c.UserContext(), "http")
+		if status >= fiber.StatusInternalServerError {
+			logger.Error("Request failed", append(fields, zap.Error(err))...)
+		} else {
+			logger.Debug("Request served", fields...)
+		}
+
+		return err
+	}
+}
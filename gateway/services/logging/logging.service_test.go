@@ -0,0 +1,129 @@
+package logging
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"gateway/config"
+	"gateway/models"
+	"gateway/utils"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gofiber/fiber/v2"
+	. "github.com/smartystreets/goconvey/convey"
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+)
+
+// capture sends the entries of loggers initialized from now on to a buffer,
+// decoded one map per entry.
+func capture(cfg config.Logging) func() []map[string]interface{} {
+	buffer := &bytes.Buffer{}
+	output = zapcore.AddSync(buffer)
+	Init(cfg)
+
+	return func() []map[string]interface{} {
+		var entries []map[string]interface{}
+		for _, line := range strings.Split(strings.TrimSpace(buffer.String()), "\n") {
+			entry := map[string]interface{}{}
+			if json.Unmarshal([]byte(line), &entry) == nil {
+				entries = append(entries, entry)
+			}
+		}
+		return entries
+	}
+}
+
+func TestLogging(t *testing.T) {
+	Convey("func Named(pkg string) *zap.Logger", t, func() {
+		Convey("Given a level set for one package", func() {
+			entries := capture(config.Logging{Level: "info", Format: "json", Levels: map[string]string{"proxy": "debug"}})
+
+			Convey("When packages log at debug level", func() {
+				Named("proxy").Debug("Picked a member")
+				Named("services").Debug("Queried users")
+				Named("services").Warn("Slow query")
+
+				Convey("Then only the package set to debug logs it", func() {
+					logged := entries()
+					So(len(logged), ShouldEqual, 2)
+					So(logged[0]["logger"], ShouldEqual, "proxy")
+					So(logged[0]["msg"], ShouldEqual, "Picked a member")
+					So(logged[1]["level"], ShouldEqual, "warn")
+				})
+			})
+		})
+
+		Convey("Given an unknown level", func() {
+			Convey("When Init is called", func() {
+				err := Init(config.Logging{Level: "verbose"})
+
+				Convey("Then it returns an error", func() {
+					So(err, ShouldNotBeNil)
+				})
+			})
+		})
+	})
+
+	Convey("func Ctx(ctx context.Context, pkg string) *zap.Logger", t, func() {
+		Convey("Given a context carrying fields", func() {
+			entries := capture(config.Logging{Level: "info", Format: "json"})
+			ctx := With(context.Background(), zap.String("requestId", "abc"))
+
+			Convey("When a logger from it logs", func() {
+				Ctx(With(ctx, zap.Int("attempt", 2)), "proxy").Info("Retrying")
+				Ctx(ctx, "proxy").Info("Done")
+
+				Convey("Then every entry has the fields of its context", func() {
+					logged := entries()
+					So(logged[0]["requestId"], ShouldEqual, "abc")
+					So(logged[0]["attempt"], ShouldEqual, 2)
+					So(logged[1], ShouldNotContainKey, "attempt")
+				})
+			})
+		})
+	})
+
+	Convey("func Middleware() fiber.Handler", t, func() {
+		entries := capture(config.Logging{Level: "debug", Format: "json"})
+		app := fiber.New()
+		app.Use(func(c *fiber.Ctx) error {
+			c.Locals(utils.RequestIDKey, "req-1")
+			return c.Next()
+		})
+		app.Use(Middleware())
+		app.Get("/v1/users/:id", func(c *fiber.Ctx) error {
+			c.Locals("user", &models.UserSafeDto{Model: models.Model{ID: 7}})
+			Ctx(c.UserContext(), "services").Info("Loading user")
+			return fiber.ErrBadGateway
+		})
+
+		Convey("Given a request failing with a server error", func() {
+			req := httptest.NewRequest("GET", "/v1/users/1", nil)
+
+			Convey("When it is served", func() {
+				app.Test(req)
+
+				Convey("Then entries logged for it carry its request ID", func() {
+					logged := entries()
+					So(len(logged), ShouldEqual, 2)
+					So(logged[0]["requestId"], ShouldEqual, "req-1")
+					So(logged[0]["path"], ShouldEqual, "/v1/users/1")
+				})
+
+				Convey("Then it is logged as an error with its route, user and latency", func() {
+					request := entries()[1]
+					So(request["level"], ShouldEqual, "error")
+					So(request["logger"], ShouldEqual, "http")
+					So(request["route"], ShouldEqual, "/v1/users/:id")
+					So(request["status"], ShouldEqual, fiber.StatusBadGateway)
+					So(request["userId"], ShouldEqual, 7)
+					So(request, ShouldContainKey, "latency")
+					So(request["error"], ShouldEqual, fiber.ErrBadGateway.Message)
+				})
+			})
+		})
+	})
+}
@@ -0,0 +1,180 @@
+package metrics
+
+import (
+	"database/sql"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/gofiber/fiber/v2/utils"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"github.com/valyala/fasthttp/fasthttpadaptor"
+)
+
+const namespace = "gateway"
+
+// routeKey holds the route template on the fasthttp request, which proxy
+// routes share with the app they are mounted in.
+const routeKey = "metrics.route"
+
+// Unmatched labels requests no route matched.
+const Unmatched = "unmatched"
+
+// Registry holds every gateway metric, apart from the Go runtime and process
+// ones registered with it.
+var Registry = prometheus.NewRegistry()
+
+var (
+	requests = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "http_requests_total",
+		Help:      "HTTP requests served, by route template, method and status.",
+	}, []string{"route", "method", "status"})
+	requestDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "http_request_duration_seconds",
+		Help:      "Time taken to serve HTTP requests, by route template, method and status.",
+		Buckets:   prometheus.DefBuckets,
+	}, []string{"route", "method", "status"})
+	inFlight = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "http_requests_in_flight",
+		Help:      "HTTP requests being served, by method. The route is only known once routed.",
+	}, []string{"method"})
+
+	logins = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "logins_total",
+		Help:      "Login attempts, by result: success, failure, locked or throttled.",
+	}, []string{"result"})
+
+	upstreamRequests = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "upstream_requests_total",
+		Help:      "Calls to upstreams, retries included, by upstream origin and status, error when no response came.",
+	}, []string{"upstream", "status"})
+	upstreamDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "upstream_request_duration_seconds",
+		Help:      "Time until upstreams sent response headers, by upstream origin.",
+		Buckets:   prometheus.DefBuckets,
+	}, []string{"upstream"})
+	upstreamRetries = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "upstream_retries_total",
+		Help:      "Calls to upstreams that were retries of a failed one, by upstream origin.",
+	}, []string{"upstream"})
+	upstreamRejected = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "upstream_rejected_total",
+		Help:      "Calls not made, by upstream origin or pool and reason: breaker_open or no_healthy_member.",
+	}, []string{"upstream", "reason"})
+)
+
+func init() {
+	Registry.MustRegister(
+		collectors.NewGoCollector(),
+		collectors.NewProcessCollector(collectors.ProcessCollectorOpts{}),
+		requests, requestDuration, inFlight,
+		logins,
+		upstreamRequests, upstreamDuration, upstreamRetries, upstreamRejected,
+	)
+}
+
+// Handler serves the metrics in the Prometheus text format.
+func Handler() fiber.Handler {
+	handler := fasthttpadaptor.NewFastHTTPHandler(promhttp.HandlerFor(Registry, promhttp.HandlerOpts{}))
+
+	return func(c *fiber.Ctx) error {
+		handler(c.Context())
+		return nil
+	}
+}
+
+// Middleware measures every request. Mount it before anything else.
+func Middleware() fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		// Fiber reuses the bytes behind c.Method(); labels outlive requests.
+		method := utils.CopyString(c.Method())
+		started := time.Now()
+		inFlight.WithLabelValues(method).Inc()
+		defer inFlight.WithLabelValues(method).Dec()
+
+		err := c.Next()
+
+		route, status := Outcome(c, err)
+		labels := []string{route, method, strconv.Itoa(status)}
+		requests.WithLabelValues(labels...).Inc()
+		requestDuration.WithLabelValues(labels...).Observe(time.Since(started).Seconds())
+
+		return err
+	}
+}
+
+// SetRoute labels the request with route, for handlers that route by
+// themselves such as the proxy.
+func SetRoute(c *fiber.Ctx, route string) {
+	c.Context().SetUserValue(routeKey, route)
+}
+
+// Outcome is the route template and the status of a request once err has
+// been returned by its handlers. Errors are turned into responses by the app
+// error handler only after middlewares return, so their status is guessed
+// the same way.
+func Outcome(c *fiber.Ctx, err error) (route string, status int) {
+	route = routeTemplate(c)
+	status = c.Response().StatusCode()
+	if err != nil {
+		status = fiber.StatusInternalServerError
+		if e, ok := err.(*fiber.Error); ok {
+			status = e.Code
+			// Fiber's own answer when no route matches.
+			if e.Code == fiber.StatusNotFound && strings.HasPrefix(e.Message, "Cannot ") {
+				route = Unmatched
+			}
+		}
+	}
+
+	return route, status
+}
+
+// routeTemplate is the template of the route that served the request,
+// never the raw path, so that label values stay few.
+func routeTemplate(c *fiber.Ctx) string {
+	if route, ok := c.Context().UserValue(routeKey).(string); ok {
+		return route
+	}
+
+	return c.Route().Path
+}
+
+// Login counts a login attempt by result.
+func Login(result string) {
+	logins.WithLabelValues(result).Inc()
+}
+
+// UpstreamCall records one call to upstream; status 0 means no response.
+func UpstreamCall(upstream string, status int, retry bool, took time.Duration) {
+	label := "error"
+	if status > 0 {
+		label = strconv.Itoa(status)
+	}
+	upstreamRequests.WithLabelValues(upstream, label).Inc()
+	upstreamDuration.WithLabelValues(upstream).Observe(took.Seconds())
+	if retry {
+		upstreamRetries.WithLabelValues(upstream).Inc()
+	}
+}
+
+// UpstreamRejected counts a call the proxy did not make.
+func UpstreamRejected(upstream, reason string) {
+	upstreamRejected.WithLabelValues(upstream, reason).Inc()
+}
+
+// RegisterDB exports the connection pool statistics of db.
+func RegisterDB(db *sql.DB, name string) error {
+	return Registry.Register(collectors.NewDBStatsCollector(db, name))
+}
@@ -0,0 +1,101 @@
+package metrics
+
+import (
+	"io"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestMetrics(t *testing.T) {
+	Convey("func Middleware() fiber.Handler", t, func() {
+		requests.Reset()
+		app := fiber.New()
+		app.Use(Middleware())
+		app.Get("/metrics", Handler())
+		api := app.Group("/api")
+		api.Get("/v1/users/:id", func(c *fiber.Ctx) error {
+			return c.SendStatus(fiber.StatusNoContent)
+		})
+		api.Get("/v1/broken", func(c *fiber.Ctx) error {
+			return fiber.ErrBadRequest
+		})
+		api.Use(func(c *fiber.Ctx) error {
+			SetRoute(c, "/api/v1/orders/*")
+			return c.SendStatus(fiber.StatusAccepted)
+		})
+
+		Convey("Given requests to a route with params", func() {
+			Convey("When they are served", func() {
+				app.Test(httptest.NewRequest("GET", "/api/v1/users/1", nil))
+				app.Test(httptest.NewRequest("GET", "/api/v1/users/2", nil))
+
+				Convey("Then they are counted under the route template", func() {
+					So(testutil.ToFloat64(requests.WithLabelValues("/api/v1/users/:id", "GET", "204")), ShouldEqual, 2)
+				})
+			})
+		})
+
+		Convey("Given a handler returning an error", func() {
+			Convey("When it is served", func() {
+				app.Test(httptest.NewRequest("GET", "/api/v1/broken", nil))
+
+				Convey("Then the status of the error is used", func() {
+					So(testutil.ToFloat64(requests.WithLabelValues("/api/v1/broken", "GET", "400")), ShouldEqual, 1)
+				})
+			})
+		})
+
+		Convey("Given a handler that routes by itself", func() {
+			Convey("When it labels the request", func() {
+				app.Test(httptest.NewRequest("POST", "/api/v1/orders/42", nil))
+
+				Convey("Then its label is used", func() {
+					So(testutil.ToFloat64(requests.WithLabelValues("/api/v1/orders/*", "POST", "202")), ShouldEqual, 1)
+				})
+			})
+		})
+
+		Convey("Given a path no route matches", func() {
+			Convey("When it is requested", func() {
+				app.Test(httptest.NewRequest("GET", "/nothing/here", nil))
+
+				Convey("Then it is counted as unmatched rather than by path", func() {
+					So(testutil.ToFloat64(requests.WithLabelValues(Unmatched, "GET", "404")), ShouldEqual, 1)
+				})
+			})
+		})
+
+		Convey("Given a request in flight", func() {
+			Convey("When it is served", func() {
+				app.Test(httptest.NewRequest("GET", "/api/v1/users/1", nil))
+
+				Convey("Then the in-flight gauge is back to zero", func() {
+					So(testutil.ToFloat64(inFlight.WithLabelValues("GET")), ShouldEqual, 0)
+				})
+			})
+		})
+
+		Convey("Given recorded logins and upstream calls", func() {
+			Login("failure")
+			UpstreamCall("http://orders:8080", 0, true, time.Millisecond)
+
+			Convey("When the metrics are scraped", func() {
+				res, _ := app.Test(httptest.NewRequest("GET", "/metrics", nil))
+				body, _ := io.ReadAll(res.Body)
+
+				Convey("Then they are exposed in the Prometheus format", func() {
+					So(res.StatusCode, ShouldEqual, fiber.StatusOK)
+					So(string(body), ShouldContainSubstring, `gateway_logins_total{result="failure"}`)
+					So(string(body), ShouldContainSubstring, `gateway_upstream_requests_total{status="error",upstream="http://orders:8080"}`)
+					So(string(body), ShouldContainSubstring, `gateway_upstream_retries_total{upstream="http://orders:8080"}`)
+					So(string(body), ShouldContainSubstring, "go_goroutines")
+				})
+			})
+		})
+	})
+}
@@ -0,0 +1,102 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"gateway/models"
+	"gateway/services/db"
+
+	"gorm.io/gorm"
+)
+
+func FindPermissions(ctx context.Context) ([]models.Permission, error) {
+	var permissions []models.Permission
+	result := db.Conn.WithContext(ctx).Order("code").Find(&permissions)
+
+	if result.Error != nil {
+		logDBError(ctx, result.Error)
+		return nil, errors.New("Error when reading database")
+	}
+
+	return permissions, nil
+}
+
+func GetPermissionByID(ctx context.Context, id uint) (*models.Permission, error) {
+	permission := new(models.Permission)
+	result := db.Conn.WithContext(ctx).First(permission, id)
+
+	if result.Error != nil {
+		logDBError(ctx, result.Error)
+		return nil, errors.New("No permission found")
+	}
+
+	return permission, nil
+}
+
+func CreatePermission(ctx context.Context, dto models.CreatePermissionDto) (*models.Permission, error) {
+	permission := models.Permission{Code: dto.Code, Description: dto.Description}
+
+	result := db.Conn.WithContext(ctx).Create(&permission)
+	if result.Error != nil {
+		logDBError(ctx, result.Error)
+		if db.IsUniqueViolation(result.Error, "code") {
+			return nil, errors.New("Permission already exists")
+		}
+		return nil, errors.New("Error writing to database")
+	}
+
+	return &permission, nil
+}
+
+func GrantPermission(ctx context.Context, roleID, permissionID uint) (*models.Role, error) {
+	return changeRolePermissions(ctx, models.AuditPermissionGrant, roleID, permissionID, func(a *gorm.Association, r *models.Role, p *models.Permission) error {
+		return a.Append(p)
+	})
+}
+
+// RevokePermission takes the permission away from the role. The admin role
+// keeps all of its permissions so that it cannot be locked out.
+func RevokePermission(ctx context.Context, roleID, permissionID uint) (*models.Role, error) {
+	return changeRolePermissions(ctx, models.AuditPermissionRevoke, roleID, permissionID, func(a *gorm.Association, r *models.Role, p *models.Permission) error {
+		if r.Code == models.AdminRoleCode {
+			return ErrAdminRoleLocked
+		}
+		return a.Delete(p)
+	})
+}
+
+// changeRolePermissions applies change to the permissions of the role, and
+// records it in the audit trail as action.
+func changeRolePermissions(ctx context.Context, action string, roleID, permissionID uint, change func(*gorm.Association, *models.Role, *models.Permission) error) (*models.Role, error) {
+	role, err := GetRoleByID(ctx, roleID)
+	if err != nil {
+		return nil, err
+	}
+	permission, err := GetPermissionByID(ctx, permissionID)
+	if err != nil {
+		return nil, err
+	}
+
+	// The association changes role.Permissions in place.
+	before := permissionCodes(role.Permissions)
+	if err := change(db.Conn.WithContext(ctx).Model(role).Association("Permissions"), role, permission); err != nil {
+		if errors.Is(err, ErrAdminRoleLocked) {
+			return nil, err
+		}
+		logDBError(ctx, err)
+		return nil, errors.New("Error when writing database")
+	}
+	evictPrincipals()
+
+	changed, err := GetRoleByID(ctx, roleID)
+	if err != nil {
+		return nil, err
+	}
+	Audit(ctx, models.AuditEvent{
+		Action: action, Success: true,
+		TargetType: models.AuditTargetRole, TargetID: &role.ID, TargetName: role.Code,
+		Changes: models.AuditChanges{"permissions": {From: before, To: permissionCodes(changed.Permissions)}},
+	})
+
+	return changed, nil
+}
@@ -0,0 +1,151 @@
+package policy
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// Subject is the authenticated user a request is made by.
+type Subject struct {
+	ID          uint
+	Username    string
+	Roles       []string
+	Permissions []string
+}
+
+// Request holds every attribute a rule can look at.
+type Request struct {
+	Method  string
+	Path    string
+	Params  map[string]string
+	Subject Subject
+	Claims  map[string]interface{}
+}
+
+// Condition is a single test of a rule against a request.
+type Condition func(r *Request) bool
+
+type Effect int
+
+const (
+	Deny Effect = iota
+	Allow
+)
+
+// Rule applies when the request uses one of Methods, or any method when
+// there are none, and every condition holds.
+type Rule struct {
+	Name    string
+	Effect  Effect
+	Methods []string
+	When    []Condition
+}
+
+// Policy is an ordered list of rules. The first rule that applies decides;
+// requests no rule applies to are denied.
+type Policy struct {
+	Name  string
+	Rules []Rule
+}
+
+// Decision tells whether a request is allowed and which rule decided it.
+type Decision struct {
+	Allowed bool
+	Policy  string
+	Rule    string
+}
+
+func (d Decision) String() string {
+	if d.Rule == "" {
+		return fmt.Sprintf("%s: denied by default", d.Policy)
+	}
+	if d.Allowed {
+		return fmt.Sprintf("%s: allowed by rule %s", d.Policy, d.Rule)
+	}
+	return fmt.Sprintf("%s: denied by rule %s", d.Policy, d.Rule)
+}
+
+func (p Policy) Evaluate(r *Request) Decision {
+	for _, rule := range p.Rules {
+		if rule.applies(r) {
+			return Decision{Allowed: rule.Effect == Allow, Policy: p.Name, Rule: rule.Name}
+		}
+	}
+
+	return Decision{Allowed: false, Policy: p.Name}
+}
+
+func (rule Rule) applies(r *Request) bool {
+	if len(rule.Methods) > 0 && !contains(rule.Methods, strings.ToUpper(r.Method)) {
+		return false
+	}
+	for _, c := range rule.When {
+		if !c(r) {
+			return false
+		}
+	}
+
+	return true
+}
+
+// Always holds for every request, for catch-all rules.
+func Always() Condition {
+	return func(r *Request) bool { return true }
+}
+
+func HasPermission(code string) Condition {
+	return func(r *Request) bool { return contains(r.Subject.Permissions, code) }
+}
+
+func HasRole(code string) Condition {
+	return func(r *Request) bool { return contains(r.Subject.Roles, code) }
+}
+
+// ParamIsSubject holds when the route parameter is the subject's own ID, as
+// in PATCH /users/:id for the user's own record.
+func ParamIsSubject(param string) Condition {
+	return func(r *Request) bool {
+		id, err := strconv.ParseUint(r.Params[param], 10, 64)
+		return err == nil && r.Subject.ID != 0 && uint(id) == r.Subject.ID
+	}
+}
+
+func ParamEquals(param, value string) Condition {
+	return func(r *Request) bool { return r.Params[param] == value }
+}
+
+// ClaimEquals compares a token claim with value in their string forms, so
+// that numeric claims decoded as float64 compare as written.
+func ClaimEquals(name string, value interface{}) Condition {
+	return func(r *Request) bool {
+		claim, ok := r.Claims[name]
+		return ok && fmt.Sprint(claim) == fmt.Sprint(value)
+	}
+}
+
+func Not(c Condition) Condition {
+	return func(r *Request) bool { return !c(r) }
+}
+
+// AnyOf holds when at least one of the conditions does. Listing conditions in
+// Rule.When requires all of them.
+func AnyOf(cs ...Condition) Condition {
+	return func(r *Request) bool {
+		for _, c := range cs {
+			if c(r) {
+				return true
+			}
+		}
+		return false
+	}
+}
+
+func contains(values []string, v string) bool {
+	for _, value := range values {
+		if value == v {
+			return true
+		}
+	}
+	return false
+}
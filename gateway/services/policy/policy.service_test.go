@@ -0,0 +1,115 @@
+package policy
+
+import (
+	"testing"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestPolicy(t *testing.T) {
+	Convey("func (p Policy) Evaluate(r *Request) Decision", t, func() {
+		p := Policy{
+			Name: "users.update",
+			Rules: []Rule{
+				{Name: "suspended", Effect: Deny, When: []Condition{ClaimEquals("suspended", true)}},
+				{Name: "self", Effect: Allow, Methods: []string{"PATCH"}, When: []Condition{ParamIsSubject("id")}},
+				{Name: "admin", Effect: Allow, When: []Condition{AnyOf(HasPermission("users:admin"), HasRole("root"))}},
+			},
+		}
+		r := &Request{
+			Method:  "PATCH",
+			Params:  map[string]string{"id": "7"},
+			Subject: Subject{ID: 7, Permissions: []string{"users:read"}},
+			Claims:  map[string]interface{}{"sub": "7"},
+		}
+
+		Convey("Given a subject changing their own record", func() {
+			Convey("When the policy is evaluated", func() {
+				d := p.Evaluate(r)
+
+				Convey("Then the request is allowed by the self rule", func() {
+					So(d.Allowed, ShouldBeTrue)
+					So(d.Rule, ShouldEqual, "self")
+					So(d.String(), ShouldEqual, "users.update: allowed by rule self")
+				})
+			})
+
+			Convey("When the method is not one the rule lists", func() {
+				r.Method = "DELETE"
+				d := p.Evaluate(r)
+
+				Convey("Then the request is denied by default", func() {
+					So(d.Allowed, ShouldBeFalse)
+					So(d.Rule, ShouldBeBlank)
+				})
+			})
+
+			Convey("When an earlier deny rule applies", func() {
+				r.Claims["suspended"] = true
+				d := p.Evaluate(r)
+
+				Convey("Then the request is denied by that rule", func() {
+					So(d.Allowed, ShouldBeFalse)
+					So(d.Rule, ShouldEqual, "suspended")
+				})
+			})
+		})
+
+		Convey("Given a subject changing someone else's record", func() {
+			r.Params["id"] = "8"
+
+			Convey("When the subject has no admin permission", func() {
+				d := p.Evaluate(r)
+
+				Convey("Then the request is denied", func() {
+					So(d.Allowed, ShouldBeFalse)
+				})
+			})
+
+			Convey("When the subject has users:admin", func() {
+				r.Subject.Permissions = append(r.Subject.Permissions, "users:admin")
+				d := p.Evaluate(r)
+
+				Convey("Then the request is allowed by the admin rule", func() {
+					So(d.Allowed, ShouldBeTrue)
+					So(d.Rule, ShouldEqual, "admin")
+				})
+			})
+
+			Convey("When the subject has the root role", func() {
+				r.Subject.Roles = []string{"root"}
+
+				Convey("Then the request is allowed", func() {
+					So(p.Evaluate(r).Allowed, ShouldBeTrue)
+				})
+			})
+		})
+	})
+
+	Convey("func ParamIsSubject(param string) Condition", t, func() {
+		Convey("Given a parameter that is not a number", func() {
+			r := &Request{Params: map[string]string{"id": "me"}, Subject: Subject{ID: 1}}
+
+			Convey("When the condition is tested", func() {
+				Convey("Then it does not hold", func() {
+					So(ParamIsSubject("id")(r), ShouldBeFalse)
+					So(Not(ParamIsSubject("id"))(r), ShouldBeTrue)
+				})
+			})
+		})
+	})
+
+	Convey("func ClaimEquals(name string, value interface{}) Condition", t, func() {
+		Convey("Given a numeric claim decoded from JSON", func() {
+			r := &Request{Claims: map[string]interface{}{"tier": float64(2)}}
+
+			Convey("When the condition is tested", func() {
+				Convey("Then it matches the value as written", func() {
+					So(ClaimEquals("tier", 2)(r), ShouldBeTrue)
+					So(ClaimEquals("tier", 3)(r), ShouldBeFalse)
+					So(ClaimEquals("missing", "")(r), ShouldBeFalse)
+				})
+			})
+		})
+	})
+}
@@ -0,0 +1,83 @@
+package services
+
+import (
+	"context"
+	"gateway/models"
+	"sync"
+	"time"
+)
+
+// principals caches users together with their roles and permissions, so that
+// authenticating a request does not query the database every time. Changes
+// made through this package evict the affected users immediately.
+var principals = struct {
+	sync.Mutex
+	entries map[uint]principal
+	// Evictions bump the generation of the user, or of every user, so that
+	// loads that started before an eviction do not cache what they read.
+	generation  uint64
+	generations map[uint]uint64
+}{entries: map[uint]principal{}, generations: map[uint]uint64{}}
+
+type principal struct {
+	user     *models.User
+	loadedAt time.Time
+}
+
+// principalGeneration tells which evictions a load of the user comes after.
+type principalGeneration struct {
+	all, user uint64
+}
+
+// GetPrincipal returns the user with roles and permissions, from the cache
+// when it is younger than ttl. A ttl of 0 disables the cache.
+func GetPrincipal(ctx context.Context, id uint, ttl time.Duration) (*models.User, error) {
+	principals.Lock()
+	cached, ok := principals.entries[id]
+	generation := principalGeneration{all: principals.generation, user: principals.generations[id]}
+	principals.Unlock()
+	if ok && time.Since(cached.loadedAt) < ttl {
+		return cached.user, nil
+	}
+
+	user, err := GetUserByID(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+
+	if ttl > 0 {
+		cachePrincipal(id, user, generation)
+	}
+
+	return user, nil
+}
+
+// cachePrincipal keeps the user loaded at generation, unless it has been
+// evicted since.
+func cachePrincipal(id uint, user *models.User, generation principalGeneration) {
+	principals.Lock()
+	defer principals.Unlock()
+
+	if principals.generation == generation.all && principals.generations[id] == generation.user {
+		principals.entries[id] = principal{user: user, loadedAt: time.Now()}
+	}
+}
+
+func evictPrincipal(id uint) {
+	principals.Lock()
+	delete(principals.entries, id)
+	principals.generations[id]++
+	principals.Unlock()
+}
+
+// evictPrincipals empties the cache, for changes to roles that may affect any
+// number of users.
+func evictPrincipals() {
+	principals.Lock()
+	principals.entries = map[uint]principal{}
+	// The new generation outdates every load in flight, so the users' own
+	// generations can start over.
+	principals.generation++
+	principals.generations = map[uint]uint64{}
+	principals.Unlock()
+}
@@ -0,0 +1,78 @@
+package services
+
+import (
+	"gateway/models"
+	"testing"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestPrincipalCache(t *testing.T) {
+	Convey("func cachePrincipal(id uint, user *models.User, generation principalGeneration)", t, func() {
+		user := &models.User{Username: "cached"}
+		loading := func(id uint) principalGeneration {
+			principals.Lock()
+			defer principals.Unlock()
+			return principalGeneration{all: principals.generation, user: principals.generations[id]}
+		}
+		cached := func(id uint) bool {
+			principals.Lock()
+			defer principals.Unlock()
+			_, ok := principals.entries[id]
+			return ok
+		}
+
+		Convey("Given nothing was evicted while the user loaded", func() {
+			generation := loading(1)
+
+			Convey("When the function is called", func() {
+				cachePrincipal(1, user, generation)
+
+				Convey("Then the user is cached", func() {
+					So(cached(1), ShouldBeTrue)
+				})
+			})
+		})
+
+		Convey("Given the user was evicted while it loaded", func() {
+			generation := loading(1)
+			evictPrincipal(1)
+
+			Convey("When the function is called", func() {
+				cachePrincipal(1, user, generation)
+
+				Convey("Then the stale user is not cached", func() {
+					So(cached(1), ShouldBeFalse)
+				})
+			})
+		})
+
+		Convey("Given every user was evicted while it loaded", func() {
+			generation := loading(1)
+			evictPrincipals()
+
+			Convey("When the function is called", func() {
+				cachePrincipal(1, user, generation)
+
+				Convey("Then the stale user is not cached", func() {
+					So(cached(1), ShouldBeFalse)
+				})
+			})
+		})
+
+		Convey("Given another user was evicted while it loaded", func() {
+			generation := loading(1)
+			evictPrincipal(2)
+
+			Convey("When the function is called", func() {
+				cachePrincipal(1, user, generation)
+
+				Convey("Then the user is cached", func() {
+					So(cached(1), ShouldBeTrue)
+				})
+			})
+		})
+
+		Reset(evictPrincipals)
+	})
+}
@@ -0,0 +1,163 @@
+package proxy
+
+import (
+	"errors"
+	"gateway/config"
+	"sort"
+	"sync"
+	"time"
+)
+
+var ErrBreakerOpen = errors.New("Circuit breaker is open")
+
+type BreakerState int
+
+const (
+	Closed BreakerState = iota
+	Open
+	HalfOpen
+)
+
+func (s BreakerState) String() string {
+	switch s {
+	case Open:
+		return "open"
+	case HalfOpen:
+		return "half-open"
+	default:
+		return "closed"
+	}
+}
+
+// Breaker fails calls to one upstream fast while it keeps failing, so that
+// requests do not pile up waiting on it.
+type Breaker struct {
+	mu       sync.Mutex
+	settings config.Breaker
+	state    BreakerState
+	failures int
+	openedAt time.Time
+	trials   int
+	now      func() time.Time
+}
+
+type BreakerStatus struct {
+	Upstream string     `json:"upstream"`
+	State    string     `json:"state"`
+	Failures int        `json:"failures"`
+	OpenedAt *time.Time `json:"openedAt,omitempty"`
+	RetryAt  *time.Time `json:"retryAt,omitempty"`
+}
+
+func NewBreaker(settings config.Breaker) *Breaker {
+	return &Breaker{settings: settings, now: time.Now}
+}
+
+// Allow tells whether a call may go ahead. Every allowed call must be
+// followed by Record.
+func (b *Breaker) Allow() error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if b.settings.FailureThreshold <= 0 {
+		return nil
+	}
+
+	if b.state == Open {
+		if b.now().Sub(b.openedAt) < time.Duration(b.settings.OpenTimeout) {
+			return ErrBreakerOpen
+		}
+		b.state, b.trials = HalfOpen, 0
+	}
+	if b.state == HalfOpen {
+		if b.trials >= b.settings.HalfOpenRequests {
+			return ErrBreakerOpen
+		}
+		b.trials++
+	}
+
+	return nil
+}
+
+// Record reports the outcome of an allowed call.
+func (b *Breaker) Record(success bool) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if b.settings.FailureThreshold <= 0 {
+		return
+	}
+
+	switch b.state {
+	case HalfOpen:
+		if success {
+			b.state, b.failures = Closed, 0
+		} else {
+			b.open()
+		}
+	case Closed:
+		if success {
+			b.failures = 0
+		} else if b.failures++; b.failures >= b.settings.FailureThreshold {
+			b.open()
+		}
+	}
+}
+
+func (b *Breaker) open() {
+	b.state = Open
+	b.openedAt = b.now()
+}
+
+func (b *Breaker) Status(upstream string) BreakerStatus {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	s := BreakerStatus{Upstream: upstream, State: b.state.String(), Failures: b.failures}
+	if b.state != Closed {
+		openedAt := b.openedAt
+		retryAt := openedAt.Add(time.Duration(b.settings.OpenTimeout))
+		s.OpenedAt, s.RetryAt = &openedAt, &retryAt
+	}
+
+	return s
+}
+
+// Breakers holds one breaker per upstream origin. They outlive route table
+// reloads, since the proxy does.
+type Breakers struct {
+	mu       sync.Mutex
+	settings config.Breaker
+	byOrigin map[string]*Breaker
+}
+
+func NewBreakers(settings config.Breaker) *Breakers {
+	return &Breakers{settings: settings, byOrigin: map[string]*Breaker{}}
+}
+
+func (bs *Breakers) Get(origin string) *Breaker {
+	bs.mu.Lock()
+	defer bs.mu.Unlock()
+
+	b, ok := bs.byOrigin[origin]
+	if !ok {
+		b = NewBreaker(bs.settings)
+		bs.byOrigin[origin] = b
+	}
+
+	return b
+}
+
+// Status lists the breakers sorted by upstream.
+func (bs *Breakers) Status() []BreakerStatus {
+	bs.mu.Lock()
+	defer bs.mu.Unlock()
+
+	statuses := []BreakerStatus{}
+	for origin, b := range bs.byOrigin {
+		statuses = append(statuses, b.Status(origin))
+	}
+	sort.Slice(statuses, func(i, j int) bool { return statuses[i].Upstream < statuses[j].Upstream })
+
+	return statuses
+}
@@ -0,0 +1,82 @@
+package proxy
+
+import (
+	"gateway/config"
+	"testing"
+	"time"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestBreaker(t *testing.T) {
+	Convey("type Breaker", t, func() {
+		now := time.Now()
+		b := NewBreaker(config.Breaker{FailureThreshold: 2, OpenTimeout: config.Duration(time.Minute), HalfOpenRequests: 1})
+		b.now = func() time.Time { return now }
+
+		fail := func() {
+			So(b.Allow(), ShouldBeNil)
+			b.Record(false)
+		}
+
+		Convey("Given a closed breaker", func() {
+			Convey("When failures are interrupted by a success", func() {
+				fail()
+				b.Allow()
+				b.Record(true)
+				fail()
+
+				Convey("Then it stays closed", func() {
+					So(b.Status("u").State, ShouldEqual, "closed")
+					So(b.Allow(), ShouldBeNil)
+				})
+			})
+
+			Convey("When the threshold of failures in a row is reached", func() {
+				fail()
+				fail()
+
+				Convey("Then it opens and fails fast", func() {
+					So(b.Allow(), ShouldEqual, ErrBreakerOpen)
+					status := b.Status("u")
+					So(status.State, ShouldEqual, "open")
+					So(*status.RetryAt, ShouldEqual, now.Add(time.Minute))
+				})
+
+				Convey("Then after the open timeout it lets one trial call through", func() {
+					now = now.Add(time.Minute)
+					So(b.Allow(), ShouldBeNil)
+					So(b.Status("u").State, ShouldEqual, "half-open")
+					So(b.Allow(), ShouldEqual, ErrBreakerOpen)
+
+					Convey("And a successful trial closes it", func() {
+						b.Record(true)
+						So(b.Status("u").State, ShouldEqual, "closed")
+						So(b.Allow(), ShouldBeNil)
+					})
+
+					Convey("And a failed trial opens it again", func() {
+						b.Record(false)
+						So(b.Status("u").State, ShouldEqual, "open")
+						So(b.Allow(), ShouldEqual, ErrBreakerOpen)
+					})
+				})
+			})
+		})
+
+		Convey("Given a breaker without failure threshold", func() {
+			b := NewBreaker(config.Breaker{})
+
+			Convey("When calls keep failing", func() {
+				for i := 0; i < 10; i++ {
+					b.Allow()
+					b.Record(false)
+				}
+
+				Convey("Then it never opens", func() {
+					So(b.Allow(), ShouldBeNil)
+				})
+			})
+		})
+	})
+}
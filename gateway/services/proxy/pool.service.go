@@ -0,0 +1,600 @@
+package proxy
+
+import (
+	"context"
+	"errors"
+	"gateway/config"
+	"gateway/services/discovery"
+	"gateway/services/logging"
+	"net/http"
+	"net/url"
+	"reflect"
+	"sort"
+	"sync"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+var ErrNoHealthyMember = errors.New("No healthy upstream in the pool")
+
+// resolveTimeout bounds the lookup of a service when its pool is created.
+const resolveTimeout = 5 * time.Second
+
+// Strategy decides which of the available members of a pool gets the next
+// call.
+type Strategy string
+
+const (
+	RoundRobin       Strategy = "round-robin"
+	LeastConnections Strategy = "least-connections"
+	Weighted         Strategy = "weighted"
+)
+
+func ParseStrategy(name string) (Strategy, error) {
+	switch Strategy(name) {
+	case "", RoundRobin:
+		return RoundRobin, nil
+	case LeastConnections, Weighted:
+		return Strategy(name), nil
+	default:
+		return "", errors.New("Unknown load balancing strategy: " + name)
+	}
+}
+
+// PoolSpec describes several instances of one upstream service, either
+// listed in Members or looked up by Service name. Nil HealthCheck and
+// Ejection take the proxy defaults.
+type PoolSpec struct {
+	Name        string
+	Strategy    string
+	Members     []MemberSpec
+	Service     string
+	HealthCheck *config.HealthCheck
+	Ejection    *config.Ejection
+}
+
+// MemberSpec is one instance. Weight only matters to the weighted strategy
+// and defaults to 1.
+type MemberSpec struct {
+	URL    string
+	Weight int
+}
+
+type member struct {
+	url    *url.URL
+	weight int
+
+	// Active health checks.
+	healthy        bool
+	probeSuccesses int
+	probeFailures  int
+	lastProbeError string
+	// Passive ejection.
+	failures     int
+	ejectedUntil time.Time
+
+	active  int // calls in flight
+	current int // smooth weighted round-robin counter
+}
+
+func (m *member) origin() string {
+	return m.url.Scheme + "://" + m.url.Host
+}
+
+// Pool balances calls across the members still available: healthy as far as
+// probes tell and not ejected after failing calls.
+type Pool struct {
+	mu          sync.Mutex
+	name        string
+	strategy    Strategy
+	members     []*member
+	next        int
+	healthCheck config.HealthCheck
+	ejection    config.Ejection
+	client      *http.Client
+	stop        context.CancelFunc
+	now         func() time.Time
+
+	service        string
+	discovery      discovery.Provider
+	refresh        time.Duration
+	discoveryError string
+}
+
+type PoolStatus struct {
+	Name           string         `json:"name"`
+	Strategy       string         `json:"strategy"`
+	Service        string         `json:"service,omitempty"`
+	DiscoveryError string         `json:"discoveryError,omitempty"`
+	Members        []MemberStatus `json:"members"`
+}
+
+type MemberStatus struct {
+	URL            string     `json:"url"`
+	Weight         int        `json:"weight"`
+	Available      bool       `json:"available"`
+	Healthy        bool       `json:"healthy"`
+	LastProbeError string     `json:"lastProbeError,omitempty"`
+	Failures       int        `json:"failures"`
+	EjectedUntil   *time.Time `json:"ejectedUntil,omitempty"`
+	ActiveRequests int        `json:"activeRequests"`
+}
+
+// NewPool validates spec. Health checks only start with Start, and the
+// members of a service are only looked up once the pool has a discovery
+// provider; see Pools.
+func NewPool(spec PoolSpec, healthCheck config.HealthCheck, ejection config.Ejection, client *http.Client) (*Pool, error) {
+	strategy, err := ParseStrategy(spec.Strategy)
+	if err != nil {
+		return nil, err
+	}
+	if spec.Service != "" && len(spec.Members) > 0 {
+		return nil, errors.New("Upstream pool cannot have both members and a service: " + spec.Name)
+	}
+	if spec.Service == "" && len(spec.Members) == 0 {
+		return nil, errors.New("Upstream pool has no members: " + spec.Name)
+	}
+	if spec.HealthCheck != nil {
+		healthCheck = *spec.HealthCheck
+	}
+	if spec.Ejection != nil {
+		ejection = *spec.Ejection
+	}
+
+	pool := &Pool{
+		name:        spec.Name,
+		strategy:    strategy,
+		healthCheck: healthCheck,
+		ejection:    ejection,
+		client:      client,
+		now:         time.Now,
+		service:     spec.Service,
+	}
+	if err := pool.setMembers(spec.Members); err != nil {
+		return nil, err
+	}
+
+	return pool, nil
+}
+
+// setMembers replaces the members, keeping the state of those that stay.
+func (p *Pool) setMembers(specs []MemberSpec) error {
+	members := make([]*member, 0, len(specs))
+	for _, spec := range specs {
+		u, err := ParseUpstream(spec.URL)
+		if err != nil {
+			return err
+		}
+		weight := spec.Weight
+		if weight <= 0 {
+			weight = 1
+		}
+		members = append(members, &member{url: u, weight: weight, healthy: true})
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	previous := map[string]*member{}
+	for _, m := range p.members {
+		previous[m.url.String()] = m
+	}
+	for i, m := range members {
+		if kept, ok := previous[m.url.String()]; ok {
+			kept.weight = m.weight
+			members[i] = kept
+		}
+	}
+	p.members = members
+
+	return nil
+}
+
+// Resolve looks the members of a service pool up again. On failure the
+// pool keeps the members it had.
+func (p *Pool) Resolve(ctx context.Context) error {
+	if p.service == "" || p.discovery == nil {
+		return nil
+	}
+
+	instances, err := p.discovery.Instances(ctx, p.service)
+	if err == nil {
+		specs := make([]MemberSpec, 0, len(instances))
+		for _, i := range instances {
+			specs = append(specs, MemberSpec{URL: i.URL, Weight: i.Weight})
+		}
+		err = p.setMembers(specs)
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	p.discoveryError = ""
+	if err != nil {
+		p.discoveryError = err.Error()
+	}
+
+	return err
+}
+
+// singlePool wraps a lone upstream so that every route goes through a pool.
+func singlePool(target *url.URL) *Pool {
+	return &Pool{
+		name:     target.String(),
+		strategy: RoundRobin,
+		members:  []*member{{url: target, weight: 1, healthy: true}},
+		now:      time.Now,
+	}
+}
+
+// pick chooses the member for the next call among those not in skip, or
+// fails when none is available.
+func (p *Pool) pick(skip map[*member]bool) (*member, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	now := p.now()
+	available := make([]*member, 0, len(p.members))
+	for _, m := range p.members {
+		if m.available(now) && !skip[m] {
+			available = append(available, m)
+		}
+	}
+	if len(available) == 0 {
+		return nil, ErrNoHealthyMember
+	}
+
+	switch p.strategy {
+	case Weighted:
+		// Smooth weighted round-robin: heavier members are picked more
+		// often without being picked many times in a row.
+		total := 0
+		var best *member
+		for _, m := range available {
+			m.current += m.weight
+			total += m.weight
+			if best == nil || m.current > best.current {
+				best = m
+			}
+		}
+		best.current -= total
+		return best, nil
+	case LeastConnections:
+		// Ties go round-robin so that an idle pool still spreads calls.
+		start := p.next % len(available)
+		p.next++
+		best := available[start]
+		for i := 1; i < len(available); i++ {
+			if m := available[(start+i)%len(available)]; m.active < best.active {
+				best = m
+			}
+		}
+		return best, nil
+	default:
+		m := available[p.next%len(available)]
+		p.next++
+		return m, nil
+	}
+}
+
+func (m *member) available(now time.Time) bool {
+	return m.healthy && !now.Before(m.ejectedUntil)
+}
+
+// acquire counts a call in flight until the returned function is called.
+func (p *Pool) acquire(m *member) func() {
+	p.mu.Lock()
+	m.active++
+	p.mu.Unlock()
+
+	var once sync.Once
+	return func() {
+		once.Do(func() {
+			p.mu.Lock()
+			m.active--
+			p.mu.Unlock()
+		})
+	}
+}
+
+// record reports the outcome of a call, ejecting the member after too many
+// failures in a row.
+func (p *Pool) record(m *member, success bool) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if success || p.ejection.Consecutive5xx <= 0 {
+		m.failures = 0
+		return
+	}
+	if m.failures++; m.failures >= p.ejection.Consecutive5xx {
+		m.failures = 0
+		m.ejectedUntil = p.now().Add(time.Duration(p.ejection.Duration))
+	}
+}
+
+func (p *Pool) Status() PoolStatus {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	now := p.now()
+	s := PoolStatus{
+		Name:           p.name,
+		Strategy:       string(p.strategy),
+		Service:        p.service,
+		DiscoveryError: p.discoveryError,
+		Members:        []MemberStatus{},
+	}
+	for _, m := range p.members {
+		ms := MemberStatus{
+			URL:            m.url.String(),
+			Weight:         m.weight,
+			Available:      m.available(now),
+			Healthy:        m.healthy,
+			LastProbeError: m.lastProbeError,
+			Failures:       m.failures,
+			ActiveRequests: m.active,
+		}
+		if now.Before(m.ejectedUntil) {
+			until := m.ejectedUntil
+			ms.EjectedUntil = &until
+		}
+		s.Members = append(s.Members, ms)
+	}
+
+	return s
+}
+
+// Start probes the members in the background when the pool has a health
+// check, and looks them up again when it has a service, until Stop.
+func (p *Pool) Start() {
+	ctx, cancel := context.WithCancel(context.Background())
+	p.stop = cancel
+
+	if p.healthCheck.Path != "" && p.client != nil {
+		go func() {
+			p.Probe(ctx)
+			every(ctx, time.Duration(p.healthCheck.Interval), p.Probe)
+		}()
+	}
+	if p.service != "" && p.discovery != nil {
+		go every(ctx, p.refresh, func(ctx context.Context) {
+			if err := p.Resolve(ctx); err != nil && ctx.Err() == nil {
+				logging.Named("proxy").Warn("Service discovery failed, keeping the instances found before", zap.String("service", p.service), zap.Int("instances", len(p.Status().Members)), zap.Error(err))
+			}
+		})
+	}
+}
+
+// every calls fn each interval until ctx is done.
+func every(ctx context.Context, interval time.Duration, fn func(context.Context)) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			fn(ctx)
+		}
+	}
+}
+
+func (p *Pool) Stop() {
+	if p.stop != nil {
+		p.stop()
+	}
+}
+
+// Probe checks every member once, concurrently.
+func (p *Pool) Probe(ctx context.Context) {
+	p.mu.Lock()
+	members := p.members
+	p.mu.Unlock()
+
+	var wg sync.WaitGroup
+	for _, m := range members {
+		wg.Add(1)
+		go func(m *member) {
+			defer wg.Done()
+			// Probes cut short by Stop say nothing about the member.
+			if err := p.probe(ctx, m); ctx.Err() == nil {
+				p.recordProbe(m, err)
+			}
+		}(m)
+	}
+	wg.Wait()
+}
+
+func (p *Pool) probe(ctx context.Context, m *member) error {
+	ctx, cancel := context.WithTimeout(ctx, time.Duration(p.healthCheck.Timeout))
+	defer cancel()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, RewriteURL(m.url, "", p.healthCheck.Path).String(), nil)
+	if err != nil {
+		return err
+	}
+	res, err := p.client.Do(req)
+	if err != nil {
+		return err
+	}
+	res.Body.Close()
+	if res.StatusCode >= 400 {
+		return errors.New(res.Status)
+	}
+
+	return nil
+}
+
+func (p *Pool) recordProbe(m *member, err error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if err == nil {
+		m.lastProbeError = ""
+		m.probeFailures = 0
+		if m.probeSuccesses++; !m.healthy && m.probeSuccesses >= p.healthCheck.HealthyThreshold {
+			m.healthy = true
+		}
+		return
+	}
+
+	m.lastProbeError = err.Error()
+	m.probeSuccesses = 0
+	if m.probeFailures++; m.healthy && m.probeFailures >= p.healthCheck.UnhealthyThreshold {
+		m.healthy = false
+	}
+}
+
+// Pools holds the pools of the active route table. They outlive reloads
+// that leave them unchanged, so that health and in-flight counts carry on.
+type Pools struct {
+	mu          sync.Mutex
+	healthCheck config.HealthCheck
+	ejection    config.Ejection
+	client      *http.Client
+	discovery   discovery.Provider
+	refresh     time.Duration
+	byName      map[string]*Pool
+	specs       map[string]PoolSpec
+}
+
+func NewPools(healthCheck config.HealthCheck, ejection config.Ejection, client *http.Client) *Pools {
+	return &Pools{
+		healthCheck: healthCheck,
+		ejection:    ejection,
+		client:      client,
+		byName:      map[string]*Pool{},
+		specs:       map[string]PoolSpec{},
+	}
+}
+
+// UseDiscovery looks the members of service pools up with provider, again
+// every refresh. Call it before Set.
+func (ps *Pools) UseDiscovery(provider discovery.Provider, refresh time.Duration) {
+	ps.mu.Lock()
+	defer ps.mu.Unlock()
+
+	ps.discovery, ps.refresh = provider, refresh
+}
+
+// Set replaces the pools by those of specs right away.
+func (ps *Pools) Set(specs []PoolSpec) error {
+	set, err := ps.Prepare(specs)
+	if err != nil {
+		return err
+	}
+	set.Commit()
+
+	return nil
+}
+
+// PoolSet holds the pools of specs given to Prepare, which only replace the
+// current pools once committed.
+type PoolSet struct {
+	pools   *Pools
+	byName  map[string]*Pool
+	specs   map[string]PoolSpec
+	started []*Pool
+}
+
+// Prepare builds the pools of specs, keeping the current pools whose spec is
+// unchanged. New and changed pools look their service up, if any, without
+// holding up calls to the current pools; they only start probing once the
+// set is committed.
+func (ps *Pools) Prepare(specs []PoolSpec) (*PoolSet, error) {
+	ps.mu.Lock()
+	current, currentSpecs := ps.byName, ps.specs
+	provider, refresh := ps.discovery, ps.refresh
+	ps.mu.Unlock()
+
+	set := &PoolSet{pools: ps, byName: map[string]*Pool{}, specs: map[string]PoolSpec{}}
+	for _, spec := range specs {
+		set.specs[spec.Name] = spec
+		if pool, ok := current[spec.Name]; ok && reflect.DeepEqual(currentSpecs[spec.Name], spec) {
+			set.byName[spec.Name] = pool
+			continue
+		}
+		pool, err := NewPool(spec, ps.healthCheck, ps.ejection, ps.client)
+		if err != nil {
+			return nil, err
+		}
+		if spec.Service != "" {
+			if provider == nil {
+				return nil, errors.New("Upstream pool needs service discovery: " + spec.Name)
+			}
+			pool.discovery, pool.refresh = provider, refresh
+			// A service unknown for now may still show up later.
+			ctx, cancel := context.WithTimeout(context.Background(), resolveTimeout)
+			if err := pool.Resolve(ctx); err != nil {
+				logging.Named("proxy").Warn("Service discovery failed", zap.String("service", spec.Service), zap.Error(err))
+			}
+			cancel()
+		}
+		set.byName[spec.Name] = pool
+		set.started = append(set.started, pool)
+	}
+
+	return set, nil
+}
+
+func (s *PoolSet) Get(name string) (*Pool, bool) {
+	pool, ok := s.byName[name]
+	return pool, ok
+}
+
+// Commit makes the pools of the set the current ones. Pools left out of it
+// stop and new ones start.
+func (s *PoolSet) Commit() {
+	ps := s.pools
+	ps.mu.Lock()
+	defer ps.mu.Unlock()
+
+	for name, pool := range ps.byName {
+		if s.byName[name] != pool {
+			pool.Stop()
+		}
+	}
+	for _, pool := range s.started {
+		pool.Start()
+	}
+	ps.byName, ps.specs = s.byName, s.specs
+}
+
+// Close stops the background work of every pool.
+func (ps *Pools) Close() {
+	ps.mu.Lock()
+	defer ps.mu.Unlock()
+
+	for _, pool := range ps.byName {
+		pool.Stop()
+	}
+}
+
+func (ps *Pools) Get(name string) (*Pool, bool) {
+	ps.mu.Lock()
+	defer ps.mu.Unlock()
+
+	pool, ok := ps.byName[name]
+	return pool, ok
+}
+
+// Status lists the pools sorted by name.
+func (ps *Pools) Status() []PoolStatus {
+	ps.mu.Lock()
+	pools := make([]*Pool, 0, len(ps.byName))
+	for _, pool := range ps.byName {
+		pools = append(pools, pool)
+	}
+	ps.mu.Unlock()
+
+	statuses := []PoolStatus{}
+	for _, pool := range pools {
+		statuses = append(statuses, pool.Status())
+	}
+	sort.Slice(statuses, func(i, j int) bool { return statuses[i].Name < statuses[j].Name })
+
+	return statuses
+}
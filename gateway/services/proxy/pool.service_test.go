@@ -0,0 +1,407 @@
+package proxy
+
+import (
+	"context"
+	"errors"
+	"gateway/config"
+	"gateway/services/discovery"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+// fakeRegistry stands for a registry such as Consul.
+type fakeRegistry struct {
+	mu       sync.Mutex
+	services map[string][]discovery.Instance
+	err      error
+}
+
+func (r *fakeRegistry) Instances(ctx context.Context, service string) ([]discovery.Instance, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.err != nil {
+		return nil, r.err
+	}
+	instances, ok := r.services[service]
+	if !ok {
+		return nil, discovery.ErrUnknownService
+	}
+	return instances, nil
+}
+
+func picks(p *Pool, n int) []string {
+	hosts := []string{}
+	for i := 0; i < n; i++ {
+		m, err := p.pick(nil)
+		if err != nil {
+			hosts = append(hosts, err.Error())
+			continue
+		}
+		hosts = append(hosts, m.url.Host)
+	}
+	return hosts
+}
+
+func poolForTest(strategy string, members ...MemberSpec) *Pool {
+	pool, err := NewPool(PoolSpec{Name: "orders", Strategy: strategy, Members: members}, config.HealthCheck{}, config.Ejection{}, nil)
+	So(err, ShouldBeNil)
+	return pool
+}
+
+func TestPool(t *testing.T) {
+	a, b, c := MemberSpec{URL: "http://a"}, MemberSpec{URL: "http://b"}, MemberSpec{URL: "http://c"}
+
+	Convey("func NewPool(spec PoolSpec, ...) (*Pool, error)", t, func() {
+		Convey("Given an unknown strategy", func() {
+			Convey("When the function is called", func() {
+				_, err := NewPool(PoolSpec{Name: "orders", Strategy: "random", Members: []MemberSpec{a}}, config.HealthCheck{}, config.Ejection{}, nil)
+
+				Convey("Then it returns an error", func() {
+					So(err, ShouldNotBeNil)
+				})
+			})
+		})
+
+		Convey("Given no members", func() {
+			Convey("When the function is called", func() {
+				_, err := NewPool(PoolSpec{Name: "orders"}, config.HealthCheck{}, config.Ejection{}, nil)
+
+				Convey("Then it returns an error", func() {
+					So(err, ShouldNotBeNil)
+				})
+			})
+		})
+	})
+
+	Convey("func (p *Pool) pick(skip map[*member]bool) (*member, error)", t, func() {
+		Convey("Given a round-robin pool", func() {
+			pool := poolForTest("", a, b, c)
+
+			Convey("When members are picked", func() {
+				Convey("Then they take turns", func() {
+					So(picks(pool, 4), ShouldResemble, []string{"a", "b", "c", "a"})
+				})
+			})
+		})
+
+		Convey("Given a weighted pool", func() {
+			pool := poolForTest("weighted", MemberSpec{URL: "http://a", Weight: 3}, b)
+
+			Convey("When members are picked", func() {
+				Convey("Then they are picked in proportion to their weight, interleaved", func() {
+					So(picks(pool, 4), ShouldResemble, []string{"a", "a", "b", "a"})
+				})
+			})
+		})
+
+		Convey("Given a least-connections pool with a busy member", func() {
+			pool := poolForTest("least-connections", a, b, c)
+			first, _ := pool.pick(nil)
+			pool.acquire(first)
+
+			Convey("When members are picked", func() {
+				Convey("Then the busy member is skipped", func() {
+					hosts := picks(pool, 4)
+					So(hosts, ShouldNotContain, "a")
+					So(hosts, ShouldContain, "b")
+					So(hosts, ShouldContain, "c")
+				})
+			})
+
+			Convey("When its call is done", func() {
+				release := pool.acquire(first)
+				release()
+				release()
+
+				Convey("Then it is counted only once", func() {
+					So(pool.Status().Members[0].ActiveRequests, ShouldEqual, 1)
+				})
+			})
+		})
+
+		Convey("Given a pool ejecting after 2 failures in a row", func() {
+			now := time.Now()
+			pool, _ := NewPool(PoolSpec{Name: "orders", Members: []MemberSpec{a, b}}, config.HealthCheck{}, config.Ejection{Consecutive5xx: 2, Duration: config.Duration(time.Minute)}, nil)
+			pool.now = func() time.Time { return now }
+			first := pool.members[0]
+
+			Convey("When a member fails twice in a row", func() {
+				pool.record(first, false)
+				pool.record(first, false)
+
+				Convey("Then it takes no calls until the ejection ends", func() {
+					So(picks(pool, 2), ShouldResemble, []string{"b", "b"})
+					So(pool.Status().Members[0].Available, ShouldBeFalse)
+					So(*pool.Status().Members[0].EjectedUntil, ShouldEqual, now.Add(time.Minute))
+
+					now = now.Add(time.Minute)
+					So(picks(pool, 2), ShouldContain, "a")
+				})
+			})
+
+			Convey("When its failures are interrupted by a success", func() {
+				pool.record(first, false)
+				pool.record(first, true)
+				pool.record(first, false)
+
+				Convey("Then it stays available", func() {
+					So(pool.Status().Members[0].Available, ShouldBeTrue)
+				})
+			})
+
+			Convey("When every member is ejected", func() {
+				for _, m := range pool.members {
+					pool.record(m, false)
+					pool.record(m, false)
+				}
+
+				Convey("Then picking fails", func() {
+					_, err := pool.pick(nil)
+					So(err, ShouldEqual, ErrNoHealthyMember)
+				})
+			})
+		})
+	})
+
+	Convey("func (p *Pool) Probe(ctx context.Context)", t, func() {
+		healthy := true
+		up := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if r.URL.Path != "/healthz" || !healthy {
+				w.WriteHeader(http.StatusServiceUnavailable)
+			}
+		}))
+		t.Cleanup(up.Close)
+		down := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+		down.Close()
+
+		hc := config.HealthCheck{Path: "/healthz", Interval: config.Duration(time.Hour), Timeout: config.Duration(time.Second), HealthyThreshold: 2, UnhealthyThreshold: 2}
+		pool, _ := NewPool(PoolSpec{Name: "orders", Members: []MemberSpec{{URL: up.URL}, {URL: down.URL}}}, hc, config.Ejection{}, http.DefaultClient)
+
+		Convey("Given a member that cannot be reached", func() {
+			Convey("When it fails fewer probes than the unhealthy threshold", func() {
+				pool.Probe(context.Background())
+
+				Convey("Then it stays in the pool", func() {
+					So(pool.Status().Members[1].Available, ShouldBeTrue)
+					So(pool.Status().Members[1].LastProbeError, ShouldNotBeEmpty)
+				})
+			})
+
+			Convey("When it fails as many probes as the unhealthy threshold", func() {
+				pool.Probe(context.Background())
+				pool.Probe(context.Background())
+
+				Convey("Then it is taken out while the other stays", func() {
+					status := pool.Status()
+					So(status.Members[0].Healthy, ShouldBeTrue)
+					So(status.Members[1].Healthy, ShouldBeFalse)
+					So(picks(pool, 2), ShouldResemble, []string{up.Listener.Addr().String(), up.Listener.Addr().String()})
+				})
+			})
+		})
+
+		Convey("Given a member that went unhealthy", func() {
+			healthy = false
+			pool.Probe(context.Background())
+			pool.Probe(context.Background())
+			So(pool.Status().Members[0].Healthy, ShouldBeFalse)
+
+			Convey("When it passes probes again", func() {
+				healthy = true
+				pool.Probe(context.Background())
+				once := pool.Status().Members[0].Healthy
+				pool.Probe(context.Background())
+
+				Convey("Then it is re-admitted after the healthy threshold", func() {
+					So(once, ShouldBeFalse)
+					So(pool.Status().Members[0].Healthy, ShouldBeTrue)
+				})
+			})
+		})
+	})
+
+	Convey("func (p *Pool) Resolve(ctx context.Context) error", t, func() {
+		registry := &fakeRegistry{services: map[string][]discovery.Instance{
+			"orders": {{URL: "http://a"}, {URL: "http://b", Weight: 2}},
+		}}
+		pools := NewPools(config.HealthCheck{}, config.Ejection{Consecutive5xx: 1, Duration: config.Duration(time.Minute)}, nil)
+		pools.UseDiscovery(registry, time.Hour)
+
+		Convey("Given a pool naming a service", func() {
+			err := pools.Set([]PoolSpec{{Name: "orders", Service: "orders"}})
+			pool, _ := pools.Get("orders")
+
+			Convey("When the pool is set", func() {
+				Convey("Then its members are looked up right away", func() {
+					So(err, ShouldBeNil)
+					status := pool.Status()
+					So(status.Service, ShouldEqual, "orders")
+					So(len(status.Members), ShouldEqual, 2)
+					So(status.Members[1].Weight, ShouldEqual, 2)
+				})
+			})
+
+			Convey("When the instances change", func() {
+				pool.record(pool.members[1], false)
+				registry.services["orders"] = []discovery.Instance{{URL: "http://b"}, {URL: "http://c"}}
+				err := pool.Resolve(context.Background())
+
+				Convey("Then the members follow, keeping the state of those that stay", func() {
+					So(err, ShouldBeNil)
+					status := pool.Status()
+					So(status.Members[0].URL, ShouldEqual, "http://b")
+					So(status.Members[0].Available, ShouldBeFalse)
+					So(status.Members[1].URL, ShouldEqual, "http://c")
+				})
+			})
+
+			Convey("When the registry fails", func() {
+				registry.err = errors.New("registry unavailable")
+				err := pool.Resolve(context.Background())
+
+				Convey("Then the pool keeps its members and reports the error", func() {
+					So(err, ShouldNotBeNil)
+					So(len(pool.Status().Members), ShouldEqual, 2)
+					So(pool.Status().DiscoveryError, ShouldEqual, "registry unavailable")
+				})
+			})
+		})
+
+		Convey("Given a pool naming a service the registry does not know yet", func() {
+			err := pools.Set([]PoolSpec{{Name: "catalog", Service: "catalog"}})
+			pool, _ := pools.Get("catalog")
+
+			Convey("When the pool is set", func() {
+				Convey("Then it is kept, without members until the service shows up", func() {
+					So(err, ShouldBeNil)
+					_, pickErr := pool.pick(nil)
+					So(pickErr, ShouldEqual, ErrNoHealthyMember)
+
+					registry.services["catalog"] = []discovery.Instance{{URL: "http://d"}}
+					pool.Resolve(context.Background())
+					So(picks(pool, 1), ShouldResemble, []string{"d"})
+				})
+			})
+		})
+
+		Convey("Given no discovery provider", func() {
+			pools := NewPools(config.HealthCheck{}, config.Ejection{}, nil)
+
+			Convey("When a pool naming a service is set", func() {
+				err := pools.Set([]PoolSpec{{Name: "orders", Service: "orders"}})
+
+				Convey("Then it returns an error", func() {
+					So(err, ShouldNotBeNil)
+				})
+			})
+		})
+	})
+
+	Convey("func (ps *Pools) Set(specs []PoolSpec) error", t, func() {
+		Convey("Given pools already set", func() {
+			pools := NewPools(config.HealthCheck{}, config.Ejection{}, nil)
+			pools.Set([]PoolSpec{{Name: "orders", Members: []MemberSpec{a}}, {Name: "catalog", Members: []MemberSpec{b}}})
+			orders, _ := pools.Get("orders")
+			catalog, _ := pools.Get("catalog")
+
+			Convey("When they are set again with one pool changed and one removed", func() {
+				err := pools.Set([]PoolSpec{{Name: "orders", Members: []MemberSpec{a}}, {Name: "catalog", Members: []MemberSpec{c}}})
+				sameOrders, _ := pools.Get("orders")
+				newCatalog, _ := pools.Get("catalog")
+
+				Convey("Then unchanged pools keep their state and changed ones are replaced", func() {
+					So(err, ShouldBeNil)
+					So(sameOrders, ShouldEqual, orders)
+					So(newCatalog, ShouldNotEqual, catalog)
+					So(len(pools.Status()), ShouldEqual, 2)
+				})
+			})
+
+			Convey("When an invalid pool is set", func() {
+				err := pools.Set([]PoolSpec{{Name: "orders"}})
+
+				Convey("Then the previous pools are kept", func() {
+					So(err, ShouldNotBeNil)
+					So(pools.Status()[0].Name, ShouldEqual, "catalog")
+					So(len(pools.Status()), ShouldEqual, 2)
+				})
+			})
+		})
+	})
+
+	Convey("func (ps *Pools) Prepare(specs []PoolSpec) (*PoolSet, error)", t, func() {
+		Convey("Given pools already set", func() {
+			pools := NewPools(config.HealthCheck{}, config.Ejection{}, nil)
+			pools.Set([]PoolSpec{{Name: "orders", Members: []MemberSpec{a}}, {Name: "catalog", Members: []MemberSpec{b}}})
+			catalog, _ := pools.Get("catalog")
+
+			Convey("When a set with a changed pool is prepared", func() {
+				set, err := pools.Prepare([]PoolSpec{{Name: "orders", Members: []MemberSpec{a}}, {Name: "catalog", Members: []MemberSpec{c}}})
+				current, _ := pools.Get("catalog")
+				prepared, _ := set.Get("catalog")
+
+				Convey("Then the current pools are kept until the set is committed", func() {
+					So(err, ShouldBeNil)
+					So(current, ShouldEqual, catalog)
+					So(prepared, ShouldNotEqual, catalog)
+
+					set.Commit()
+					committed, _ := pools.Get("catalog")
+					So(committed, ShouldEqual, prepared)
+				})
+			})
+		})
+
+		Convey("Given a registry slow to answer", func() {
+			registry := &slowRegistry{called: make(chan struct{}), release: make(chan struct{})}
+			pools := NewPools(config.HealthCheck{}, config.Ejection{}, nil)
+			pools.Set([]PoolSpec{{Name: "orders", Members: []MemberSpec{a}}})
+			pools.UseDiscovery(registry, time.Minute)
+
+			Convey("When a pool naming a service is prepared", func() {
+				prepared := make(chan error)
+				go func() {
+					_, err := pools.Prepare([]PoolSpec{{Name: "catalog", Service: "catalog"}})
+					prepared <- err
+				}()
+				<-registry.called
+
+				got := make(chan bool)
+				go func() {
+					_, ok := pools.Get("orders")
+					got <- ok
+				}()
+
+				Convey("Then the current pools are not held up by the lookup", func() {
+					select {
+					case ok := <-got:
+						So(ok, ShouldBeTrue)
+					case <-time.After(time.Second):
+						So("Get", ShouldEqual, "returned while the service was looked up")
+					}
+					close(registry.release)
+					So(<-prepared, ShouldBeNil)
+				})
+			})
+		})
+	})
+}
+
+// slowRegistry answers once released.
+type slowRegistry struct {
+	called  chan struct{}
+	release chan struct{}
+}
+
+func (r *slowRegistry) Instances(ctx context.Context, service string) ([]discovery.Instance, error) {
+	close(r.called)
+	<-r.release
+
+	return []discovery.Instance{{URL: "http://d"}}, nil
+}
@@ -0,0 +1,518 @@
+package proxy
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"gateway/config"
+	"gateway/services/discovery"
+	"gateway/services/metrics"
+	"gateway/services/tracing"
+	"gateway/utils"
+	"io"
+	"math/rand"
+	"net"
+	"net/http"
+	"net/textproto"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/propagation"
+	semconv "go.opentelemetry.io/otel/semconv/v1.12.0"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// Route maps a public path prefix to an upstream base URL, or to the pool
+// named Pool. Everything after the prefix is appended to the upstream path,
+// optionally behind Rewrite. A zero Timeout leaves the upstream call bounded
+// only by the client. Retry overrides the retry policy of the proxy.
+type Route struct {
+	Prefix   string
+	Upstream string
+	Pool     string
+	Rewrite  string
+	Timeout  time.Duration
+	Retry    *config.Retry
+}
+
+// Headers that only make sense for a single connection and must not be
+// forwarded in either direction, along with those a Connection header names.
+var hopHeaders = []string{
+	"Connection",
+	"Keep-Alive",
+	"Proxy-Authenticate",
+	"Proxy-Authorization",
+	"Proxy-Connection",
+	"Te",
+	"Trailer",
+	"Transfer-Encoding",
+	"Upgrade",
+}
+
+// Headers the gateway sets on responses itself. The upstream's copies are
+// dropped where the gateway has already set one.
+var gatewayHeaders = map[string]bool{
+	http.CanonicalHeaderKey(fiber.HeaderXRequestID): true,
+	http.CanonicalHeaderKey("RateLimit-Limit"):      true,
+	http.CanonicalHeaderKey("RateLimit-Remaining"):  true,
+	http.CanonicalHeaderKey("RateLimit-Reset"):      true,
+}
+
+// Methods that can be sent again without changing the outcome.
+var idempotentMethods = map[string]bool{
+	fiber.MethodGet:     true,
+	fiber.MethodHead:    true,
+	fiber.MethodOptions: true,
+	fiber.MethodPut:     true,
+	fiber.MethodDelete:  true,
+	fiber.MethodTrace:   true,
+}
+
+type Proxy struct {
+	client   *http.Client
+	breakers *Breakers
+	pools    *Pools
+	retry    config.Retry
+}
+
+// New returns a proxy without circuit breakers, retries, health checks or
+// ejection; see Configure.
+func New(client *http.Client) *Proxy {
+	if client == nil {
+		client = &http.Client{}
+	}
+	// Redirects are the client's business, not the gateway's. The copy leaves
+	// the caller's client as it was.
+	copied := *client
+	client = &copied
+	client.CheckRedirect = func(*http.Request, []*http.Request) error {
+		return http.ErrUseLastResponse
+	}
+
+	return &Proxy{
+		client:   client,
+		breakers: NewBreakers(config.Breaker{}),
+		pools:    NewPools(config.HealthCheck{}, config.Ejection{}, client),
+	}
+}
+
+// Configure sets the circuit breakers, the default retry policy and the
+// default health check and ejection of pools. Call it before the proxy serves
+// requests or has pools.
+func (p *Proxy) Configure(cfg config.Upstream) *Proxy {
+	p.breakers = NewBreakers(cfg.Breaker)
+	p.pools = NewPools(cfg.HealthCheck, cfg.Ejection, p.client)
+	p.retry = cfg.Retry
+
+	return p
+}
+
+// UseDiscovery looks up the instances of the services pools name with
+// provider, again every refresh. Call it before SetPools.
+func (p *Proxy) UseDiscovery(provider discovery.Provider, refresh time.Duration) *Proxy {
+	p.pools.UseDiscovery(provider, refresh)
+
+	return p
+}
+
+// SetPools replaces the upstream pools routes can target. Call it before
+// building the handlers of the routes using them.
+func (p *Proxy) SetPools(specs []PoolSpec) error {
+	return p.pools.Set(specs)
+}
+
+// PreparePools builds the pools of specs without replacing the current ones.
+// Build the handlers of routes using them with HandlerFor, and commit the set
+// once those handlers serve.
+func (p *Proxy) PreparePools(specs []PoolSpec) (*PoolSet, error) {
+	return p.pools.Prepare(specs)
+}
+
+// Pools lists the members of every pool and whether they take calls.
+func (p *Proxy) Pools() []PoolStatus {
+	return p.pools.Status()
+}
+
+// Breakers lists the state of the circuit breaker of every upstream called
+// so far.
+func (p *Proxy) Breakers() []BreakerStatus {
+	return p.breakers.Status()
+}
+
+// Close stops probing and looking up pool members.
+func (p *Proxy) Close() {
+	p.pools.Close()
+}
+
+// Check fails while a pool has no member taking calls or a circuit is open.
+func (p *Proxy) Check(ctx context.Context) error {
+	var problems []string
+	for _, pool := range p.pools.Status() {
+		available := 0
+		for _, m := range pool.Members {
+			if m.Available {
+				available++
+			}
+		}
+		if available == 0 {
+			problems = append(problems, "pool "+pool.Name+" has no available member")
+		}
+	}
+	for _, b := range p.breakers.Status() {
+		if b.State == Open.String() {
+			problems = append(problems, "circuit of "+b.Upstream+" is open")
+		}
+	}
+	if len(problems) > 0 {
+		return errors.New(strings.Join(problems, "; "))
+	}
+
+	return nil
+}
+
+// Handler returns a fiber.Handler forwarding every request under route.Prefix.
+// It must be mounted on a wildcard path so that c.Params("*") holds the rest.
+func (p *Proxy) Handler(route Route) (fiber.Handler, error) {
+	return p.handler(route, p.pools.Get)
+}
+
+// HandlerFor is Handler for a route targeting a pool of pools, which may not
+// be committed yet.
+func (p *Proxy) HandlerFor(pools *PoolSet, route Route) (fiber.Handler, error) {
+	return p.handler(route, pools.Get)
+}
+
+func (p *Proxy) handler(route Route, lookup func(name string) (*Pool, bool)) (fiber.Handler, error) {
+	var pool *Pool
+	if route.Pool != "" {
+		var ok bool
+		if pool, ok = lookup(route.Pool); !ok {
+			return nil, errors.New("Unknown upstream pool: " + route.Pool)
+		}
+	} else {
+		target, err := ParseUpstream(route.Upstream)
+		if err != nil {
+			return nil, err
+		}
+		pool = singlePool(target)
+	}
+	retry := p.retry
+	if route.Retry != nil {
+		retry = *route.Retry
+	}
+
+	return func(c *fiber.Ctx) error {
+		return p.forward(c, pool, route.Rewrite, c.Params("*"), route.Timeout, retry)
+	}, nil
+}
+
+// ParseUpstream validates an upstream base URL.
+func ParseUpstream(upstream string) (*url.URL, error) {
+	target, err := url.Parse(upstream)
+	if err != nil {
+		return nil, err
+	}
+	if target.Scheme != "http" && target.Scheme != "https" {
+		return nil, errors.New("Upstream must be an absolute http(s) URL: " + upstream)
+	}
+	if target.Host == "" {
+		return nil, errors.New("Upstream has no host: " + upstream)
+	}
+
+	return target, nil
+}
+
+// RewriteURL joins the upstream base path, the rewrite prefix and the part of
+// the request path matched by the route wildcard.
+func RewriteURL(target *url.URL, rewrite, rest string) *url.URL {
+	u := *target
+	u.Path = joinPath(joinPath(target.Path, rewrite), rest)
+	u.RawPath = ""
+	u.RawQuery = ""
+
+	return &u
+}
+
+func joinPath(a, b string) string {
+	if b == "" {
+		return a
+	}
+
+	return strings.TrimRight(a, "/") + "/" + strings.TrimLeft(b, "/")
+}
+
+// Forward sends the current request to target, keeping the query string, and
+// streams the upstream response back to the client. The timeout, when set,
+// covers the whole exchange including streaming the response body.
+func (p *Proxy) Forward(c *fiber.Ctx, target *url.URL, timeout time.Duration) error {
+	return p.forward(c, singlePool(target), "", "", timeout, p.retry)
+}
+
+// forward calls a member of pool through its circuit breaker and retries
+// idempotent requests that could not get a usable response, picking the
+// member again for every attempt.
+func (p *Proxy) forward(c *fiber.Ctx, pool *Pool, rewrite, rest string, timeout time.Duration, retry config.Retry) error {
+	var ctx context.Context
+	var cancel context.CancelFunc
+	if timeout > 0 {
+		ctx, cancel = context.WithTimeout(c.UserContext(), timeout)
+	} else {
+		ctx, cancel = context.WithCancel(c.UserContext())
+	}
+
+	req, err := http.NewRequestWithContext(ctx, c.Method(), "", nil)
+	if err != nil {
+		cancel()
+		return utils.JSONError(c, fiber.StatusInternalServerError, err, nil)
+	}
+	copyRequestHeaders(c, req)
+	body := c.Body()
+
+	if !idempotentMethods[req.Method] {
+		retry.Attempts = 0
+	}
+	query := string(c.Request().URI().QueryString())
+
+	var res *http.Response
+	var origin string
+	// Members whose breaker refused a call are skipped for the rest of the
+	// request, without using up an attempt.
+	refused := map[*member]bool{}
+	for attempt := 0; ; attempt++ {
+		var m *member
+		var breaker *Breaker
+		m, breaker, err = p.pickAllowed(pool, refused)
+		if m != nil {
+			origin = m.origin()
+		}
+		if err != nil {
+			break
+		}
+		u := RewriteURL(m.url, rewrite, rest)
+		u.RawQuery = query
+		started := time.Now()
+		res, err = p.try(req, u, pool.acquire(m), body, time.Duration(retry.PerTryTimeout))
+		status := 0
+		if err == nil {
+			status = res.StatusCode
+		}
+		metrics.UpstreamCall(origin, status, attempt > 0, time.Since(started))
+		success := err == nil && res.StatusCode < 500
+		breaker.Record(success)
+		pool.record(m, success)
+
+		if (err == nil && !retryableStatus(res.StatusCode)) || attempt >= retry.Attempts {
+			break
+		}
+		if err == nil {
+			io.Copy(io.Discard, io.LimitReader(res.Body, 4096))
+			res.Body.Close()
+			res = nil
+		}
+		if !sleep(ctx, backoff(retry, attempt)) {
+			err = ctx.Err()
+			break
+		}
+	}
+
+	if err != nil {
+		cancel()
+		if errors.Is(err, ErrBreakerOpen) {
+			return utils.JSONStatus(c, fiber.StatusServiceUnavailable, fiber.ErrServiceUnavailable.Message, fiber.Map{"upstream": origin})
+		}
+		if errors.Is(err, ErrNoHealthyMember) {
+			return utils.JSONStatus(c, fiber.StatusServiceUnavailable, fiber.ErrServiceUnavailable.Message, fiber.Map{"pool": pool.name})
+		}
+		return upstreamError(c, err)
+	}
+
+	c.Status(res.StatusCode)
+	removeHopHeaders(res.Header)
+	for k, vs := range res.Header {
+		if gatewayHeaders[k] && len(c.Response().Header.Peek(k)) > 0 {
+			continue
+		}
+		for _, v := range vs {
+			c.Response().Header.Add(k, v)
+		}
+	}
+	// fasthttp closes the stream once it has been written to the client.
+	c.Context().SetBodyStream(&cancelOnClose{ReadCloser: res.Body, cancel: cancel}, int(res.ContentLength))
+
+	return nil
+}
+
+// pickAllowed picks a member of pool whose circuit breaker lets a call
+// through, adding the members it refuses to refused. It fails with
+// ErrBreakerOpen, and the last member refused, when the only members left
+// have an open breaker.
+func (p *Proxy) pickAllowed(pool *Pool, refused map[*member]bool) (*member, *Breaker, error) {
+	var last *member
+	for {
+		m, err := pool.pick(refused)
+		if err != nil {
+			if last != nil {
+				return last, nil, ErrBreakerOpen
+			}
+			metrics.UpstreamRejected(pool.name, "no_healthy_member")
+			return nil, nil, err
+		}
+
+		breaker := p.breakers.Get(m.origin())
+		if err := breaker.Allow(); err == nil {
+			return m, breaker, nil
+		}
+		metrics.UpstreamRejected(m.origin(), "breaker_open")
+		refused[m] = true
+		last = m
+	}
+}
+
+// try makes one attempt at target in a client span of its own, whose trace
+// context the upstream receives, calling release once the response is done
+// with. perTry, when set, bounds the wait for the response headers only,
+// so that the body can still stream for as long as the route timeout allows.
+func (p *Proxy) try(req *http.Request, target *url.URL, release func(), body []byte, perTry time.Duration) (*http.Response, error) {
+	ctx, span := tracing.Tracer().Start(req.Context(), "HTTP "+req.Method, trace.WithSpanKind(trace.SpanKindClient))
+	ctx, cancelCtx := context.WithCancel(ctx)
+	cancel := func() {
+		cancelCtx()
+		release()
+		span.End()
+	}
+	attempt := req.Clone(ctx)
+	attempt.URL = target
+	attempt.Host = target.Host
+	span.SetAttributes(semconv.HTTPClientAttributesFromHTTPRequest(attempt)...)
+	tracing.Inject(ctx, propagation.HeaderCarrier(attempt.Header))
+	attempt.Body = http.NoBody
+	if len(body) > 0 {
+		attempt.Body = io.NopCloser(bytes.NewReader(body))
+		attempt.GetBody = func() (io.ReadCloser, error) {
+			return io.NopCloser(bytes.NewReader(body)), nil
+		}
+	}
+
+	var timer *time.Timer
+	if perTry > 0 {
+		timer = time.AfterFunc(perTry, cancelCtx)
+	}
+	res, err := p.client.Do(attempt)
+	// A timer firing after the headers arrived has cancelled the body too.
+	if expired := timer != nil && !timer.Stop(); expired && req.Context().Err() == nil {
+		if err == nil {
+			res.Body.Close()
+		}
+		err = fmt.Errorf("no response within %s: %w", perTry, context.DeadlineExceeded)
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+		cancel()
+		return nil, err
+	}
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+		cancel()
+		return nil, err
+	}
+	span.SetAttributes(semconv.HTTPStatusCodeKey.Int(res.StatusCode))
+	span.SetStatus(semconv.SpanStatusFromHTTPStatusCodeAndSpanKind(res.StatusCode, trace.SpanKindClient))
+	res.Body = &cancelOnClose{ReadCloser: res.Body, cancel: cancel}
+
+	return res, nil
+}
+
+func retryableStatus(status int) bool {
+	return status == http.StatusBadGateway || status == http.StatusServiceUnavailable || status == http.StatusGatewayTimeout
+}
+
+// backoff doubles retry.Backoff for every attempt up to retry.MaxBackoff and
+// picks a random wait between half and all of it, so that clients retrying
+// together spread out.
+func backoff(retry config.Retry, attempt int) time.Duration {
+	d := time.Duration(retry.Backoff)
+	for i := 0; i < attempt && d < time.Duration(retry.MaxBackoff); i++ {
+		d *= 2
+	}
+	if max := time.Duration(retry.MaxBackoff); d > max {
+		d = max
+	}
+	if d <= 0 {
+		return 0
+	}
+
+	return d/2 + time.Duration(rand.Int63n(int64(d/2)+1))
+}
+
+// sleep waits for d unless ctx is done first, which it reports as false.
+func sleep(ctx context.Context, d time.Duration) bool {
+	t := time.NewTimer(d)
+	defer t.Stop()
+
+	select {
+	case <-t.C:
+		return true
+	case <-ctx.Done():
+		return false
+	}
+}
+
+type cancelOnClose struct {
+	io.ReadCloser
+	cancel context.CancelFunc
+}
+
+func (b *cancelOnClose) Close() error {
+	defer b.cancel()
+	return b.ReadCloser.Close()
+}
+
+func copyRequestHeaders(c *fiber.Ctx, req *http.Request) {
+	c.Request().Header.VisitAll(func(k, v []byte) {
+		req.Header.Add(string(k), string(v))
+	})
+	removeHopHeaders(req.Header)
+	req.Header.Del("Host")
+	req.Header.Del("Content-Length")
+	req.ContentLength = int64(len(c.Body()))
+
+	forwardedFor := c.IP()
+	if prior := req.Header.Get(fiber.HeaderXForwardedFor); prior != "" {
+		forwardedFor = prior + ", " + forwardedFor
+	}
+	req.Header.Set(fiber.HeaderXForwardedFor, forwardedFor)
+	req.Header.Set(fiber.HeaderXForwardedHost, c.Hostname())
+	req.Header.Set(fiber.HeaderXForwardedProto, c.Protocol())
+	// Upstreams log under the same ID, not whatever the client sent.
+	if id := utils.RequestID(c); id != "" {
+		req.Header.Set(fiber.HeaderXRequestID, id)
+	}
+}
+
+// removeHopHeaders deletes hopHeaders from h, and the headers its Connection
+// header lists as hop-by-hop too (RFC 7230, section 6.1).
+func removeHopHeaders(h http.Header) {
+	for _, v := range h.Values("Connection") {
+		for _, name := range strings.Split(v, ",") {
+			if name = textproto.TrimString(name); name != "" {
+				h.Del(name)
+			}
+		}
+	}
+	for _, name := range hopHeaders {
+		h.Del(name)
+	}
+}
+
+func upstreamError(c *fiber.Ctx, err error) error {
+	var netErr net.Error
+	if errors.Is(err, context.DeadlineExceeded) || (errors.As(err, &netErr) && netErr.Timeout()) {
+		return utils.JSONStatus(c, fiber.StatusGatewayTimeout, fiber.ErrGatewayTimeout.Message, nil)
+	}
+
+	return utils.JSONStatus(c, fiber.StatusBadGateway, fiber.ErrBadGateway.Message, nil)
+}
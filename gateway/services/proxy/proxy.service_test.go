@@ -0,0 +1,468 @@
+package proxy
+
+import (
+	"encoding/json"
+	"gateway/config"
+	mw "gateway/middlewares"
+	"gateway/services/security"
+	"gateway/services/tracing"
+	"gateway/services/tracing/tracingtest"
+	"gateway/utils"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	. "github.com/smartystreets/goconvey/convey"
+	"go.opentelemetry.io/otel/trace"
+)
+
+type upstreamEcho struct {
+	Method        string      `json:"method"`
+	Path          string      `json:"path"`
+	Query         string      `json:"query"`
+	Body          string      `json:"body"`
+	Header        http.Header `json:"header"`
+	ForwardedHost string      `json:"forwardedHost"`
+}
+
+func TestProxyService(t *testing.T) {
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		w.Header().Set("Content-Type", "application/json")
+		w.Header().Set("X-Upstream", "orders")
+		w.Header().Set("Connection", "close")
+		w.WriteHeader(http.StatusCreated)
+		json.NewEncoder(w).Encode(upstreamEcho{
+			Method:        r.Method,
+			Path:          r.URL.Path,
+			Query:         r.URL.RawQuery,
+			Body:          string(body),
+			Header:        r.Header,
+			ForwardedHost: r.Header.Get("X-Forwarded-Host"),
+		})
+	}))
+	t.Cleanup(upstream.Close)
+
+	Convey("func New(client *http.Client) *Proxy", t, func() {
+		Convey("Given a client shared with the caller", func() {
+			client := &http.Client{Timeout: time.Second}
+
+			Convey("When a proxy is built with it", func() {
+				p := New(client)
+
+				Convey("Then the proxy stops at redirects without changing the caller's client", func() {
+					So(client.CheckRedirect, ShouldBeNil)
+					So(p.client.CheckRedirect, ShouldNotBeNil)
+					So(p.client.Timeout, ShouldEqual, time.Second)
+				})
+			})
+		})
+	})
+
+	Convey("func (p *Proxy) Handler(route Route) (fiber.Handler, error)", t, func() {
+		Convey("Given an upstream URL that is not absolute", func() {
+			Convey("When the function is called", func() {
+				_, err := New(nil).Handler(Route{Prefix: "/v1/orders", Upstream: "orders:8080"})
+
+				Convey("Then it returns an error", func() {
+					So(err, ShouldNotBeNil)
+				})
+			})
+		})
+
+		Convey("Given a route mounted under /api", func() {
+			app := fiber.New()
+			h, err := New(nil).Handler(Route{Prefix: "/v1/orders", Upstream: upstream.URL + "/internal", Rewrite: "/orders"})
+			So(err, ShouldBeNil)
+			app.All("/api/v1/orders/*", h)
+
+			Convey("When a request is sent under the prefix", func() {
+				req := httptest.NewRequest("POST", "http://gateway.local/api/v1/orders/42/items?page=2&sort=asc", strings.NewReader(`{"qty":1}`))
+				req.Header.Set("Content-Type", "application/json")
+				req.Header.Set("Authorization", "Bearer token")
+				req.Header.Set("Proxy-Authorization", "Basic secret")
+				res, _ := app.Test(req)
+				echo := upstreamEcho{}
+				json.NewDecoder(res.Body).Decode(&echo)
+
+				Convey("Then the path is rewritten onto the upstream base path", func() {
+					So(echo.Method, ShouldEqual, "POST")
+					So(echo.Path, ShouldEqual, "/internal/orders/42/items")
+					So(echo.Query, ShouldEqual, "page=2&sort=asc")
+				})
+
+				Convey("Then the body and end-to-end headers are forwarded", func() {
+					So(echo.Body, ShouldEqual, `{"qty":1}`)
+					So(echo.Header.Get("Authorization"), ShouldEqual, "Bearer token")
+					So(echo.Header.Get("Proxy-Authorization"), ShouldBeBlank)
+					So(echo.Header.Get("X-Forwarded-For"), ShouldNotBeBlank)
+					So(echo.ForwardedHost, ShouldEqual, "gateway.local")
+				})
+
+				Convey("Then the upstream status and headers are sent back", func() {
+					So(res.StatusCode, ShouldEqual, fiber.StatusCreated)
+					So(res.Header.Get("X-Upstream"), ShouldEqual, "orders")
+				})
+			})
+
+			Convey("When the prefix itself is requested", func() {
+				req := httptest.NewRequest("GET", "http://gateway.local/api/v1/orders", nil)
+				res, _ := app.Test(req)
+				echo := upstreamEcho{}
+				json.NewDecoder(res.Body).Decode(&echo)
+
+				Convey("Then it is forwarded to the rewrite root", func() {
+					So(echo.Path, ShouldEqual, "/internal/orders")
+				})
+			})
+		})
+
+		Convey("Given a protected route", func() {
+			app := fiber.New()
+			h, _ := New(nil).Handler(Route{Prefix: "/v1/orders", Upstream: upstream.URL})
+			auth, _ := security.New(config.Security{JWTSecret: "secret"})
+			app.All("/api/v1/orders/*", mw.Protected(auth), h)
+
+			Convey("When a request without access token is sent", func() {
+				req := httptest.NewRequest("GET", "http://gateway.local/api/v1/orders/1", nil)
+				res, _ := app.Test(req)
+
+				Convey("Then it is rejected before reaching the upstream", func() {
+					So(res.StatusCode, ShouldEqual, fiber.StatusUnauthorized)
+				})
+			})
+		})
+
+		Convey("Given headers listed in a Connection header", func() {
+			hopping := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				w.Header().Set("Connection", "X-Upstream-Hop")
+				w.Header().Set("X-Upstream-Hop", "upstream")
+				w.Header().Set("X-Client-Hop", r.Header.Get("X-Client-Hop"))
+				w.Header().Set("X-End-To-End", r.Header.Get("X-End-To-End"))
+			}))
+			defer hopping.Close()
+			app := fiber.New()
+			h, _ := New(nil).Handler(Route{Prefix: "/v1/orders", Upstream: hopping.URL})
+			app.All("/api/v1/orders/*", h)
+
+			Convey("When they are sent in either direction", func() {
+				req := httptest.NewRequest("GET", "http://gateway.local/api/v1/orders/1", nil)
+				req.Header.Set("Connection", "keep-alive, X-Client-Hop")
+				req.Header.Set("X-Client-Hop", "client")
+				req.Header.Set("X-End-To-End", "client")
+				res, _ := app.Test(req)
+
+				Convey("Then they are not forwarded", func() {
+					So(res.Header.Get("X-Client-Hop"), ShouldBeBlank)
+					So(res.Header.Get("X-Upstream-Hop"), ShouldBeBlank)
+					So(res.Header.Get("X-End-To-End"), ShouldEqual, "client")
+				})
+			})
+		})
+
+		Convey("Given a request given an ID", func() {
+			app := fiber.New()
+			app.Use(mw.RequestID())
+			h, _ := New(nil).Handler(Route{Prefix: "/v1/orders", Upstream: upstream.URL})
+			app.All("/api/v1/orders/*", h)
+
+			Convey("When the client sent an ID that is not valid", func() {
+				req := httptest.NewRequest("GET", "http://gateway.local/api/v1/orders/1", nil)
+				req.Header.Set(fiber.HeaderXRequestID, "not valid")
+				res, _ := app.Test(req)
+				echo := upstreamEcho{}
+				json.NewDecoder(res.Body).Decode(&echo)
+
+				Convey("Then the upstream receives the ID of the gateway", func() {
+					So(echo.Header.Get(fiber.HeaderXRequestID), ShouldEqual, res.Header.Get(fiber.HeaderXRequestID))
+					So(echo.Header.Values(fiber.HeaderXRequestID), ShouldHaveLength, 1)
+				})
+			})
+
+			Convey("When the upstream answers with its own ID and rate limit headers", func() {
+				echoing := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+					w.Header().Set(fiber.HeaderXRequestID, "upstream-id")
+					w.Header().Set("RateLimit-Limit", "5")
+					w.Header().Set("RateLimit-Remaining", "4")
+				}))
+				defer echoing.Close()
+				app := fiber.New()
+				app.Use(mw.RequestID())
+				h, _ := New(nil).Handler(Route{Prefix: "/v1/orders", Upstream: echoing.URL})
+				app.All("/api/v1/orders/*", func(c *fiber.Ctx) error {
+					c.Set("RateLimit-Limit", "100")
+					return c.Next()
+				}, h)
+
+				req := httptest.NewRequest("GET", "http://gateway.local/api/v1/orders/1", nil)
+				req.Header.Set(fiber.HeaderXRequestID, "gateway-id")
+				res, _ := app.Test(req)
+
+				Convey("Then the client only gets the headers of the gateway", func() {
+					So(res.Header.Values(fiber.HeaderXRequestID), ShouldResemble, []string{"gateway-id"})
+					So(res.Header.Values("RateLimit-Limit"), ShouldResemble, []string{"100"})
+				})
+
+				Convey("Then the headers the gateway did not set are passed on", func() {
+					So(res.Header.Values("RateLimit-Remaining"), ShouldResemble, []string{"4"})
+				})
+			})
+		})
+
+		Convey("Given a traced request", func() {
+			spans := tracingtest.InMemory()
+			app := fiber.New()
+			app.Use(tracing.Middleware())
+			h, _ := New(nil).Handler(Route{Prefix: "/v1/orders", Upstream: upstream.URL})
+			app.All("/api/v1/orders/*", h)
+
+			Convey("When it continues the trace of the caller", func() {
+				req := httptest.NewRequest("GET", "http://gateway.local/api/v1/orders/1", nil)
+				req.Header.Set("traceparent", "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01")
+				req.Header.Set("tracestate", "vendor=value")
+				res, _ := app.Test(req)
+				echo := upstreamEcho{}
+				json.NewDecoder(res.Body).Decode(&echo)
+
+				Convey("Then the upstream receives the same trace under the client span of the call", func() {
+					So(len(spans.GetSpans()), ShouldEqual, 2)
+					// The server span ends first, before the body streams.
+					server, client := spans.GetSpans()[0], spans.GetSpans()[1]
+					So(client.SpanKind, ShouldEqual, trace.SpanKindClient)
+					So(client.Parent.SpanID(), ShouldEqual, server.SpanContext.SpanID())
+					So(server.Parent.SpanID().String(), ShouldEqual, "00f067aa0ba902b7")
+					So(echo.Header.Get("traceparent"), ShouldEqual, "00-4bf92f3577b34da6a3ce929d0e0e4736-"+client.SpanContext.SpanID().String()+"-01")
+					So(echo.Header.Get("tracestate"), ShouldEqual, "vendor=value")
+				})
+			})
+		})
+
+		Convey("Given an upstream that fails once before it answers", func() {
+			calls := 0
+			flaky := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				calls++
+				if calls == 1 {
+					w.WriteHeader(http.StatusServiceUnavailable)
+					return
+				}
+				w.Write([]byte("ok"))
+			}))
+			t.Cleanup(flaky.Close)
+			app := fiber.New()
+			p := New(nil).Configure(config.Upstream{Retry: config.Retry{Attempts: 2}})
+			h, _ := p.Handler(Route{Prefix: "/v1/orders", Upstream: flaky.URL})
+			app.All("/api/v1/orders/*", h)
+
+			Convey("When an idempotent request is sent", func() {
+				res, _ := app.Test(httptest.NewRequest("GET", "http://gateway.local/api/v1/orders/1", nil))
+				body, _ := io.ReadAll(res.Body)
+
+				Convey("Then it is retried and the second answer is sent back", func() {
+					So(res.StatusCode, ShouldEqual, fiber.StatusOK)
+					So(string(body), ShouldEqual, "ok")
+					So(calls, ShouldEqual, 2)
+				})
+			})
+
+			Convey("When a POST request is sent", func() {
+				res, _ := app.Test(httptest.NewRequest("POST", "http://gateway.local/api/v1/orders", strings.NewReader("{}")))
+
+				Convey("Then it is not retried", func() {
+					So(res.StatusCode, ShouldEqual, fiber.StatusServiceUnavailable)
+					So(calls, ShouldEqual, 1)
+				})
+			})
+		})
+
+		Convey("Given an upstream that keeps failing behind a circuit breaker", func() {
+			calls := 0
+			failing := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				calls++
+				w.WriteHeader(http.StatusInternalServerError)
+			}))
+			t.Cleanup(failing.Close)
+			app := fiber.New()
+			p := New(nil).Configure(config.Upstream{Breaker: config.Breaker{FailureThreshold: 2, OpenTimeout: config.Duration(time.Minute), HalfOpenRequests: 1}})
+			h, _ := p.Handler(Route{Prefix: "/v1/orders", Upstream: failing.URL})
+			app.All("/api/v1/orders/*", h)
+
+			Convey("When the threshold of failures is reached", func() {
+				app.Test(httptest.NewRequest("GET", "http://gateway.local/api/v1/orders/1", nil))
+				app.Test(httptest.NewRequest("GET", "http://gateway.local/api/v1/orders/1", nil))
+				res, _ := app.Test(httptest.NewRequest("GET", "http://gateway.local/api/v1/orders/1", nil))
+				body := utils.DefaultResponseBody{}
+				json.NewDecoder(res.Body).Decode(&body)
+
+				Convey("Then further requests fail fast with HTTP 503 without reaching the upstream", func() {
+					So(res.StatusCode, ShouldEqual, fiber.StatusServiceUnavailable)
+					So(body.Message, ShouldEqual, fiber.ErrServiceUnavailable.Message)
+					So(calls, ShouldEqual, 2)
+				})
+
+				Convey("Then the breaker is reported open", func() {
+					breakers := p.Breakers()
+					So(len(breakers), ShouldEqual, 1)
+					So(breakers[0].Upstream, ShouldEqual, failing.URL)
+					So(breakers[0].State, ShouldEqual, "open")
+				})
+			})
+		})
+
+		Convey("Given a pool whose first member has an open circuit breaker", func() {
+			failing := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				w.WriteHeader(http.StatusInternalServerError)
+			}))
+			t.Cleanup(failing.Close)
+			healthy := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				w.Write([]byte("ok"))
+			}))
+			t.Cleanup(healthy.Close)
+
+			app := fiber.New()
+			p := New(nil).Configure(config.Upstream{Breaker: config.Breaker{FailureThreshold: 1, OpenTimeout: config.Duration(time.Minute), HalfOpenRequests: 1}})
+			p.SetPools([]PoolSpec{{Name: "orders", Members: []MemberSpec{{URL: failing.URL}, {URL: healthy.URL}}}})
+			h, _ := p.Handler(Route{Prefix: "/v1/orders", Pool: "orders"})
+			app.All("/api/v1/orders/*", h)
+			app.Test(httptest.NewRequest("GET", "http://gateway.local/api/v1/orders/1", nil))
+
+			Convey("When requests are sent without retries left", func() {
+				statuses := []int{}
+				for i := 0; i < 2; i++ {
+					res, _ := app.Test(httptest.NewRequest("POST", "http://gateway.local/api/v1/orders/1", nil))
+					statuses = append(statuses, res.StatusCode)
+				}
+
+				Convey("Then the open member is skipped for the healthy one", func() {
+					So(statuses, ShouldResemble, []int{fiber.StatusOK, fiber.StatusOK})
+				})
+			})
+
+			Convey("When the other member opens its breaker too", func() {
+				healthy.Close()
+				app.Test(httptest.NewRequest("GET", "http://gateway.local/api/v1/orders/1", nil))
+				res, _ := app.Test(httptest.NewRequest("GET", "http://gateway.local/api/v1/orders/1", nil))
+				body := utils.DefaultResponseBody{}
+				json.NewDecoder(res.Body).Decode(&body)
+
+				Convey("Then HTTP 503 names an upstream", func() {
+					So(res.StatusCode, ShouldEqual, fiber.StatusServiceUnavailable)
+					So(body.Data.(map[string]interface{})["upstream"], ShouldNotBeBlank)
+				})
+			})
+		})
+
+		Convey("Given an upstream slower than the per-try timeout", func() {
+			slow := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				select {
+				case <-time.After(time.Second):
+				case <-r.Context().Done():
+				}
+			}))
+			t.Cleanup(slow.Close)
+			app := fiber.New()
+			p := New(nil).Configure(config.Upstream{Retry: config.Retry{Attempts: 1, PerTryTimeout: config.Duration(50 * time.Millisecond)}})
+			h, _ := p.Handler(Route{Prefix: "/v1/orders", Upstream: slow.URL})
+			app.All("/api/v1/orders/*", h)
+
+			Convey("When a request is sent", func() {
+				started := time.Now()
+				res, _ := app.Test(httptest.NewRequest("GET", "http://gateway.local/api/v1/orders/1", nil))
+
+				Convey("Then every attempt gives up in time and HTTP 504 is sent back", func() {
+					So(res.StatusCode, ShouldEqual, fiber.StatusGatewayTimeout)
+					So(time.Since(started), ShouldBeLessThan, 500*time.Millisecond)
+				})
+			})
+		})
+
+		Convey("Given a route to an unknown pool", func() {
+			Convey("When the function is called", func() {
+				_, err := New(nil).Handler(Route{Prefix: "/v1/orders", Pool: "orders"})
+
+				Convey("Then it returns an error", func() {
+					So(err, ShouldNotBeNil)
+				})
+			})
+		})
+
+		Convey("Given a pool with one failing member", func() {
+			failing := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				w.WriteHeader(http.StatusServiceUnavailable)
+			}))
+			t.Cleanup(failing.Close)
+			healthy := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				w.Write([]byte(r.URL.Path))
+			}))
+			t.Cleanup(healthy.Close)
+
+			app := fiber.New()
+			p := New(nil).Configure(config.Upstream{
+				Retry:    config.Retry{Attempts: 1},
+				Ejection: config.Ejection{Consecutive5xx: 1, Duration: config.Duration(time.Minute)},
+			})
+			err := p.SetPools([]PoolSpec{{Name: "orders", Members: []MemberSpec{{URL: failing.URL}, {URL: healthy.URL + "/internal"}}}})
+			So(err, ShouldBeNil)
+			h, err := p.Handler(Route{Prefix: "/v1/orders", Pool: "orders"})
+			So(err, ShouldBeNil)
+			app.All("/api/v1/orders/*", h)
+
+			Convey("When idempotent requests are sent", func() {
+				bodies := []string{}
+				for i := 0; i < 3; i++ {
+					res, _ := app.Test(httptest.NewRequest("GET", "http://gateway.local/api/v1/orders/1", nil))
+					body, _ := io.ReadAll(res.Body)
+					bodies = append(bodies, string(body))
+				}
+
+				Convey("Then the retry goes to the next member, onto its own base path", func() {
+					So(bodies, ShouldResemble, []string{"/internal/1", "/internal/1", "/internal/1"})
+				})
+
+				Convey("Then the failing member is ejected", func() {
+					pools := p.Pools()
+					So(len(pools), ShouldEqual, 1)
+					So(pools[0].Members[0].Available, ShouldBeFalse)
+					So(pools[0].Members[1].Available, ShouldBeTrue)
+				})
+			})
+
+			Convey("When every member has been ejected", func() {
+				healthy.Close()
+				app.Test(httptest.NewRequest("GET", "http://gateway.local/api/v1/orders/1", nil))
+				res, _ := app.Test(httptest.NewRequest("GET", "http://gateway.local/api/v1/orders/1", nil))
+				body := utils.DefaultResponseBody{}
+				json.NewDecoder(res.Body).Decode(&body)
+
+				Convey("Then HTTP 503 names the pool", func() {
+					So(res.StatusCode, ShouldEqual, fiber.StatusServiceUnavailable)
+					So(body.Data, ShouldResemble, map[string]interface{}{"pool": "orders"})
+				})
+			})
+		})
+
+		Convey("Given the upstream is unreachable", func() {
+			dead := httptest.NewServer(http.NotFoundHandler())
+			dead.Close()
+			app := fiber.New()
+			h, _ := New(nil).Handler(Route{Prefix: "/v1/orders", Upstream: dead.URL})
+			app.All("/api/v1/orders/*", h)
+
+			Convey("When a request is sent", func() {
+				req := httptest.NewRequest("GET", "http://gateway.local/api/v1/orders/1", nil)
+				res, _ := app.Test(req)
+				body := utils.DefaultResponseBody{}
+				json.NewDecoder(res.Body).Decode(&body)
+
+				Convey("Then server responds with HTTP 502 (bad gateway)", func() {
+					So(res.StatusCode, ShouldEqual, fiber.StatusBadGateway)
+					So(body.Status, ShouldEqual, fiber.StatusBadGateway)
+					So(body.Message, ShouldEqual, fiber.ErrBadGateway.Message)
+				})
+			})
+		})
+	})
+}
@@ -0,0 +1,150 @@
+package ratelimit
+
+import (
+	"errors"
+	"math"
+	"time"
+)
+
+// Limit allows Requests per Per. A zero limit lets everything through.
+type Limit struct {
+	Requests int
+	Per      time.Duration
+}
+
+func (l Limit) Enabled() bool {
+	return l.Requests > 0 && l.Per > 0
+}
+
+// Result is the outcome of taking one request from a limit, with what the
+// RateLimit-* and Retry-After headers report.
+type Result struct {
+	Allowed    bool
+	Limit      int
+	Remaining  int
+	Reset      time.Duration
+	RetryAfter time.Duration
+}
+
+// State is what a store keeps per key. Each algorithm uses its own fields.
+type State struct {
+	// Token bucket
+	Tokens  float64   `json:"tokens,omitempty"`
+	Updated time.Time `json:"updated,omitempty"`
+
+	// Sliding window
+	Window   time.Time `json:"window,omitempty"`
+	Count    int       `json:"count,omitempty"`
+	Previous int       `json:"previous,omitempty"`
+}
+
+// Algorithm takes one request from the state of a key.
+type Algorithm interface {
+	Take(s *State, l Limit, now time.Time) Result
+}
+
+// Store keeps the state of every key. A store shared by several gateway
+// instances only has to run Update atomically per key.
+type Store interface {
+	// Update calls fn with the state of key, a zero State for new keys, and
+	// saves it. Keys left alone for ttl may be forgotten.
+	Update(key string, ttl time.Duration, fn func(*State)) error
+}
+
+var (
+	TokenBucket   Algorithm = tokenBucket{}
+	SlidingWindow Algorithm = slidingWindow{}
+
+	// Default is the store limiters use when they are given none.
+	Default Store = NewMemoryStore()
+)
+
+func ParseAlgorithm(name string) (Algorithm, error) {
+	switch name {
+	case "", "token-bucket":
+		return TokenBucket, nil
+	case "sliding-window":
+		return SlidingWindow, nil
+	default:
+		return nil, errors.New("Unknown rate limit algorithm: " + name)
+	}
+}
+
+type Limiter struct {
+	Limit     Limit
+	Algorithm Algorithm
+	Store     Store
+}
+
+// Take counts one request for key.
+func (l *Limiter) Take(key string) (Result, error) {
+	var result Result
+	err := l.Store.Update(key, 2*l.Limit.Per, func(s *State) {
+		result = l.Algorithm.Take(s, l.Limit, time.Now())
+	})
+
+	return result, err
+}
+
+// tokenBucket holds up to Requests tokens and refills them evenly over Per,
+// which allows bursts of Requests after a quiet period.
+type tokenBucket struct{}
+
+func (tokenBucket) Take(s *State, l Limit, now time.Time) Result {
+	capacity := float64(l.Requests)
+	perToken := float64(l.Per) / capacity
+
+	if s.Updated.IsZero() {
+		s.Tokens = capacity
+	} else if elapsed := now.Sub(s.Updated); elapsed > 0 {
+		s.Tokens = math.Min(capacity, s.Tokens+float64(elapsed)/perToken)
+	}
+	s.Updated = now
+
+	r := Result{Limit: l.Requests}
+	if s.Tokens >= 1 {
+		s.Tokens--
+		r.Allowed = true
+	} else {
+		r.RetryAfter = time.Duration((1 - s.Tokens) * perToken)
+	}
+	r.Remaining = int(s.Tokens)
+	r.Reset = time.Duration((capacity - s.Tokens) * perToken)
+
+	return r
+}
+
+// slidingWindow counts requests in fixed windows of Per and weighs the
+// previous window by how much of it still overlaps the last Per.
+type slidingWindow struct{}
+
+func (slidingWindow) Take(s *State, l Limit, now time.Time) Result {
+	start := now.Truncate(l.Per)
+	if !s.Window.Equal(start) {
+		if s.Window.Equal(start.Add(-l.Per)) {
+			s.Previous = s.Count
+		} else {
+			s.Previous = 0
+		}
+		s.Window, s.Count = start, 0
+	}
+
+	elapsed := now.Sub(start)
+	overlap := 1 - float64(elapsed)/float64(l.Per)
+	estimate := float64(s.Previous)*overlap + float64(s.Count)
+
+	r := Result{Limit: l.Requests, Reset: l.Per - elapsed}
+	if estimate+1 <= float64(l.Requests) {
+		s.Count++
+		estimate++
+		r.Allowed = true
+	} else if s.Count+1 > l.Requests {
+		// Only the next window helps, once enough of this one has slid out.
+		r.RetryAfter = r.Reset + time.Duration(float64(l.Per)*(1-float64(l.Requests-1)/float64(s.Count)))
+	} else {
+		r.RetryAfter = time.Duration(float64(l.Per)*(1-float64(l.Requests-1-s.Count)/float64(s.Previous))) - elapsed
+	}
+	r.Remaining = int(math.Max(0, float64(l.Requests)-math.Ceil(estimate)))
+
+	return r
+}
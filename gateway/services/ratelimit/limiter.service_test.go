@@ -0,0 +1,125 @@
+package ratelimit
+
+import (
+	"testing"
+	"time"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestAlgorithms(t *testing.T) {
+	limit := Limit{Requests: 3, Per: time.Minute}
+	start := time.Date(2022, 10, 1, 12, 0, 0, 0, time.UTC)
+
+	Convey("TokenBucket", t, func() {
+		s := &State{}
+
+		Convey("Given a new key", func() {
+			Convey("When the limit is exhausted", func() {
+				var results []Result
+				for i := 0; i < 4; i++ {
+					results = append(results, TokenBucket.Take(s, limit, start))
+				}
+
+				Convey("Then a burst of the full limit is allowed", func() {
+					So(results[0].Allowed, ShouldBeTrue)
+					So(results[0].Remaining, ShouldEqual, 2)
+					So(results[2].Allowed, ShouldBeTrue)
+					So(results[2].Remaining, ShouldEqual, 0)
+					So(results[2].Reset, ShouldEqual, time.Minute)
+				})
+
+				Convey("Then the next request waits for one token", func() {
+					So(results[3].Allowed, ShouldBeFalse)
+					So(results[3].RetryAfter, ShouldEqual, 20*time.Second)
+				})
+
+				Convey("Then a token is back once it has been refilled", func() {
+					So(TokenBucket.Take(s, limit, start.Add(20*time.Second)).Allowed, ShouldBeTrue)
+					So(TokenBucket.Take(s, limit, start.Add(20*time.Second)).Allowed, ShouldBeFalse)
+				})
+			})
+		})
+	})
+
+	Convey("SlidingWindow", t, func() {
+		s := &State{}
+
+		Convey("Given the limit was exhausted in the previous window", func() {
+			for i := 0; i < 3; i++ {
+				SlidingWindow.Take(s, limit, start.Add(30*time.Second))
+			}
+
+			Convey("When a request comes at the start of the next window", func() {
+				r := SlidingWindow.Take(s, limit, start.Add(time.Minute))
+
+				Convey("Then the previous window still counts in full", func() {
+					So(r.Allowed, ShouldBeFalse)
+					So(r.RetryAfter, ShouldEqual, 20*time.Second)
+				})
+			})
+
+			Convey("When a request comes once enough of it has slid out", func() {
+				r := SlidingWindow.Take(s, limit, start.Add(80*time.Second))
+
+				Convey("Then it is allowed", func() {
+					So(r.Allowed, ShouldBeTrue)
+					So(r.Remaining, ShouldEqual, 0)
+					So(r.Reset, ShouldEqual, 40*time.Second)
+				})
+			})
+
+			Convey("When a request comes in the same window", func() {
+				r := SlidingWindow.Take(s, limit, start.Add(45*time.Second))
+
+				Convey("Then it waits for the next window to slide far enough", func() {
+					So(r.Allowed, ShouldBeFalse)
+					So(r.RetryAfter, ShouldEqual, 35*time.Second)
+				})
+			})
+
+			Convey("When a request comes two windows later", func() {
+				r := SlidingWindow.Take(s, limit, start.Add(2*time.Minute))
+
+				Convey("Then the old counts are forgotten", func() {
+					So(r.Allowed, ShouldBeTrue)
+					So(r.Remaining, ShouldEqual, 2)
+				})
+			})
+		})
+	})
+}
+
+func TestLimiter(t *testing.T) {
+	Convey("func (l *Limiter) Take(key string) (Result, error)", t, func() {
+		store := NewMemoryStore()
+		l := &Limiter{Limit: Limit{Requests: 1, Per: time.Minute}, Algorithm: TokenBucket, Store: store}
+
+		Convey("Given two keys", func() {
+			Convey("When each takes a request", func() {
+				a, _ := l.Take("a")
+				b, _ := l.Take("b")
+				again, _ := l.Take("a")
+
+				Convey("Then they are counted separately", func() {
+					So(a.Allowed, ShouldBeTrue)
+					So(b.Allowed, ShouldBeTrue)
+					So(again.Allowed, ShouldBeFalse)
+					So(store.Len(), ShouldEqual, 2)
+				})
+			})
+		})
+	})
+
+	Convey("func ParseAlgorithm(name string) (Algorithm, error)", t, func() {
+		Convey("Given an unknown name", func() {
+			Convey("When the function is called", func() {
+				_, err := ParseAlgorithm("leaky-bucket")
+
+				Convey("Then it returns an error", func() {
+					So(err, ShouldNotBeNil)
+				})
+			})
+		})
+	})
+}
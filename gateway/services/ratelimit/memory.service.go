@@ -0,0 +1,63 @@
+package ratelimit
+
+import (
+	"sync"
+	"time"
+)
+
+// MemoryStore keeps limiter state in this process only, so every gateway
+// instance counts on its own.
+type MemoryStore struct {
+	mu        sync.Mutex
+	entries   map[string]*memoryEntry
+	lastSweep time.Time
+}
+
+type memoryEntry struct {
+	state   State
+	expires time.Time
+}
+
+// sweepEvery bounds how often expired keys are dropped.
+const sweepEvery = time.Minute
+
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{entries: map[string]*memoryEntry{}, lastSweep: time.Now()}
+}
+
+func (m *MemoryStore) Update(key string, ttl time.Duration, fn func(*State)) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	now := time.Now()
+	if now.Sub(m.lastSweep) > sweepEvery {
+		m.sweep(now)
+	}
+
+	e, ok := m.entries[key]
+	if !ok || now.After(e.expires) {
+		e = &memoryEntry{}
+		m.entries[key] = e
+	}
+	fn(&e.state)
+	e.expires = now.Add(ttl)
+
+	return nil
+}
+
+// Len tells how many keys are being tracked.
+func (m *MemoryStore) Len() int {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	return len(m.entries)
+}
+
+func (m *MemoryStore) sweep(now time.Time) {
+	for key, e := range m.entries {
+		if now.After(e.expires) {
+			delete(m.entries, key)
+		}
+	}
+	m.lastSweep = now
+}
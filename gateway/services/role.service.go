@@ -0,0 +1,222 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"gateway/models"
+	"gateway/services/db"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+var (
+	ErrAdminRoleLocked = errors.New("The admin role cannot be changed")
+	// ErrLastAdmin keeps at least one active user able to manage roles
+	// through the API.
+	ErrLastAdmin = errors.New("The last active administrator cannot lose the admin role")
+)
+
+func FindRoles(ctx context.Context) ([]models.Role, error) {
+	var roles []models.Role
+	result := db.Conn.WithContext(ctx).Preload("Permissions").Order("code").Find(&roles)
+
+	if result.Error != nil {
+		logDBError(ctx, result.Error)
+		return nil, errors.New("Error when reading database")
+	}
+
+	return roles, nil
+}
+
+func GetRoleByID(ctx context.Context, id uint) (*models.Role, error) {
+	role := new(models.Role)
+	result := db.Conn.WithContext(ctx).Preload("Permissions").First(role, id)
+
+	if result.Error != nil {
+		logDBError(ctx, result.Error)
+		return nil, errors.New("No role found")
+	}
+
+	return role, nil
+}
+
+func GetRoleByCode(ctx context.Context, code string) (*models.Role, error) {
+	role := new(models.Role)
+	result := db.Conn.WithContext(ctx).Preload("Permissions").Where("code = ?", code).First(role)
+
+	if result.Error != nil {
+		logDBError(ctx, result.Error)
+		return nil, errors.New("No role found")
+	}
+
+	return role, nil
+}
+
+func CreateRole(ctx context.Context, dto models.CreateRoleDto) (*models.Role, error) {
+	role := models.Role{Code: dto.Code}
+
+	result := db.Conn.WithContext(ctx).Create(&role)
+	if result.Error != nil {
+		logDBError(ctx, result.Error)
+		return nil, roleWriteError(result.Error, "Error writing to database")
+	}
+	Audit(ctx, models.AuditEvent{
+		Action: models.AuditRoleCreate, Success: true,
+		TargetType: models.AuditTargetRole, TargetID: &role.ID, TargetName: role.Code,
+		Changes: models.AuditChanges{"code": {To: role.Code}},
+	})
+
+	return &role, nil
+}
+
+func UpdateRole(ctx context.Context, id uint, dto models.UpdateRoleDto) (*models.Role, error) {
+	role, err := GetRoleByID(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	if role.Code == models.AdminRoleCode && dto.Code != role.Code {
+		return nil, ErrAdminRoleLocked
+	}
+
+	before := role.Code
+	result := db.Conn.WithContext(ctx).Model(role).Update("code", dto.Code)
+	if result.Error != nil {
+		logDBError(ctx, result.Error)
+		return nil, roleWriteError(result.Error, "Error when writing database")
+	}
+	evictPrincipals()
+	if before != role.Code {
+		Audit(ctx, models.AuditEvent{
+			Action: models.AuditRoleUpdate, Success: true,
+			TargetType: models.AuditTargetRole, TargetID: &role.ID, TargetName: role.Code,
+			Changes: models.AuditChanges{"code": {From: before, To: role.Code}},
+		})
+	}
+
+	return role, nil
+}
+
+// DeleteRole removes the role for good, along with its user assignments, so
+// that its code can be reused.
+func DeleteRole(ctx context.Context, id uint) error {
+	role, err := GetRoleByID(ctx, id)
+	if err != nil {
+		return err
+	}
+	if role.Code == models.AdminRoleCode {
+		return ErrAdminRoleLocked
+	}
+
+	err = db.Conn.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		if err := tx.Exec("DELETE FROM user_roles WHERE role_id = ?", id).Error; err != nil {
+			return err
+		}
+		if err := tx.Exec("DELETE FROM role_permissions WHERE role_id = ?", id).Error; err != nil {
+			return err
+		}
+		return tx.Unscoped().Delete(role).Error
+	})
+	if err != nil {
+		logDBError(ctx, err)
+		return errors.New("Error when writing database")
+	}
+	evictPrincipals()
+	Audit(ctx, models.AuditEvent{
+		Action: models.AuditRoleDelete, Success: true,
+		TargetType: models.AuditTargetRole, TargetID: &role.ID, TargetName: role.Code,
+		Changes: models.AuditChanges{"code": {From: role.Code}},
+	})
+
+	return nil
+}
+
+func AssignRole(ctx context.Context, userID, roleID uint) (*models.User, error) {
+	return changeUserRoles(ctx, models.AuditRoleAssign, userID, roleID, func(tx *gorm.DB, user *models.User, role *models.Role) error {
+		return tx.Model(user).Association("Roles").Append(role)
+	})
+}
+
+// RevokeRole takes the role away from the user. It fails with ErrLastAdmin
+// rather than take the admin role from the last active administrator.
+func RevokeRole(ctx context.Context, userID, roleID uint) (*models.User, error) {
+	return changeUserRoles(ctx, models.AuditRoleUnassign, userID, roleID, func(tx *gorm.DB, user *models.User, role *models.Role) error {
+		if role.Code == models.AdminRoleCode && user.IsActive && user.HasRole(models.AdminRoleCode) {
+			if err := keepAnAdmin(tx, user.ID); err != nil {
+				return err
+			}
+		}
+		return tx.Model(user).Association("Roles").Delete(role)
+	})
+}
+
+// keepAnAdmin fails with ErrLastAdmin when no active user but userID holds
+// the admin role. It writes the admin role first, so that the checks of
+// concurrent transactions wait for tx and then count what it changed.
+func keepAnAdmin(tx *gorm.DB, userID uint) error {
+	if err := tx.Model(&models.Role{}).Where("code = ?", models.AdminRoleCode).Update("updated_at", time.Now()).Error; err != nil {
+		return err
+	}
+
+	var others int64
+	err := tx.Model(&models.User{}).
+		Joins("JOIN user_roles ON user_roles.user_id = users.id").
+		Joins("JOIN roles ON roles.id = user_roles.role_id").
+		Where("roles.code = ? AND users.is_active = ? AND users.id <> ?", models.AdminRoleCode, true, userID).
+		Count(&others).Error
+	if err != nil {
+		return err
+	}
+	if others == 0 {
+		return ErrLastAdmin
+	}
+
+	return nil
+}
+
+// changeUserRoles applies change to the roles of the user in a transaction,
+// and records it in the audit trail as action.
+func changeUserRoles(ctx context.Context, action string, userID, roleID uint, change func(*gorm.DB, *models.User, *models.Role) error) (*models.User, error) {
+	user, err := GetUserByID(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+	role, err := GetRoleByID(ctx, roleID)
+	if err != nil {
+		return nil, err
+	}
+
+	// The association changes user.Roles in place.
+	before := roleCodes(user.Roles)
+	err = db.Conn.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		return change(tx, user, role)
+	})
+	if errors.Is(err, ErrLastAdmin) {
+		return nil, err
+	}
+	if err != nil {
+		logDBError(ctx, err)
+		return nil, errors.New("Error when writing database")
+	}
+	evictPrincipal(userID)
+
+	changed, err := GetUserByID(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+	Audit(ctx, models.AuditEvent{
+		Action: action, Success: true,
+		TargetType: models.AuditTargetUser, TargetID: &user.ID, TargetName: user.Username,
+		Changes: models.AuditChanges{"roles": {From: before, To: roleCodes(changed.Roles)}},
+	})
+
+	return changed, nil
+}
+
+func roleWriteError(err error, fallback string) error {
+	if db.IsUniqueViolation(err, "code") {
+		return errors.New("Role already exists")
+	}
+
+	return errors.New(fallback)
+}
@@ -0,0 +1,137 @@
+package routing
+
+import (
+	"gateway/services/logging"
+	"os"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"go.uber.org/zap"
+)
+
+// Compiler turns a validated table into the handler serving its routes, and
+// a function to call once that handler serves, which may be nil. Nothing the
+// compiler does may affect the table served until then.
+type Compiler func(*Table) (handler fiber.Handler, activate func(), err error)
+
+// Router serves the active route table and swaps it atomically on reload.
+// Requests already in flight finish on the table they started with.
+type Router struct {
+	path    string
+	compile Compiler
+	active  atomic.Value // *compiledTable
+	reload  sync.Mutex   // serialises reloads
+	mu      sync.Mutex   // guards status and file
+	status  Status
+	file    os.FileInfo // source file as of the last load attempt
+}
+
+type compiledTable struct {
+	table   *Table
+	handler fiber.Handler
+}
+
+// Status describes the table currently served and the outcome of the last
+// reload attempt.
+type Status struct {
+	Source        string    `json:"source"`
+	Version       string    `json:"version"`
+	LoadedAt      time.Time `json:"loadedAt"`
+	Routes        int       `json:"routes"`
+	LastAttemptAt time.Time `json:"lastAttemptAt"`
+	LastError     string    `json:"lastError,omitempty"`
+	Table         *Table    `json:"table"`
+}
+
+// NewRouter loads the table at path and compiles it. An empty path serves an
+// empty table that can never be reloaded.
+func NewRouter(path string, compile Compiler) (*Router, error) {
+	r := &Router{path: path, compile: compile}
+
+	table := &Table{}
+	if path != "" {
+		var err error
+		r.file, _ = os.Stat(path)
+		if table, err = LoadFile(path); err != nil {
+			return nil, err
+		}
+	}
+
+	if err := r.swap(table); err != nil {
+		return nil, err
+	}
+
+	return r, nil
+}
+
+// Handler dispatches each request to the table active when it arrives.
+func (r *Router) Handler() fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		return r.active.Load().(*compiledTable).handler(c)
+	}
+}
+
+// Reload re-reads the source file. An invalid table is rejected and the
+// previous one keeps serving.
+func (r *Router) Reload() error {
+	if r.path == "" {
+		return nil
+	}
+
+	r.reload.Lock()
+	defer r.reload.Unlock()
+
+	file, _ := os.Stat(r.path)
+	table, err := LoadFile(r.path)
+	if err == nil {
+		err = r.swap(table)
+	}
+
+	r.mu.Lock()
+	r.file = file
+	r.status.LastAttemptAt = time.Now()
+	r.status.LastError = ""
+	if err != nil {
+		r.status.LastError = err.Error()
+	}
+	r.mu.Unlock()
+
+	if err != nil {
+		logging.Named("routing").Warn("Route table reload rejected", zap.String("version", r.Status().Version), zap.Error(err))
+		return err
+	}
+	logging.Named("routing").Info("Route table reloaded", zap.String("file", r.path), zap.String("version", table.Version))
+
+	return nil
+}
+
+func (r *Router) swap(table *Table) error {
+	handler, activate, err := r.compile(table)
+	if err != nil {
+		return err
+	}
+
+	r.mu.Lock()
+	r.active.Store(&compiledTable{table: table, handler: handler})
+	r.status.Source = r.path
+	r.status.Version = table.Version
+	r.status.LoadedAt = time.Now()
+	r.status.Routes = len(table.Routes)
+	r.status.Table = table
+	r.mu.Unlock()
+
+	if activate != nil {
+		activate()
+	}
+
+	return nil
+}
+
+func (r *Router) Status() Status {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	return r.status
+}
@@ -0,0 +1,129 @@
+package routing
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func versionCompiler(table *Table) (fiber.Handler, func(), error) {
+	return func(c *fiber.Ctx) error {
+		return c.SendString(table.Version)
+	}, nil, nil
+}
+
+func TestRouter(t *testing.T) {
+	Convey("func (r *Router) Reload() error", t, func() {
+		path := filepath.Join(t.TempDir(), "routes.yaml")
+		os.WriteFile(path, []byte("version: v1\nroutes: []\n"), 0600)
+
+		router, err := NewRouter(path, versionCompiler)
+		So(err, ShouldBeNil)
+		app := fiber.New()
+		app.Use(router.Handler())
+
+		served := func() string {
+			res, _ := app.Test(httptest.NewRequest("GET", "/api/anything", nil))
+			b, _ := io.ReadAll(res.Body)
+			return string(b)
+		}
+
+		Convey("Given the file has been changed to a valid table", func() {
+			os.WriteFile(path, []byte("version: v2\nroutes: []\n"), 0600)
+
+			Convey("When the function is called", func() {
+				err := router.Reload()
+
+				Convey("Then the new table is served", func() {
+					So(err, ShouldBeNil)
+					So(served(), ShouldEqual, "v2")
+					So(router.Status().Version, ShouldEqual, "v2")
+					So(router.Status().LastError, ShouldBeBlank)
+				})
+			})
+		})
+
+		Convey("Given the file has been changed to an invalid table", func() {
+			os.WriteFile(path, []byte("version: v3\nroutes:\n  - path: nope\n"), 0600)
+
+			Convey("When the function is called", func() {
+				err := router.Reload()
+
+				Convey("Then the previous table keeps serving", func() {
+					So(err, ShouldNotBeNil)
+					So(served(), ShouldEqual, "v1")
+					So(router.Status().Version, ShouldEqual, "v1")
+					So(router.Status().LastError, ShouldNotBeBlank)
+				})
+			})
+		})
+	})
+
+	Convey("func (r *Router) WatchFile(ctx context.Context, interval time.Duration)", t, func() {
+		Convey("Given a router watching its source file", func() {
+			path := filepath.Join(t.TempDir(), "routes.json")
+			os.WriteFile(path, []byte(`{"version":"a","routes":[]}`), 0600)
+			router, _ := NewRouter(path, versionCompiler)
+
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+			go router.WatchFile(ctx, 10*time.Millisecond)
+
+			Convey("When the file is rewritten", func() {
+				os.WriteFile(path, []byte(`{"version":"bb","routes":[]}`), 0600)
+
+				Convey("Then the table is reloaded", func() {
+					deadline := time.Now().Add(2 * time.Second)
+					for router.Status().Version != "bb" && time.Now().Before(deadline) {
+						time.Sleep(10 * time.Millisecond)
+					}
+					So(router.Status().Version, ShouldEqual, "bb")
+				})
+			})
+		})
+	})
+
+	Convey("func NewRouter(path string, compile Compiler) (*Router, error)", t, func() {
+		Convey("Given a compiler with something to activate", func() {
+			path := filepath.Join(t.TempDir(), "routes.yaml")
+			os.WriteFile(path, []byte("version: v1\nroutes: []\n"), 0600)
+			var router *Router
+			activated := []string{}
+			servedWhenActivated := []string{}
+			compile := func(table *Table) (fiber.Handler, func(), error) {
+				if table.Version == "broken" {
+					return nil, func() { activated = append(activated, "broken") }, errors.New("Cannot compile")
+				}
+				handler, _, _ := versionCompiler(table)
+				return handler, func() {
+					activated = append(activated, table.Version)
+					if router != nil {
+						servedWhenActivated = append(servedWhenActivated, router.Status().Version)
+					}
+				}, nil
+			}
+			router, err := NewRouter(path, compile)
+			So(err, ShouldBeNil)
+
+			Convey("When tables are reloaded", func() {
+				os.WriteFile(path, []byte("version: broken\nroutes: []\n"), 0600)
+				router.Reload()
+				os.WriteFile(path, []byte("version: v2\nroutes: []\n"), 0600)
+				router.Reload()
+
+				Convey("Then only tables that were swapped in are activated, once served", func() {
+					So(activated, ShouldResemble, []string{"v1", "v2"})
+					So(servedWhenActivated, ShouldResemble, []string{"v2"})
+				})
+			})
+		})
+	})
+}
@@ -0,0 +1,360 @@
+package routing
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"gateway/config"
+	"gateway/services/proxy"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+// Table is the declarative list of routes the gateway forwards to upstream
+// services. It is loaded from a YAML or JSON file.
+type Table struct {
+	Version string            `json:"version" yaml:"version"`
+	Pools   []PoolDefinition  `json:"pools" yaml:"pools"`
+	Routes  []RouteDefinition `json:"routes" yaml:"routes"`
+}
+
+// RouteDefinition forwards to a single Upstream, to one of the pools of the
+// table or to the instances of a Service found by service discovery.
+type RouteDefinition struct {
+	Name     string   `json:"name" yaml:"name"`
+	Method   string   `json:"method" yaml:"method"`
+	Path     string   `json:"path" yaml:"path"`
+	Upstream string   `json:"upstream" yaml:"upstream"`
+	Pool     string   `json:"pool" yaml:"pool"`
+	Service  string   `json:"service" yaml:"service"`
+	Rewrite  string   `json:"rewrite" yaml:"rewrite"`
+	Public   bool     `json:"public" yaml:"public"`
+	Roles    []string `json:"roles" yaml:"roles"`
+	// Permissions must all be held by the user, on top of any of Roles.
+	Permissions []string        `json:"permissions" yaml:"permissions"`
+	Timeout     config.Duration `json:"timeout" yaml:"timeout"`
+	RateLimit   *RateLimit      `json:"rateLimit" yaml:"rateLimit"`
+	// Retry replaces the default retry policy for this route.
+	Retry *config.Retry `json:"retry" yaml:"retry"`
+}
+
+// RateLimit counts requests per client IP, per authenticated user or per
+// X-API-Key header, as Key is ip (the default), user or apiKey.
+type RateLimit struct {
+	Requests int             `json:"requests" yaml:"requests"`
+	Per      config.Duration `json:"per" yaml:"per"`
+	Key      string          `json:"key" yaml:"key"`
+}
+
+// PoolDefinition spreads calls across several instances of a service with
+// Strategy: round-robin (the default), least-connections or weighted. The
+// instances are either listed in Members or found by service discovery from
+// Service. HealthCheck and Ejection replace the defaults of the gateway
+// config.
+type PoolDefinition struct {
+	Name        string              `json:"name" yaml:"name"`
+	Strategy    string              `json:"strategy" yaml:"strategy"`
+	Members     []PoolMember        `json:"members" yaml:"members"`
+	Service     string              `json:"service" yaml:"service"`
+	HealthCheck *config.HealthCheck `json:"healthCheck" yaml:"healthCheck"`
+	Ejection    *config.Ejection    `json:"ejection" yaml:"ejection"`
+}
+
+type PoolMember struct {
+	URL    string `json:"url" yaml:"url"`
+	Weight int    `json:"weight" yaml:"weight"`
+}
+
+// servicePool prefixes the pools made for routes naming a service.
+const servicePool = "service:"
+
+var methods = map[string]bool{
+	"": true, "*": true,
+	"GET": true, "HEAD": true, "POST": true, "PUT": true, "PATCH": true,
+	"DELETE": true, "OPTIONS": true, "CONNECT": true, "TRACE": true,
+}
+
+var idempotent = map[string]bool{
+	"GET": true, "HEAD": true, "OPTIONS": true, "PUT": true, "DELETE": true, "TRACE": true,
+}
+
+// ValidationError lists every problem found in a route table so that
+// operators can fix them all at once.
+type ValidationError struct {
+	Problems []string
+}
+
+func (e *ValidationError) Error() string {
+	return "Invalid route table:\n  " + strings.Join(e.Problems, "\n  ")
+}
+
+// LoadFile reads, parses and validates a route table. The format is picked
+// from the file extension. When the file has no version, the SHA-256 of its
+// content is used instead.
+func LoadFile(path string) (*Table, error) {
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	table, err := Parse(content, filepath.Ext(path))
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+
+	if table.Version == "" {
+		sum := sha256.Sum256(content)
+		table.Version = hex.EncodeToString(sum[:])[:12]
+	}
+
+	return table, nil
+}
+
+func Parse(content []byte, ext string) (*Table, error) {
+	table := new(Table)
+
+	var err error
+	switch strings.ToLower(ext) {
+	case ".yaml", ".yml":
+		err = yaml.Unmarshal(content, table)
+	case ".json":
+		err = json.Unmarshal(content, table)
+	default:
+		return nil, errors.New("Unsupported route table format: " + ext)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if err := table.Validate(); err != nil {
+		return nil, err
+	}
+
+	return table, nil
+}
+
+func (t *Table) Validate() error {
+	var problems []string
+	pools := map[string]bool{}
+
+	for i, p := range t.Pools {
+		name := fmt.Sprintf("pools[%d]", i)
+		if p.Name != "" {
+			name += " (" + p.Name + ")"
+		}
+		report := func(msg string) {
+			problems = append(problems, name+": "+msg)
+		}
+
+		if strings.TrimSpace(p.Name) == "" {
+			report("name is required")
+		} else if strings.HasPrefix(p.Name, servicePool) {
+			report("name cannot start with " + servicePool)
+		} else if pools[p.Name] {
+			report("duplicates pool " + p.Name)
+		}
+		pools[p.Name] = true
+
+		if _, err := proxy.ParseStrategy(p.Strategy); err != nil {
+			report("unknown strategy " + p.Strategy)
+		}
+		if len(p.Members) > 0 && p.Service != "" {
+			report("set either members or service, not both")
+		} else if len(p.Members) == 0 && strings.TrimSpace(p.Service) == "" {
+			report("needs at least one member or a service")
+		}
+		for j, m := range p.Members {
+			if _, err := proxy.ParseUpstream(m.URL); err != nil {
+				report(fmt.Sprintf("members[%d]: %s", j, err))
+			}
+			if m.Weight < 0 {
+				report(fmt.Sprintf("members[%d]: weight cannot be negative", j))
+			}
+		}
+		if p.HealthCheck != nil {
+			for _, problem := range p.HealthCheck.Problems() {
+				report("healthCheck: " + problem)
+			}
+		}
+		if p.Ejection != nil {
+			for _, problem := range p.Ejection.Problems() {
+				report("ejection: " + problem)
+			}
+		}
+	}
+
+	seen := map[string]string{}
+
+	for i, r := range t.Routes {
+		name := fmt.Sprintf("routes[%d]", i)
+		if r.Name != "" {
+			name += " (" + r.Name + ")"
+		}
+		report := func(msg string) {
+			problems = append(problems, name+": "+msg)
+		}
+
+		if !methods[strings.ToUpper(r.Method)] {
+			report("unknown method " + r.Method)
+		}
+
+		if !strings.HasPrefix(r.Path, "/") {
+			report("path must start with /")
+		} else if strings.ContainsAny(r.Path, "*:?") {
+			report("path must be a plain prefix without wildcards or params")
+		} else {
+			key := r.MethodOrAll() + " " + r.Path
+			if other, ok := seen[key]; ok {
+				report("duplicates " + other)
+			}
+			seen[key] = name
+		}
+
+		switch {
+		case countSet(r.Upstream, r.Pool, r.Service) > 1:
+			report("set only one of upstream, pool and service")
+		case r.Service != "":
+			if strings.TrimSpace(r.Service) == "" {
+				report("service cannot be blank")
+			}
+		case r.Pool != "":
+			if !pools[r.Pool] {
+				report("unknown pool " + r.Pool)
+			}
+		default:
+			if _, err := proxy.ParseUpstream(r.Upstream); err != nil {
+				report(err.Error())
+			}
+		}
+
+		if r.Public && len(r.Roles) > 0 {
+			report("public routes cannot require roles")
+		}
+		for _, role := range r.Roles {
+			if strings.TrimSpace(role) == "" {
+				report("role codes cannot be blank")
+			}
+		}
+		if r.Public && len(r.Permissions) > 0 {
+			report("public routes cannot require permissions")
+		}
+		for _, permission := range r.Permissions {
+			if strings.TrimSpace(permission) == "" {
+				report("permission codes cannot be blank")
+			}
+		}
+
+		if r.Timeout < 0 {
+			report("timeout cannot be negative")
+		}
+
+		if r.Retry != nil {
+			for _, problem := range r.Retry.Problems() {
+				report("retry: " + problem)
+			}
+			if m := r.MethodOrAll(); r.Retry.Attempts > 0 && m != "*" && !idempotent[m] {
+				report("retry only applies to idempotent methods, not " + m)
+			}
+		}
+
+		if r.RateLimit != nil {
+			if r.RateLimit.Requests <= 0 || r.RateLimit.Per <= 0 {
+				report("rateLimit needs positive requests and per")
+			}
+			switch r.RateLimit.Key {
+			case "", "ip", "apiKey":
+			case "user":
+				if r.Public {
+					report("public routes cannot rate limit per user")
+				}
+			default:
+				report("unknown rateLimit key " + r.RateLimit.Key)
+			}
+		}
+	}
+
+	if len(problems) > 0 {
+		return &ValidationError{Problems: problems}
+	}
+
+	return nil
+}
+
+// MethodOrAll normalises the method, using "*" for routes matching any method.
+func (r RouteDefinition) MethodOrAll() string {
+	if r.Method == "" {
+		return "*"
+	}
+
+	return strings.ToUpper(r.Method)
+}
+
+func (r RouteDefinition) ProxyRoute() proxy.Route {
+	return proxy.Route{
+		Prefix:   r.Path,
+		Upstream: r.Upstream,
+		Pool:     r.poolName(),
+		Rewrite:  r.Rewrite,
+		Timeout:  time.Duration(r.Timeout),
+		Retry:    r.Retry,
+	}
+}
+
+func (r RouteDefinition) poolName() string {
+	if r.Service != "" {
+		return servicePool + r.Service
+	}
+
+	return r.Pool
+}
+
+func countSet(values ...string) int {
+	n := 0
+	for _, v := range values {
+		if v != "" {
+			n++
+		}
+	}
+
+	return n
+}
+
+// ProxyPools lists the pools of the table, plus one round-robin pool with
+// the default health check and ejection per service named by routes.
+func (t *Table) ProxyPools() []proxy.PoolSpec {
+	specs := []proxy.PoolSpec{}
+	for _, p := range t.Pools {
+		specs = append(specs, p.ProxyPool())
+	}
+
+	services := map[string]bool{}
+	for _, r := range t.Routes {
+		if r.Service != "" && !services[r.Service] {
+			services[r.Service] = true
+			specs = append(specs, proxy.PoolSpec{Name: r.poolName(), Service: r.Service})
+		}
+	}
+
+	return specs
+}
+
+func (p PoolDefinition) ProxyPool() proxy.PoolSpec {
+	spec := proxy.PoolSpec{
+		Name:        p.Name,
+		Strategy:    p.Strategy,
+		Service:     p.Service,
+		HealthCheck: p.HealthCheck,
+		Ejection:    p.Ejection,
+	}
+	for _, m := range p.Members {
+		spec.Members = append(spec.Members, proxy.MemberSpec{URL: m.URL, Weight: m.Weight})
+	}
+
+	return spec
+}
@@ -0,0 +1,235 @@
+package routing
+
+import (
+	"gateway/services/proxy"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+const validYAML = `
+version: "7"
+routes:
+  - name: orders
+    path: /v1/orders
+    upstream: http://orders:8080
+    roles: [admin]
+    timeout: 5s
+    rateLimit:
+      requests: 10
+      per: 1m
+  - name: catalog
+    method: get
+    path: /v1/catalog
+    upstream: https://catalog
+    public: true
+`
+
+func TestRouteTable(t *testing.T) {
+	Convey("func Parse(content []byte, ext string) (*Table, error)", t, func() {
+		Convey("Given a valid YAML table", func() {
+			Convey("When the function is called", func() {
+				table, err := Parse([]byte(validYAML), ".yaml")
+
+				Convey("Then every route is decoded", func() {
+					So(err, ShouldBeNil)
+					So(table.Version, ShouldEqual, "7")
+					So(len(table.Routes), ShouldEqual, 2)
+
+					orders := table.Routes[0]
+					So(orders.MethodOrAll(), ShouldEqual, "*")
+					So(orders.Roles, ShouldResemble, []string{"admin"})
+					So(time.Duration(orders.Timeout), ShouldEqual, 5*time.Second)
+					So(orders.RateLimit.Requests, ShouldEqual, 10)
+					So(time.Duration(orders.RateLimit.Per), ShouldEqual, time.Minute)
+
+					catalog := table.Routes[1]
+					So(catalog.MethodOrAll(), ShouldEqual, "GET")
+					So(catalog.Public, ShouldBeTrue)
+				})
+			})
+		})
+
+		Convey("Given a valid JSON table", func() {
+			Convey("When the function is called", func() {
+				table, err := Parse([]byte(`{"routes":[{"path":"/v1/a","upstream":"http://a","timeout":"250ms"}]}`), ".json")
+
+				Convey("Then the route is decoded", func() {
+					So(err, ShouldBeNil)
+					So(time.Duration(table.Routes[0].Timeout), ShouldEqual, 250*time.Millisecond)
+				})
+			})
+		})
+
+		Convey("Given an invalid table", func() {
+			Convey("When the function is called", func() {
+				_, err := Parse([]byte(`
+routes:
+  - name: broken
+    method: FETCH
+    path: v1/a
+    upstream: a:80
+    public: true
+    roles: [admin]
+    permissions: [orders:read]
+    rateLimit: {requests: 0, per: 1m, key: user}
+  - path: /v1/b
+    upstream: http://b
+  - path: /v1/b
+    upstream: http://b
+`), ".yml")
+
+				Convey("Then every problem is reported at once", func() {
+					So(err, ShouldHaveSameTypeAs, &ValidationError{})
+					problems := err.(*ValidationError).Problems
+					So(len(problems), ShouldEqual, 8)
+					So(problems[0], ShouldStartWith, "routes[0] (broken): unknown method")
+					So(problems[4], ShouldEndWith, "public routes cannot require permissions")
+					So(problems[6], ShouldEndWith, "public routes cannot rate limit per user")
+					So(problems[7], ShouldStartWith, "routes[2]: duplicates routes[1]")
+				})
+			})
+		})
+
+		Convey("Given a table with pools", func() {
+			Convey("When the function is called", func() {
+				table, err := Parse([]byte(`
+pools:
+  - name: orders
+    strategy: weighted
+    members:
+      - url: http://orders-1:8080
+        weight: 3
+      - url: http://orders-2:8080
+    healthCheck: {path: /healthz, interval: 5s, timeout: 1s, healthyThreshold: 1, unhealthyThreshold: 2}
+routes:
+  - path: /v1/orders
+    pool: orders
+`), ".yaml")
+
+				Convey("Then routes can target them", func() {
+					So(err, ShouldBeNil)
+					spec := table.Pools[0].ProxyPool()
+					So(spec.Strategy, ShouldEqual, "weighted")
+					So(spec.Members[0].Weight, ShouldEqual, 3)
+					So(spec.HealthCheck.Path, ShouldEqual, "/healthz")
+					So(spec.Ejection, ShouldBeNil)
+					So(table.Routes[0].ProxyRoute().Pool, ShouldEqual, "orders")
+				})
+			})
+		})
+
+		Convey("Given routes naming services", func() {
+			Convey("When the function is called", func() {
+				table, err := Parse([]byte(`
+pools:
+  - name: orders
+    service: orders
+routes:
+  - path: /v1/orders
+    pool: orders
+  - path: /v1/payments
+    service: payments
+  - method: GET
+    path: /v1/payments
+    service: payments
+`), ".yaml")
+
+				Convey("Then each service gets one pool of its own", func() {
+					So(err, ShouldBeNil)
+					pools := table.ProxyPools()
+					So(len(pools), ShouldEqual, 2)
+					So(pools[0].Service, ShouldEqual, "orders")
+					So(pools[1], ShouldResemble, proxy.PoolSpec{Name: "service:payments", Service: "payments"})
+					So(table.Routes[1].ProxyRoute().Pool, ShouldEqual, "service:payments")
+				})
+			})
+		})
+
+		Convey("Given invalid pools", func() {
+			Convey("When the function is called", func() {
+				_, err := Parse([]byte(`
+pools:
+  - name: orders
+    strategy: random
+    members: [{url: orders:8080, weight: -1}]
+    healthCheck: {path: /healthz}
+  - name: orders
+    ejection: {consecutive5xx: 3}
+routes:
+  - path: /v1/orders
+    pool: catalog
+  - path: /v1/stock
+    pool: orders
+    upstream: http://stock
+`), ".yaml")
+
+				Convey("Then every problem is reported at once", func() {
+					So(err, ShouldHaveSameTypeAs, &ValidationError{})
+					problems := err.(*ValidationError).Problems
+					So(problems, ShouldResemble, []string{
+						"pools[0] (orders): unknown strategy random",
+						"pools[0] (orders): members[0]: Upstream must be an absolute http(s) URL: orders:8080",
+						"pools[0] (orders): members[0]: weight cannot be negative",
+						"pools[0] (orders): healthCheck: interval and timeout must be positive",
+						"pools[0] (orders): healthCheck: healthyThreshold and unhealthyThreshold must be positive",
+						"pools[1] (orders): duplicates pool orders",
+						"pools[1] (orders): needs at least one member or a service",
+						"pools[1] (orders): ejection: duration must be positive when consecutive5xx is set",
+						"routes[0]: unknown pool catalog",
+						"routes[1]: set only one of upstream, pool and service",
+					})
+				})
+			})
+		})
+
+		Convey("Given a route retrying a non-idempotent method", func() {
+			Convey("When the function is called", func() {
+				_, err := Parse([]byte(`
+routes:
+  - method: POST
+    path: /v1/orders
+    upstream: http://orders
+    retry: {attempts: 2, backoff: -1s}
+`), ".yaml")
+
+				Convey("Then the retry policy is rejected", func() {
+					So(err, ShouldHaveSameTypeAs, &ValidationError{})
+					problems := err.(*ValidationError).Problems
+					So(len(problems), ShouldEqual, 2)
+					So(problems[0], ShouldStartWith, "routes[0]: retry: ")
+					So(problems[1], ShouldEndWith, "retry only applies to idempotent methods, not POST")
+				})
+			})
+		})
+
+		Convey("Given an unsupported extension", func() {
+			Convey("When the function is called", func() {
+				_, err := Parse([]byte(validYAML), ".toml")
+
+				Convey("Then it returns an error", func() {
+					So(err, ShouldNotBeNil)
+				})
+			})
+		})
+	})
+
+	Convey("func LoadFile(path string) (*Table, error)", t, func() {
+		Convey("Given a table without version", func() {
+			path := filepath.Join(t.TempDir(), "routes.json")
+			os.WriteFile(path, []byte(`{"routes":[]}`), 0600)
+
+			Convey("When the function is called", func() {
+				table, err := LoadFile(path)
+
+				Convey("Then the version is derived from the content", func() {
+					So(err, ShouldBeNil)
+					So(len(table.Version), ShouldEqual, 12)
+				})
+			})
+		})
+	})
+}
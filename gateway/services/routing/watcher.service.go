@@ -0,0 +1,65 @@
+package routing
+
+import (
+	"context"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+)
+
+// WatchSignal reloads the route table on every SIGHUP until ctx is done.
+func (r *Router) WatchSignal(ctx context.Context) {
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+	defer signal.Stop(hup)
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-hup:
+			r.Reload()
+		}
+	}
+}
+
+// WatchFile polls the source file and reloads the route table whenever its
+// size or modification time changes, until ctx is done. Polling keeps working
+// when editors replace the file instead of writing it in place.
+func (r *Router) WatchFile(ctx context.Context, interval time.Duration) {
+	if r.path == "" {
+		return
+	}
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			info, err := os.Stat(r.path)
+			if err != nil || !changed(r.lastFile(), info) {
+				continue
+			}
+			r.Reload()
+		}
+	}
+}
+
+func (r *Router) lastFile() os.FileInfo {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	return r.file
+}
+
+func changed(before, after os.FileInfo) bool {
+	if before == nil {
+		return true
+	}
+
+	return !before.ModTime().Equal(after.ModTime()) || before.Size() != after.Size()
+}
@@ -1,57 +1,150 @@
 package security
 
 import (
+	"context"
 	"errors"
+	"gateway/config"
 	"gateway/models"
 	"gateway/services"
+	"gateway/services/logging"
+	"gateway/services/metrics"
 	"gateway/utils"
-	"log"
+	"strconv"
 	"time"
 
 	"github.com/gofiber/fiber/v2"
 	"github.com/golang-jwt/jwt/v4"
+	"go.uber.org/zap"
 	"golang.org/x/crypto/bcrypt"
 )
 
-const JWT_SECRET = "secret"
+// Auth logs users in and verifies the tokens it issued them, with the
+// settings and signing keys it was built with.
+type Auth struct {
+	settings config.Security
+	keys     *keyring
+	failures *ipFailures
+	// dummyHash is checked when there is no user to check the password of,
+	// so that unknown usernames take as long to refuse as known ones.
+	dummyHash []byte
+}
 
-func DoLogin(c *fiber.Ctx, loginDto models.LoginDto) error {
-	user, err := authenticateUser(loginDto.Username, loginDto.Password)
+// New loads the signing keys of cfg.
+func New(cfg config.Security) (*Auth, error) {
+	k, err := loadKeyring(cfg)
 	if err != nil {
-		return utils.JSONError(c, fiber.StatusUnauthorized, err, nil)
+		return nil, err
+	}
+	dummyHash, err := bcrypt.GenerateFromPassword([]byte("not a password"), cfg.BcryptCost)
+	if err != nil {
+		return nil, err
+	}
+
+	return &Auth{settings: cfg, keys: k, failures: newIPFailures(cfg.Lockout), dummyHash: dummyHash}, nil
+}
+
+// KeyFunc supplies the key verifying an access token, chosen by its kid.
+func (a *Auth) KeyFunc(t *jwt.Token) (interface{}, error) {
+	return a.keys.keyFunc(t)
+}
+
+// JWKS lists the public keys downstream services verify access tokens with.
+func (a *Auth) JWKS() JWKSet {
+	return a.keys.jwks()
+}
+
+// DoLogin refuses clients and accounts with too many failed passwords before
+// it checks the password, so that guessing goes no faster than the lockout
+// settings allow.
+func (a *Auth) DoLogin(c *fiber.Ctx, loginDto models.LoginDto) error {
+	withAuditActor(c, nil)
+	now := time.Now()
+	ip := c.IP()
+	if wait := a.failures.wait(ip, now); wait > 0 {
+		metrics.Login("throttled")
+		auditLogin(c, loginDto.Username, nil, "Too many attempts from client")
+		return tooManyLoginAttempts(c, wait)
 	}
 
-	token, err := generateJWT(*user)
+	// user is nil for unknown usernames, which are counted and locked all
+	// the same so that they cannot be told apart from existing ones.
+	user, _ := services.GetUserByUsername(c.UserContext(), loginDto.Username)
+	until, err := services.ClaimLoginAttempt(c.UserContext(), loginDto.Username, a.settings.Lockout)
+	if errors.Is(err, services.ErrLoginLocked) {
+		metrics.Login("locked")
+		auditLogin(c, loginDto.Username, user, "Account locked")
+		return tooManyLoginAttempts(c, until.Sub(now))
+	}
 	if err != nil {
 		return utils.JSONError(c, fiber.StatusInternalServerError, err, nil)
 	}
 
-	return utils.JSON(c, models.LoginResponseDto{AccessToken: *token})
+	if err := a.authenticateUser(user, loginDto.Password); err != nil {
+		metrics.Login("failure")
+		auditLogin(c, loginDto.Username, user, err.Error())
+		a.failures.fail(ip, now)
+		return utils.JSONError(c, fiber.StatusUnauthorized, err, nil)
+	}
+
+	if err := services.RecordSuccessfulLogin(c.UserContext(), user); err != nil {
+		return utils.JSONError(c, fiber.StatusInternalServerError, err, nil)
+	}
+	metrics.Login("success")
+	auditLogin(c, loginDto.Username, user, "")
+
+	return a.issueTokens(c, *user, loginDto.DeviceID)
 }
 
-func authenticateUser(username, password string) (*models.User, error) {
-	user, err := services.GetUserByUsername(username)
-	if err != nil || !user.IsActive {
-		return nil, errors.New("Authentication failed")
+// auditLogin records a login attempt for username, successful unless a
+// reason is given. user is nil when no such user exists.
+func auditLogin(c *fiber.Ctx, username string, user *models.User, reason string) {
+	event := models.AuditEvent{
+		Action: models.AuditLogin, Success: reason == "",
+		TargetType: models.AuditTargetUser, TargetName: username, Reason: reason,
+	}
+	if user != nil {
+		event.TargetID = &user.ID
+		if event.Success {
+			event.ActorID, event.ActorName = &user.ID, user.Username
+		}
 	}
 
-	if err := bcrypt.CompareHashAndPassword([]byte(user.Password), []byte(password)); err != nil {
-		return nil, errors.New("Authentication failed")
+	services.Audit(c.UserContext(), event)
+}
+
+// authenticateUser always runs bcrypt, against the dummy hash for unknown
+// users, so that timing does not tell which usernames exist.
+func (a *Auth) authenticateUser(user *models.User, password string) error {
+	hash := a.dummyHash
+	if user != nil {
+		hash = []byte(user.Password)
 	}
 
-	return user, nil
+	err := bcrypt.CompareHashAndPassword(hash, []byte(password))
+	if err != nil || user == nil || !user.IsActive {
+		return errors.New("Authentication failed")
+	}
+
+	return nil
 }
 
-func generateJWT(user models.User) (*string, error) {
+func (a *Auth) generateJWT(ctx context.Context, user models.User) (*string, error) {
+	jti, err := randomToken(16)
+	if err != nil {
+		return nil, err
+	}
+
+	now := time.Now()
 	claims := jwt.MapClaims{
+		"jti":      jti,
+		"sub":      strconv.FormatUint(uint64(user.ID), 10),
 		"username": user.Username,
-		"exp":      time.Now().Add(time.Hour * 72).Unix(),
+		"iat":      float64(now.UnixMilli()) / 1000,
+		"exp":      now.Add(time.Duration(a.settings.AccessTokenTTL)).Unix(),
 	}
-	tokenizer := jwt.NewWithClaims(jwt.SigningMethodHS512, claims)
-
-	token, err := tokenizer.SignedString([]byte(JWT_SECRET))
+	token, err := a.keys.sign(claims)
 	if err != nil {
-		log.Fatal(err.Error())
+		logging.Ctx(ctx, "security").Error("Failed to sign JWT", zap.Error(err))
 		return nil, errors.New("Failed to sign JWT")
 	}
 
@@ -62,21 +155,50 @@ func JwtError(c *fiber.Ctx, err error) error {
 	return utils.JSONError(c, fiber.StatusUnauthorized, err, nil)
 }
 
-func JwtSuccess(c *fiber.Ctx) error {
+func (a *Auth) JwtSuccess(c *fiber.Ctx) error {
 	token := c.Locals("user").(*jwt.Token)
 	claims := token.Claims.(jwt.MapClaims)
-	username := claims["username"].(string)
-	user, err := services.GetUserByUsername(username)
+	username, _ := claims["username"].(string)
+	sub, _ := claims["sub"].(string)
+	jti, _ := claims["jti"].(string)
+	iat, _ := claims["iat"].(float64)
+
+	if jti == "" || IsTokenRevoked(jti) {
+		return utils.JSONStatus(c, fiber.StatusUnauthorized, "Token revoked", nil)
+	}
 
+	id, err := strconv.ParseUint(sub, 10, 64)
 	if err != nil {
 		return utils.JSONStatus(c, fiber.StatusUnauthorized, "User not found", fiber.Map{username: username})
 	}
+	user, err := services.GetPrincipal(c.UserContext(), uint(id), time.Duration(a.settings.PermissionCacheTTL))
+	if err != nil {
+		return utils.JSONStatus(c, fiber.StatusUnauthorized, "User not found", fiber.Map{username: username})
+	}
+
+	if !user.IsActive || issuedBefore(iat, user.PasswordChangedAt) || issuedBefore(iat, user.SessionsRevokedAt) {
+		return utils.JSONStatus(c, fiber.StatusUnauthorized, "Token revoked", nil)
+	}
 
-	c.Locals("user", models.ToUserSafeDto(*user))
+	safeUser := models.ToUserSafeDto(*user)
+	c.Locals("token", token)
+	c.Locals("user", safeUser)
+	withAuditActor(c, safeUser)
 
 	return c.Next()
 }
 
+// withAuditActor attributes the audit events of the request to user, or to an
+// anonymous client when nil.
+func withAuditActor(c *fiber.Ctx, user *models.UserSafeDto) {
+	actor := services.AuditActor{IP: c.IP(), RequestID: utils.RequestID(c)}
+	if user != nil {
+		actor.UserID, actor.Username = user.ID, user.Username
+	}
+
+	c.SetUserContext(services.WithAuditActor(c.UserContext(), actor))
+}
+
 func GetUserFromLocals(c *fiber.Ctx) *models.UserSafeDto {
 	return c.Locals("user").(*models.UserSafeDto)
 }
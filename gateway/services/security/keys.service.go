@@ -0,0 +1,192 @@
+package security
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"gateway/config"
+	"math/big"
+	"os"
+	"sort"
+
+	"github.com/golang-jwt/jwt/v4"
+)
+
+// signingKey is one entry of the keyring. HMAC keys have no public part and
+// are never published.
+type signingKey struct {
+	ID      string
+	Method  jwt.SigningMethod
+	Private interface{}
+	Public  crypto.PublicKey
+}
+
+// keyring signs with the active key and verifies with every key it holds, so
+// that tokens signed by a retiring key stay valid until they expire.
+type keyring struct {
+	active *signingKey
+	byID   map[string]*signingKey
+}
+
+// JWK is a public key in the JSON Web Key format (RFC 7517).
+type JWK struct {
+	Kty string `json:"kty"`
+	Kid string `json:"kid"`
+	Use string `json:"use"`
+	Alg string `json:"alg"`
+	N   string `json:"n,omitempty"`
+	E   string `json:"e,omitempty"`
+	Crv string `json:"crv,omitempty"`
+	X   string `json:"x,omitempty"`
+	Y   string `json:"y,omitempty"`
+}
+
+type JWKSet struct {
+	Keys []JWK `json:"keys"`
+}
+
+// loadKeyring uses the PEM private keys from cfg.Keys when there are any and
+// falls back to HS512 with cfg.JWTSecret, which suits local development.
+func loadKeyring(cfg config.Security) (*keyring, error) {
+	k := &keyring{byID: map[string]*signingKey{}}
+
+	if len(cfg.Keys) == 0 {
+		k.active = &signingKey{ID: "", Method: jwt.SigningMethodHS512, Private: []byte(cfg.JWTSecret)}
+		k.byID[""] = k.active
+		return k, nil
+	}
+
+	for _, kc := range cfg.Keys {
+		key, err := loadPrivateKey(kc.ID, kc.File)
+		if err != nil {
+			return nil, err
+		}
+		k.byID[kc.ID] = key
+	}
+
+	k.active = k.byID[cfg.ActiveKeyID]
+	if k.active == nil {
+		return nil, errors.New("Active signing key not found: " + cfg.ActiveKeyID)
+	}
+
+	return k, nil
+}
+
+func loadPrivateKey(id, path string) (*signingKey, error) {
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	block, _ := pem.Decode(content)
+	if block == nil {
+		return nil, fmt.Errorf("%s: no PEM data", path)
+	}
+
+	var private interface{}
+	switch block.Type {
+	case "RSA PRIVATE KEY":
+		private, err = x509.ParsePKCS1PrivateKey(block.Bytes)
+	case "EC PRIVATE KEY":
+		private, err = x509.ParseECPrivateKey(block.Bytes)
+	default:
+		private, err = x509.ParsePKCS8PrivateKey(block.Bytes)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+
+	key := &signingKey{ID: id, Private: private}
+	switch p := private.(type) {
+	case *rsa.PrivateKey:
+		key.Method, key.Public = jwt.SigningMethodRS256, &p.PublicKey
+	case *ecdsa.PrivateKey:
+		key.Public = &p.PublicKey
+		switch p.Curve {
+		case elliptic.P256():
+			key.Method = jwt.SigningMethodES256
+		case elliptic.P384():
+			key.Method = jwt.SigningMethodES384
+		case elliptic.P521():
+			key.Method = jwt.SigningMethodES512
+		default:
+			return nil, fmt.Errorf("%s: unsupported curve", path)
+		}
+	case ed25519.PrivateKey:
+		key.Method, key.Public = jwt.SigningMethodEdDSA, p.Public()
+	default:
+		return nil, fmt.Errorf("%s: unsupported key type", path)
+	}
+
+	return key, nil
+}
+
+func (k *keyring) sign(claims jwt.Claims) (string, error) {
+	token := jwt.NewWithClaims(k.active.Method, claims)
+	if k.active.ID != "" {
+		token.Header["kid"] = k.active.ID
+	}
+
+	return token.SignedString(k.active.Private)
+}
+
+// keyFunc picks the verification key from the kid header and refuses tokens
+// whose algorithm does not match that key.
+func (k *keyring) keyFunc(t *jwt.Token) (interface{}, error) {
+	kid, _ := t.Header["kid"].(string)
+	key, ok := k.byID[kid]
+	if !ok {
+		return nil, errors.New("Unknown signing key")
+	}
+	if t.Method.Alg() != key.Method.Alg() {
+		return nil, fmt.Errorf("Unexpected jwt signing method=%v", t.Header["alg"])
+	}
+
+	if key.Public == nil {
+		return key.Private, nil
+	}
+	return key.Public, nil
+}
+
+func (k *keyring) jwks() JWKSet {
+	set := JWKSet{Keys: []JWK{}}
+
+	for _, key := range k.byID {
+		jwk := JWK{Kid: key.ID, Use: "sig", Alg: key.Method.Alg()}
+
+		switch p := key.Public.(type) {
+		case *rsa.PublicKey:
+			jwk.Kty = "RSA"
+			jwk.N = b64(p.N.Bytes())
+			jwk.E = b64(big.NewInt(int64(p.E)).Bytes())
+		case *ecdsa.PublicKey:
+			size := (p.Curve.Params().BitSize + 7) / 8
+			jwk.Kty = "EC"
+			jwk.Crv = p.Curve.Params().Name
+			jwk.X = b64(p.X.FillBytes(make([]byte, size)))
+			jwk.Y = b64(p.Y.FillBytes(make([]byte, size)))
+		case ed25519.PublicKey:
+			jwk.Kty = "OKP"
+			jwk.Crv = "Ed25519"
+			jwk.X = b64(p)
+		default:
+			continue
+		}
+
+		set.Keys = append(set.Keys, jwk)
+	}
+	sort.Slice(set.Keys, func(i, j int) bool { return set.Keys[i].Kid < set.Keys[j].Kid })
+
+	return set
+}
+
+func b64(b []byte) string {
+	return base64.RawURLEncoding.EncodeToString(b)
+}
@@ -0,0 +1,130 @@
+package security
+
+import (
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/pem"
+	"gateway/config"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/golang-jwt/jwt/v4"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func writeKey(dir, name string, key interface{}) string {
+	der, err := x509.MarshalPKCS8PrivateKey(key)
+	So(err, ShouldBeNil)
+
+	path := filepath.Join(dir, name+".pem")
+	os.WriteFile(path, pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der}), 0600)
+	return path
+}
+
+func TestKeyring(t *testing.T) {
+	Convey("func loadKeyring(cfg config.Security) (*keyring, error)", t, func() {
+		dir := t.TempDir()
+		rsaKey, _ := rsa.GenerateKey(rand.Reader, 2048)
+		ecKey, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+		_, edKey, _ := ed25519.GenerateKey(rand.Reader)
+		keys := []config.SigningKey{
+			{ID: "rsa", File: writeKey(dir, "rsa", rsaKey)},
+			{ID: "ec", File: writeKey(dir, "ec", ecKey)},
+			{ID: "ed", File: writeKey(dir, "ed", edKey)},
+		}
+
+		Convey("Given RSA, ECDSA and Ed25519 keys", func() {
+			Convey("When each of them is the active key", func() {
+				Convey("Then tokens are signed with the matching algorithm and verify", func() {
+					for id, alg := range map[string]string{"rsa": "RS256", "ec": "ES256", "ed": "EdDSA"} {
+						k, err := loadKeyring(config.Security{Keys: keys, ActiveKeyID: id})
+						So(err, ShouldBeNil)
+
+						signed, err := k.sign(jwt.MapClaims{"username": "user"})
+						So(err, ShouldBeNil)
+
+						token, err := jwt.Parse(signed, k.keyFunc)
+						So(err, ShouldBeNil)
+						So(token.Header["kid"], ShouldEqual, id)
+						So(token.Method.Alg(), ShouldEqual, alg)
+					}
+				})
+			})
+
+			Convey("When the active key is rotated", func() {
+				old, _ := loadKeyring(config.Security{Keys: keys, ActiveKeyID: "rsa"})
+				signed, _ := old.sign(jwt.MapClaims{"username": "user"})
+				rotated, _ := loadKeyring(config.Security{Keys: keys, ActiveKeyID: "ed"})
+
+				Convey("Then tokens signed by the retiring key still verify", func() {
+					_, err := jwt.Parse(signed, rotated.keyFunc)
+					So(err, ShouldBeNil)
+				})
+
+				Convey("Then they are rejected once the retiring key is removed", func() {
+					removed, _ := loadKeyring(config.Security{Keys: keys[1:], ActiveKeyID: "ed"})
+					_, err := jwt.Parse(signed, removed.keyFunc)
+					So(err, ShouldNotBeNil)
+				})
+			})
+
+			Convey("When a token claims another algorithm for a known kid", func() {
+				k, _ := loadKeyring(config.Security{Keys: keys, ActiveKeyID: "rsa"})
+				forged := jwt.NewWithClaims(jwt.SigningMethodHS256, jwt.MapClaims{"username": "admin"})
+				forged.Header["kid"] = "rsa"
+				signed, _ := forged.SignedString(x509.MarshalPKCS1PublicKey(&rsaKey.PublicKey))
+
+				Convey("Then it is rejected", func() {
+					_, err := jwt.Parse(signed, k.keyFunc)
+					So(err, ShouldNotBeNil)
+				})
+			})
+
+			Convey("When the JWKS is requested", func() {
+				k, _ := loadKeyring(config.Security{Keys: keys, ActiveKeyID: "rsa"})
+				set := k.jwks()
+
+				Convey("Then every public key is published", func() {
+					So(len(set.Keys), ShouldEqual, 3)
+					So(set.Keys[0].Kid, ShouldEqual, "ec")
+					So(set.Keys[0].Kty, ShouldEqual, "EC")
+					So(set.Keys[0].Crv, ShouldEqual, "P-256")
+					So(len(set.Keys[0].X), ShouldEqual, 43)
+					So(set.Keys[1].Kty, ShouldEqual, "OKP")
+					So(set.Keys[2].Kty, ShouldEqual, "RSA")
+					So(set.Keys[2].E, ShouldEqual, "AQAB")
+				})
+			})
+		})
+
+		Convey("Given no keys", func() {
+			Convey("When the function is called", func() {
+				k, err := loadKeyring(config.Security{JWTSecret: "secret"})
+
+				Convey("Then it signs with HS512 and publishes nothing", func() {
+					So(err, ShouldBeNil)
+					signed, _ := k.sign(jwt.MapClaims{"username": "user"})
+					token, err := jwt.Parse(signed, k.keyFunc)
+					So(err, ShouldBeNil)
+					So(token.Method.Alg(), ShouldEqual, "HS512")
+					So(len(k.jwks().Keys), ShouldEqual, 0)
+				})
+			})
+		})
+
+		Convey("Given an active key that is not in the list", func() {
+			Convey("When the function is called", func() {
+				_, err := loadKeyring(config.Security{Keys: keys, ActiveKeyID: "missing"})
+
+				Convey("Then it returns an error", func() {
+					So(err, ShouldNotBeNil)
+				})
+			})
+		})
+	})
+}
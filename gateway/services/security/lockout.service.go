@@ -0,0 +1,86 @@
+package security
+
+import (
+	"gateway/config"
+	"gateway/utils"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// ipFailures counts failed logins per client IP in fixed windows, which
+// catches password spraying across many usernames. It is kept in memory, so
+// each gateway instance counts on its own.
+type ipFailures struct {
+	lockout config.Lockout
+	mu      sync.Mutex
+	windows map[string]*ipWindow
+}
+
+type ipWindow struct {
+	start time.Time
+	count int
+}
+
+func newIPFailures(lockout config.Lockout) *ipFailures {
+	return &ipFailures{lockout: lockout, windows: map[string]*ipWindow{}}
+}
+
+// wait returns how long ip has to wait before trying again.
+func (f *ipFailures) wait(ip string, now time.Time) time.Duration {
+	l := f.lockout
+	if l.IPThreshold <= 0 {
+		return 0
+	}
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	w, ok := f.windows[ip]
+	if !ok || w.count < l.IPThreshold {
+		return 0
+	}
+	if end := w.start.Add(time.Duration(l.IPWindow)); now.Before(end) {
+		return end.Sub(now)
+	}
+	delete(f.windows, ip)
+
+	return 0
+}
+
+func (f *ipFailures) fail(ip string, now time.Time) {
+	l := f.lockout
+	if l.IPThreshold <= 0 {
+		return
+	}
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	w, ok := f.windows[ip]
+	if !ok || !now.Before(w.start.Add(time.Duration(l.IPWindow))) {
+		f.sweep(now)
+		w = &ipWindow{start: now}
+		f.windows[ip] = w
+	}
+	w.count++
+}
+
+// sweep drops windows that have ended. It runs when a window starts, so the
+// map only grows with IPs that failed recently.
+func (f *ipFailures) sweep(now time.Time) {
+	for ip, w := range f.windows {
+		if !now.Before(w.start.Add(time.Duration(f.lockout.IPWindow))) {
+			delete(f.windows, ip)
+		}
+	}
+}
+
+func tooManyLoginAttempts(c *fiber.Ctx, wait time.Duration) error {
+	seconds := int((wait + time.Second - 1) / time.Second)
+	c.Set(fiber.HeaderRetryAfter, strconv.Itoa(seconds))
+
+	return utils.JSONStatus(c, fiber.StatusTooManyRequests, "Too many failed login attempts", fiber.Map{"retryAfter": seconds})
+}
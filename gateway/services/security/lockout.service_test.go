@@ -0,0 +1,67 @@
+package security
+
+import (
+	"gateway/config"
+	"gateway/services"
+	"testing"
+	"time"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestLockout(t *testing.T) {
+	lockout := config.Lockout{
+		FreeAttempts: 2,
+		Delay:        config.Duration(time.Second),
+		Threshold:    6,
+		Duration:     config.Duration(5 * time.Second),
+		IPThreshold:  2,
+		IPWindow:     config.Duration(time.Minute),
+	}
+
+	Convey("func services.LoginBackoff(failures int, l config.Lockout) time.Duration", t, func() {
+		Convey("Given the lockout settings", func() {
+			Convey("When the number of failures grows", func() {
+				var waits []time.Duration
+				for failures := 1; failures <= 6; failures++ {
+					waits = append(waits, services.LoginBackoff(failures, lockout))
+				}
+
+				Convey("Then free attempts wait nothing, then delays double up to the lockout", func() {
+					So(waits, ShouldResemble, []time.Duration{0, 0, time.Second, 2 * time.Second, 4 * time.Second, 5 * time.Second})
+				})
+			})
+		})
+	})
+
+	Convey("type ipFailures", t, func() {
+		f := newIPFailures(lockout)
+		now := time.Now()
+
+		Convey("Given an IP reached the threshold", func() {
+			f.fail("10.0.0.1", now)
+			f.fail("10.0.0.1", now.Add(10*time.Second))
+
+			Convey("When it tries again within the window", func() {
+				wait := f.wait("10.0.0.1", now.Add(20*time.Second))
+
+				Convey("Then it waits until the window ends", func() {
+					So(wait, ShouldEqual, 40*time.Second)
+				})
+			})
+
+			Convey("When another IP tries", func() {
+				Convey("Then it does not wait", func() {
+					So(f.wait("10.0.0.2", now), ShouldEqual, 0)
+				})
+			})
+
+			Convey("When the window has ended", func() {
+				Convey("Then it does not wait any more", func() {
+					So(f.wait("10.0.0.1", now.Add(time.Minute)), ShouldEqual, 0)
+					So(len(f.windows), ShouldEqual, 0)
+				})
+			})
+		})
+	})
+}
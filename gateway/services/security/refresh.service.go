@@ -0,0 +1,130 @@
+package security
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"gateway/models"
+	"gateway/services"
+	"gateway/utils"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// DoRefresh exchanges a refresh token for a new access and refresh token.
+// Presenting a token that was already exchanged revokes its whole family,
+// since either the client or an attacker holds a stolen copy.
+func (a *Auth) DoRefresh(c *fiber.Ctx, dto models.RefreshTokenDto) error {
+	withAuditActor(c, nil)
+	old, err := services.GetRefreshTokenByHash(c.UserContext(), hashToken(dto.RefreshToken))
+	if err != nil {
+		return utils.JSONStatus(c, fiber.StatusUnauthorized, "Invalid refresh token", nil)
+	}
+
+	if old.UsedAt != nil {
+		revokeTokenFamily(c, old, "Refresh token reuse detected")
+		return utils.JSONStatus(c, fiber.StatusUnauthorized, "Refresh token reuse detected", nil)
+	}
+	if old.RevokedAt != nil || time.Now().After(old.ExpiresAt) {
+		return utils.JSONStatus(c, fiber.StatusUnauthorized, "Invalid refresh token", nil)
+	}
+
+	user, err := services.GetUserByID(c.UserContext(), old.UserID)
+	if err != nil || !user.IsActive {
+		revokeTokenFamily(c, old, "User inactive")
+		return utils.JSONStatus(c, fiber.StatusUnauthorized, "Invalid refresh token", nil)
+	}
+
+	refresh, next, err := a.newRefreshToken(*user, old.FamilyID, old.DeviceID)
+	if err != nil {
+		return utils.JSONError(c, fiber.StatusInternalServerError, err, nil)
+	}
+	if err := services.RotateRefreshToken(c.UserContext(), old, next); err != nil {
+		if errors.Is(err, services.ErrRefreshTokenUsed) {
+			revokeTokenFamily(c, old, "Refresh token reuse detected")
+			return utils.JSONStatus(c, fiber.StatusUnauthorized, "Refresh token reuse detected", nil)
+		}
+		return utils.JSONError(c, fiber.StatusInternalServerError, err, nil)
+	}
+
+	return a.sendTokens(c, *user, refresh)
+}
+
+// revokeTokenFamily revokes every refresh token descending from the same login
+// as token, and records why in the audit trail.
+func revokeTokenFamily(c *fiber.Ctx, token *models.RefreshToken, reason string) error {
+	if err := services.RevokeRefreshTokenFamily(c.UserContext(), token.FamilyID); err != nil {
+		return err
+	}
+	services.Audit(c.UserContext(), models.AuditEvent{
+		Action: models.AuditTokenFamilyRevoke, Success: true,
+		TargetType: models.AuditTargetUser, TargetID: &token.UserID, Reason: reason,
+	})
+
+	return nil
+}
+
+// issueTokens starts a new token family for a fresh login.
+func (a *Auth) issueTokens(c *fiber.Ctx, user models.User, deviceID string) error {
+	familyID, err := randomToken(16)
+	if err != nil {
+		return utils.JSONError(c, fiber.StatusInternalServerError, err, nil)
+	}
+
+	refresh, token, err := a.newRefreshToken(user, familyID, deviceID)
+	if err != nil {
+		return utils.JSONError(c, fiber.StatusInternalServerError, err, nil)
+	}
+	if err := services.CreateRefreshToken(c.UserContext(), token); err != nil {
+		return utils.JSONError(c, fiber.StatusInternalServerError, err, nil)
+	}
+
+	return a.sendTokens(c, user, refresh)
+}
+
+func (a *Auth) sendTokens(c *fiber.Ctx, user models.User, refresh string) error {
+	access, err := a.generateJWT(c.UserContext(), user)
+	if err != nil {
+		return utils.JSONError(c, fiber.StatusInternalServerError, err, nil)
+	}
+
+	return utils.JSON(c, models.LoginResponseDto{
+		AccessToken:  *access,
+		RefreshToken: refresh,
+		ExpiresIn:    int64(time.Duration(a.settings.AccessTokenTTL).Seconds()),
+	})
+}
+
+// newRefreshToken returns the opaque token for the client and the record to
+// persist, which only holds its hash.
+func (a *Auth) newRefreshToken(user models.User, familyID, deviceID string) (string, *models.RefreshToken, error) {
+	raw, err := randomToken(32)
+	if err != nil {
+		return "", nil, err
+	}
+
+	return raw, &models.RefreshToken{
+		UserID:    user.ID,
+		FamilyID:  familyID,
+		TokenHash: hashToken(raw),
+		DeviceID:  deviceID,
+		ExpiresAt: time.Now().Add(time.Duration(a.settings.RefreshTokenTTL)),
+	}, nil
+}
+
+func randomToken(size int) (string, error) {
+	b := make([]byte, size)
+	if _, err := rand.Read(b); err != nil {
+		return "", errors.New("Failed to generate token")
+	}
+
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
+
+func hashToken(raw string) string {
+	sum := sha256.Sum256([]byte(raw))
+	return hex.EncodeToString(sum[:])
+}
@@ -0,0 +1,132 @@
+package security
+
+import (
+	"context"
+	"gateway/models"
+	"gateway/services"
+	"gateway/services/logging"
+	"gateway/utils"
+	"math"
+	"sync"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/golang-jwt/jwt/v4"
+	"go.uber.org/zap"
+)
+
+// revocations mirrors the revoked_tokens table so that Protected() never
+// queries the database for it. Other instances' revocations arrive with the
+// next SyncRevocations.
+var revocations = struct {
+	sync.RWMutex
+	jtis map[string]time.Time
+}{jtis: map[string]time.Time{}}
+
+func IsTokenRevoked(jti string) bool {
+	revocations.RLock()
+	defer revocations.RUnlock()
+
+	_, ok := revocations.jtis[jti]
+	return ok
+}
+
+// RevokeToken blocks an access token until it expires.
+func RevokeToken(ctx context.Context, jti string, userID uint, expiresAt time.Time) error {
+	err := services.CreateRevokedToken(ctx, &models.RevokedToken{JTI: jti, UserID: userID, ExpiresAt: expiresAt})
+	if err != nil {
+		return err
+	}
+
+	revocations.Lock()
+	revocations.jtis[jti] = expiresAt
+	revocations.Unlock()
+	services.Audit(ctx, models.AuditEvent{Action: models.AuditTokenRevoke, Success: true, TargetType: models.AuditTargetUser, TargetID: &userID})
+
+	return nil
+}
+
+// SyncRevocations replaces the cache with the revocations stored in the
+// database, dropping the ones whose tokens have expired.
+func SyncRevocations(ctx context.Context) error {
+	tokens, err := services.FindRevokedTokens(ctx)
+	if err != nil {
+		return err
+	}
+
+	jtis := make(map[string]time.Time, len(tokens))
+	for _, t := range tokens {
+		jtis[t.JTI] = t.ExpiresAt
+	}
+
+	revocations.Lock()
+	revocations.jtis = jtis
+	revocations.Unlock()
+
+	return nil
+}
+
+// WatchRevocations syncs the cache every interval until ctx is done.
+func WatchRevocations(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if err := SyncRevocations(ctx); err != nil {
+				logging.Ctx(ctx, "security").Warn("Failed to sync token revocations", zap.Error(err))
+			}
+		}
+	}
+}
+
+// DoLogout revokes the access token of the request and, when given, the
+// refresh token family it was issued with.
+func DoLogout(c *fiber.Ctx, dto models.LogoutDto) error {
+	user := GetUserFromLocals(c)
+	claims := getClaimsFromLocals(c)
+
+	jti, _ := claims["jti"].(string)
+	exp, _ := claims["exp"].(float64)
+	if jti != "" {
+		if err := RevokeToken(c.UserContext(), jti, user.ID, time.Unix(int64(exp), 0)); err != nil {
+			return utils.JSONError(c, fiber.StatusInternalServerError, err, nil)
+		}
+	}
+
+	if dto.RefreshToken != "" {
+		token, err := services.GetRefreshTokenByHash(c.UserContext(), hashToken(dto.RefreshToken))
+		if err == nil && token.UserID == user.ID {
+			if err := revokeTokenFamily(c, token, "Logout"); err != nil {
+				return utils.JSONError(c, fiber.StatusInternalServerError, err, nil)
+			}
+		}
+	}
+
+	return utils.JSONMessage(c, "Logged out", nil)
+}
+
+// DoLogoutEverywhere invalidates every access and refresh token of the user.
+func DoLogoutEverywhere(c *fiber.Ctx) error {
+	user := GetUserFromLocals(c)
+
+	if err := services.RevokeSessions(c.UserContext(), user.ID); err != nil {
+		return utils.JSONError(c, fiber.StatusInternalServerError, err, nil)
+	}
+
+	return utils.JSONMessage(c, "Logged out everywhere", nil)
+}
+
+// issuedBefore reports whether a token with the given iat claim may predate
+// t. iat has a precision of one millisecond, so tokens issued within the
+// millisecond of t count as issued before it.
+func issuedBefore(iat float64, t *time.Time) bool {
+	return t != nil && int64(math.Round(iat*1000)) <= t.UnixMilli()
+}
+
+func getClaimsFromLocals(c *fiber.Ctx) jwt.MapClaims {
+	return c.Locals("token").(*jwt.Token).Claims.(jwt.MapClaims)
+}
@@ -0,0 +1,130 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"gateway/models"
+	"gateway/services/db"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+var ErrRefreshTokenUsed = errors.New("Refresh token already used")
+
+func CreateRefreshToken(ctx context.Context, token *models.RefreshToken) error {
+	if result := db.Conn.WithContext(ctx).Create(token); result.Error != nil {
+		logDBError(ctx, result.Error)
+		return errors.New("Error writing to database")
+	}
+
+	return nil
+}
+
+func GetRefreshTokenByHash(ctx context.Context, hash string) (*models.RefreshToken, error) {
+	token := new(models.RefreshToken)
+	result := db.Conn.WithContext(ctx).Where("token_hash = ?", hash).First(token)
+
+	if result.Error != nil {
+		if !errors.Is(result.Error, gorm.ErrRecordNotFound) {
+			logDBError(ctx, result.Error)
+		}
+		return nil, errors.New("No refresh token found")
+	}
+
+	return token, nil
+}
+
+// RotateRefreshToken marks old as used and stores next in one transaction.
+// It returns ErrRefreshTokenUsed when another request rotated old first.
+func RotateRefreshToken(ctx context.Context, old *models.RefreshToken, next *models.RefreshToken) error {
+	return db.Conn.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		result := tx.Model(&models.RefreshToken{}).
+			Where("id = ? AND used_at IS NULL AND revoked_at IS NULL", old.ID).
+			Update("used_at", time.Now())
+		if result.Error != nil {
+			logDBError(ctx, result.Error)
+			return errors.New("Error writing to database")
+		}
+		if result.RowsAffected == 0 {
+			return ErrRefreshTokenUsed
+		}
+
+		if result := tx.Create(next); result.Error != nil {
+			logDBError(ctx, result.Error)
+			return errors.New("Error writing to database")
+		}
+
+		return nil
+	})
+}
+
+// RevokeRefreshTokenFamily revokes every token descending from the same login.
+func RevokeRefreshTokenFamily(ctx context.Context, familyID string) error {
+	result := db.Conn.WithContext(ctx).Model(&models.RefreshToken{}).
+		Where("family_id = ? AND revoked_at IS NULL", familyID).
+		Update("revoked_at", time.Now())
+
+	if result.Error != nil {
+		logDBError(ctx, result.Error)
+		return errors.New("Error writing to database")
+	}
+
+	return nil
+}
+
+// RevokeRefreshTokensForUser revokes every refresh token of the user.
+func RevokeRefreshTokensForUser(ctx context.Context, userID uint) error {
+	result := db.Conn.WithContext(ctx).Model(&models.RefreshToken{}).
+		Where("user_id = ? AND revoked_at IS NULL", userID).
+		Update("revoked_at", time.Now())
+
+	if result.Error != nil {
+		logDBError(ctx, result.Error)
+		return errors.New("Error writing to database")
+	}
+
+	return nil
+}
+
+func CreateRevokedToken(ctx context.Context, token *models.RevokedToken) error {
+	result := db.Conn.WithContext(ctx).Create(token)
+
+	if result.Error != nil && !db.IsUniqueViolation(result.Error, "") {
+		logDBError(ctx, result.Error)
+		return errors.New("Error writing to database")
+	}
+
+	return nil
+}
+
+// FindRevokedTokens returns the revocations of tokens that have not expired.
+func FindRevokedTokens(ctx context.Context) ([]models.RevokedToken, error) {
+	var tokens []models.RevokedToken
+	result := db.Conn.WithContext(ctx).Where("expires_at > ?", time.Now()).Find(&tokens)
+
+	if result.Error != nil {
+		logDBError(ctx, result.Error)
+		return nil, errors.New("Error when reading database")
+	}
+
+	return tokens, nil
+}
+
+// RevokeSessions invalidates every access and refresh token of the user.
+func RevokeSessions(ctx context.Context, userID uint) error {
+	result := db.Conn.WithContext(ctx).Model(&models.User{}).Where("id = ?", userID).Update("sessions_revoked_at", time.Now())
+
+	if result.Error != nil {
+		logDBError(ctx, result.Error)
+		return errors.New("Error writing to database")
+	}
+	evictPrincipal(userID)
+
+	if err := RevokeRefreshTokensForUser(ctx, userID); err != nil {
+		return err
+	}
+	Audit(ctx, models.AuditEvent{Action: models.AuditSessionsRevoke, Success: true, TargetType: models.AuditTargetUser, TargetID: &userID})
+
+	return nil
+}
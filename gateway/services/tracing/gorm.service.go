@@ -0,0 +1,77 @@
+package tracing
+
+import (
+	"errors"
+
+	"go.opentelemetry.io/otel/codes"
+	semconv "go.opentelemetry.io/otel/semconv/v1.12.0"
+	"go.opentelemetry.io/otel/trace"
+	"gorm.io/gorm"
+)
+
+const spanKey = "tracing:span"
+
+// GormPlugin adds a client span for every statement run through GORM, as a
+// child of the context given with WithContext. Statements are recorded with
+// their placeholders, never with the values bound to them.
+func GormPlugin() gorm.Plugin {
+	return gormPlugin{}
+}
+
+type gormPlugin struct{}
+
+func (gormPlugin) Name() string {
+	return "tracing"
+}
+
+func (gormPlugin) Initialize(db *gorm.DB) error {
+	c := db.Callback()
+	for _, err := range []error{
+		c.Create().Before("*").Register("tracing:before_create", startSpan("create")),
+		c.Create().After("*").Register("tracing:after_create", endSpan),
+		c.Query().Before("*").Register("tracing:before_query", startSpan("query")),
+		c.Query().After("*").Register("tracing:after_query", endSpan),
+		c.Update().Before("*").Register("tracing:before_update", startSpan("update")),
+		c.Update().After("*").Register("tracing:after_update", endSpan),
+		c.Delete().Before("*").Register("tracing:before_delete", startSpan("delete")),
+		c.Delete().After("*").Register("tracing:after_delete", endSpan),
+		c.Row().Before("*").Register("tracing:before_row", startSpan("row")),
+		c.Row().After("*").Register("tracing:after_row", endSpan),
+		c.Raw().Before("*").Register("tracing:before_raw", startSpan("raw")),
+		c.Raw().After("*").Register("tracing:after_raw", endSpan),
+	} {
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func startSpan(operation string) func(*gorm.DB) {
+	return func(tx *gorm.DB) {
+		_, span := Tracer().Start(tx.Statement.Context, "gorm."+operation,
+			trace.WithSpanKind(trace.SpanKindClient),
+			trace.WithAttributes(semconv.DBSystemKey.String(tx.Dialector.Name())),
+		)
+		tx.InstanceSet(spanKey, span)
+	}
+}
+
+func endSpan(tx *gorm.DB) {
+	value, ok := tx.InstanceGet(spanKey)
+	if !ok {
+		return
+	}
+	span := value.(trace.Span)
+	defer span.End()
+
+	span.SetAttributes(
+		semconv.DBStatementKey.String(tx.Statement.SQL.String()),
+		semconv.DBSQLTableKey.String(tx.Statement.Table),
+	)
+	if err := tx.Error; err != nil && !errors.Is(err, gorm.ErrRecordNotFound) {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+}
@@ -0,0 +1,149 @@
+package tracing
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"gateway/config"
+	"gateway/services/metrics"
+	"net/url"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/gofiber/fiber/v2/utils"
+	"github.com/valyala/fasthttp"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
+	"go.opentelemetry.io/otel/exporters/stdout/stdouttrace"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	semconv "go.opentelemetry.io/otel/semconv/v1.12.0"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// instrumentation names the tracer every gateway span comes from.
+const instrumentation = "gateway"
+
+// propagator reads and writes the W3C traceparent and tracestate headers,
+// and baggage along with them.
+var propagator = propagation.NewCompositeTextMapPropagator(propagation.TraceContext{}, propagation.Baggage{})
+
+// Init installs the tracer provider described by cfg. Traces are propagated
+// even with the none exporter, so that the gateway does not break the traces
+// of its callers. The returned function flushes the spans not exported yet.
+func Init(cfg config.Tracing) (func(context.Context) error, error) {
+	otel.SetTextMapPropagator(propagator)
+
+	var exporter sdktrace.SpanExporter
+	var err error
+	switch cfg.Exporter {
+	case "none":
+		return func(context.Context) error { return nil }, nil
+	case "stdout":
+		exporter, err = stdouttrace.New()
+	case "otlp":
+		exporter, err = otlpExporter(cfg.Endpoint)
+	default:
+		return nil, errors.New("Unknown tracing exporter " + cfg.Exporter)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("Failed to create the %s trace exporter: %w", cfg.Exporter, err)
+	}
+
+	provider := sdktrace.NewTracerProvider(
+		sdktrace.WithBatcher(exporter),
+		sdktrace.WithSampler(sdktrace.ParentBased(sdktrace.TraceIDRatioBased(cfg.SampleRatio))),
+		sdktrace.WithResource(resource.NewWithAttributes(semconv.SchemaURL, semconv.ServiceNameKey.String(cfg.ServiceName))),
+	)
+	otel.SetTracerProvider(provider)
+
+	return provider.Shutdown, nil
+}
+
+func otlpExporter(endpoint string) (sdktrace.SpanExporter, error) {
+	u, err := url.Parse(endpoint)
+	if err != nil {
+		return nil, err
+	}
+
+	options := []otlptracehttp.Option{otlptracehttp.WithEndpoint(u.Host)}
+	if u.Scheme == "http" {
+		options = append(options, otlptracehttp.WithInsecure())
+	}
+	if u.Path != "" && u.Path != "/" {
+		options = append(options, otlptracehttp.WithURLPath(u.Path))
+	}
+
+	return otlptracehttp.New(context.Background(), options...)
+}
+
+// Tracer starts the gateway spans. It is looked up on every call so that it
+// follows the provider installed last.
+func Tracer() trace.Tracer {
+	return otel.GetTracerProvider().Tracer(instrumentation)
+}
+
+// Inject writes the trace context of ctx into headers bound to another
+// service.
+func Inject(ctx context.Context, carrier propagation.TextMapCarrier) {
+	otel.GetTextMapPropagator().Inject(ctx, carrier)
+}
+
+// Middleware starts a server span for every request, continuing the trace of
+// the caller, and makes it the parent of everything done for the request
+// through c.UserContext(). Mount it right after the metrics middleware.
+func Middleware() fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		// Fiber reuses the bytes behind c.Method(); spans outlive requests.
+		method := utils.CopyString(c.Method())
+		ctx := otel.GetTextMapPropagator().Extract(c.UserContext(), headerCarrier{&c.Request().Header})
+		ctx, span := Tracer().Start(ctx, method,
+			trace.WithSpanKind(trace.SpanKindServer),
+			trace.WithAttributes(
+				semconv.HTTPMethodKey.String(method),
+				semconv.HTTPTargetKey.String(string(c.Request().RequestURI())),
+				semconv.HTTPSchemeKey.String(c.Protocol()),
+				semconv.HTTPClientIPKey.String(c.IP()),
+			),
+		)
+		defer span.End()
+		c.SetUserContext(ctx)
+
+		err := c.Next()
+
+		route, status := metrics.Outcome(c, err)
+		span.SetName(method + " " + route)
+		span.SetAttributes(semconv.HTTPRouteKey.String(route), semconv.HTTPStatusCodeKey.Int(status))
+		code, description := semconv.SpanStatusFromHTTPStatusCodeAndSpanKind(status, trace.SpanKindServer)
+		if err != nil && code == codes.Error {
+			span.RecordError(err)
+			description = err.Error()
+		}
+		span.SetStatus(code, description)
+
+		return err
+	}
+}
+
+// headerCarrier lets propagators read and write fasthttp request headers.
+type headerCarrier struct {
+	header *fasthttp.RequestHeader
+}
+
+func (h headerCarrier) Get(key string) string {
+	return string(h.header.Peek(key))
+}
+
+func (h headerCarrier) Set(key, value string) {
+	h.header.Set(key, value)
+}
+
+func (h headerCarrier) Keys() []string {
+	var keys []string
+	h.header.VisitAll(func(k, _ []byte) {
+		keys = append(keys, string(k))
+	})
+
+	return keys
+}
@@ -0,0 +1,120 @@
+package tracing
+
+import (
+	"context"
+	"gateway/services/tracing/tracingtest"
+	"net/http/httptest"
+	"path/filepath"
+	"testing"
+
+	"github.com/gofiber/fiber/v2"
+	. "github.com/smartystreets/goconvey/convey"
+	"go.opentelemetry.io/otel/codes"
+	semconv "go.opentelemetry.io/otel/semconv/v1.12.0"
+	"go.opentelemetry.io/otel/trace"
+	"gorm.io/driver/sqlite"
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+)
+
+type widget struct {
+	ID   uint
+	Name string
+}
+
+func TestTracing(t *testing.T) {
+	Convey("func Middleware() fiber.Handler", t, func() {
+		spans := tracingtest.InMemory()
+		app := fiber.New()
+		app.Use(Middleware())
+		app.Get("/v1/users/:id", func(c *fiber.Ctx) error {
+			_, span := Tracer().Start(c.UserContext(), "lookup")
+			span.End()
+			return c.SendStatus(fiber.StatusNoContent)
+		})
+		app.Get("/v1/broken", func(c *fiber.Ctx) error {
+			return fiber.ErrBadGateway
+		})
+
+		Convey("Given a request without trace context", func() {
+			Convey("When it is served", func() {
+				app.Test(httptest.NewRequest("GET", "/v1/users/1", nil))
+
+				Convey("Then a trace starts, named after the route template", func() {
+					So(len(spans.GetSpans()), ShouldEqual, 2)
+					lookup, server := spans.GetSpans()[0], spans.GetSpans()[1]
+					So(server.Name, ShouldEqual, "GET /v1/users/:id")
+					So(server.SpanKind, ShouldEqual, trace.SpanKindServer)
+					So(server.Parent.IsValid(), ShouldBeFalse)
+					So(server.Attributes, ShouldContain, semconv.HTTPStatusCodeKey.Int(fiber.StatusNoContent))
+					So(lookup.Parent.SpanID(), ShouldEqual, server.SpanContext.SpanID())
+				})
+			})
+		})
+
+		Convey("Given a request with a traceparent header", func() {
+			req := httptest.NewRequest("GET", "/v1/users/1", nil)
+			req.Header.Set("traceparent", "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01")
+
+			Convey("When it is served", func() {
+				app.Test(req)
+
+				Convey("Then the trace of the caller continues", func() {
+					server := spans.GetSpans()[1]
+					So(server.SpanContext.TraceID().String(), ShouldEqual, "4bf92f3577b34da6a3ce929d0e0e4736")
+					So(server.Parent.SpanID().String(), ShouldEqual, "00f067aa0ba902b7")
+					So(server.Parent.IsRemote(), ShouldBeTrue)
+				})
+			})
+		})
+
+		Convey("Given a handler returning a server error", func() {
+			Convey("When it is served", func() {
+				app.Test(httptest.NewRequest("GET", "/v1/broken", nil))
+
+				Convey("Then the span is marked as failed", func() {
+					server := spans.GetSpans()[0]
+					So(server.Status.Code, ShouldEqual, codes.Error)
+					So(server.Attributes, ShouldContain, semconv.HTTPStatusCodeKey.Int(fiber.StatusBadGateway))
+					So(len(server.Events), ShouldEqual, 1)
+				})
+			})
+		})
+	})
+
+	Convey("func GormPlugin() gorm.Plugin", t, func() {
+		spans := tracingtest.InMemory()
+		conn, _ := gorm.Open(sqlite.Open(filepath.Join(t.TempDir(), "tracing.db")), &gorm.Config{Logger: logger.Default.LogMode(logger.Silent)})
+		So(conn.Use(GormPlugin()), ShouldBeNil)
+		conn.AutoMigrate(&widget{})
+		spans.Reset()
+
+		Convey("Given a query made within a span", func() {
+			ctx, parent := Tracer().Start(context.Background(), "request")
+			conn.WithContext(ctx).Create(&widget{Name: "secret"})
+			conn.WithContext(ctx).Where("name = ?", "secret").First(&widget{})
+			parent.End()
+
+			Convey("Then every statement gets a child span without its values", func() {
+				So(len(spans.GetSpans()), ShouldEqual, 3)
+				create, query := spans.GetSpans()[0], spans.GetSpans()[1]
+				So(create.Name, ShouldEqual, "gorm.create")
+				So(query.Name, ShouldEqual, "gorm.query")
+				So(query.Parent.SpanID(), ShouldEqual, parent.SpanContext().SpanID())
+				So(query.Attributes, ShouldContain, semconv.DBSystemKey.String("sqlite"))
+				So(query.Attributes, ShouldContain, semconv.DBSQLTableKey.String("widgets"))
+				for _, a := range query.Attributes {
+					So(a.Value.AsString(), ShouldNotContainSubstring, "secret")
+				}
+			})
+		})
+
+		Convey("Given a query that fails", func() {
+			conn.Table("missing").Find(&[]widget{})
+
+			Convey("Then its span records the error", func() {
+				So(spans.GetSpans()[0].Status.Code, ShouldEqual, codes.Error)
+			})
+		})
+	})
+}
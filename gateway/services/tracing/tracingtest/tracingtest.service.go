@@ -0,0 +1,21 @@
+// Package tracingtest records the spans of the gateway for tests. Only tests
+// import it, so that the gateway itself does not link the test exporter.
+package tracingtest
+
+import (
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/propagation"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+)
+
+// InMemory records every span from now on in the returned exporter, and
+// propagates traces in the W3C format like tracing.Init does. Spans are
+// exported as soon as they end.
+func InMemory() *tracetest.InMemoryExporter {
+	exporter := tracetest.NewInMemoryExporter()
+	otel.SetTextMapPropagator(propagation.NewCompositeTextMapPropagator(propagation.TraceContext{}, propagation.Baggage{}))
+	otel.SetTracerProvider(sdktrace.NewTracerProvider(sdktrace.WithSyncer(exporter)))
+
+	return exporter
+}
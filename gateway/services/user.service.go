@@ -1,69 +1,189 @@
 package services
 
 import (
+	"context"
 	"errors"
 	"gateway/models"
 	"gateway/services/db"
+	"gateway/services/logging"
 	"gateway/utils"
-	"log"
+	"time"
+
+	"go.uber.org/zap"
+	"gorm.io/gorm"
+)
+
+var (
+	ErrUserNotFound  = errors.New("No user found")
+	ErrUsernameTaken = errors.New("Username already exists")
+	ErrEmailTaken    = errors.New("Email already exists")
+	ErrWrongPassword = errors.New("Current password is incorrect")
 )
 
-func GetUserByUsername(username string) (*models.User, error) {
+func logger(ctx context.Context) *zap.Logger {
+	return logging.Ctx(ctx, "services")
+}
+
+// logDBError logs a failed query, at debug level when it only found no rows,
+// which callers report to clients.
+func logDBError(ctx context.Context, err error) {
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		logger(ctx).Debug("Record not found", zap.Error(err))
+		return
+	}
+	logger(ctx).Error("Database error", zap.Error(err))
+}
+
+func GetUserByUsername(ctx context.Context, username string) (*models.User, error) {
 	user := new(models.User)
-	result := db.Conn.Where("username = ?", username).First(user)
+	result := db.Conn.WithContext(ctx).Preload("Roles.Permissions").Preload("LoginAttempt").Where("username = ?", username).First(user)
 
 	if result.Error != nil {
-		log.Println(result.Error.Error())
-		return nil, errors.New("No user found")
+		logDBError(ctx, result.Error)
+		return nil, userReadError(result.Error)
 	}
 
 	return user, nil
 }
 
-func CreateUser(dto models.CreateUserDto) (*models.User, error) {
-	user := models.User{Username: dto.Username, Password: utils.HashPassword(dto.Password), Email: dto.Email}
+func GetUserByID(ctx context.Context, id uint) (*models.User, error) {
+	user := new(models.User)
+	result := db.Conn.WithContext(ctx).Preload("Roles.Permissions").Preload("LoginAttempt").First(user, id)
 
-	result := db.Conn.Create(&user)
 	if result.Error != nil {
-		err := result.Error.Error()
-		log.Println(err)
-		switch err {
-		case "UNIQUE constraint failed: users.username":
-			return nil, errors.New("Username already exists")
-		case "UNIQUE constraint failed: users.email":
-			return nil, errors.New("Email already exists")
-		default:
-			return nil, errors.New("Error writing to database")
-		}
+		logDBError(ctx, result.Error)
+		return nil, userReadError(result.Error)
 	}
 
+	return user, nil
+}
+
+// CreateUser hashes the password of the new user with bcrypt at cost.
+func CreateUser(ctx context.Context, dto models.CreateUserDto, cost int) (*models.User, error) {
+	hash, err := utils.HashPassword(dto.Password, cost)
+	if err != nil {
+		logger(ctx).Error("Password hashing failed", zap.Error(err))
+		return nil, errors.New("Error hashing password")
+	}
+	user := models.User{Username: dto.Username, Password: hash, Email: dto.Email}
+
+	result := db.Conn.WithContext(ctx).Create(&user)
+	if result.Error != nil {
+		logDBError(ctx, result.Error)
+		return nil, userWriteError(result.Error, "Error writing to database")
+	}
+	Audit(ctx, models.AuditEvent{
+		Action: models.AuditUserCreate, Success: true,
+		TargetType: models.AuditTargetUser, TargetID: &user.ID, TargetName: user.Username,
+		Changes: userChanges(models.User{}, user),
+	})
+
 	return &user, nil
 }
 
-func FindUsers(username string) ([]models.User, error) {
+func FindUsers(ctx context.Context, username string) ([]models.User, error) {
 	var users []models.User
-	result := db.Conn.Where("username LIKE ?", "%"+username+"%").Find(&users)
+	result := db.Conn.WithContext(ctx).Preload("LoginAttempt").Where("username LIKE ?", "%"+username+"%").Find(&users)
 
 	if result.Error != nil {
-		log.Println(result.Error.Error())
+		logDBError(ctx, result.Error)
 		return nil, errors.New("Error when reading database")
 	}
 
 	return users, nil
 }
 
-func UpdateUser(id uint, dto models.UpdateUserDto) (*models.User, error) {
-	var user = new(models.User)
-	upData := models.User{Email: dto.Email, IsActive: dto.IsActive}
+// UpdateUser hashes a new password with bcrypt at cost. It fails with
+// ErrLastAdmin rather than deactivate the last active administrator.
+func UpdateUser(ctx context.Context, id uint, dto models.UpdateUserDto, cost int) (*models.User, error) {
+	user, err := GetUserByID(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+
+	// A map, unlike a struct, also writes zero values such as isActive false.
+	updates := map[string]interface{}{}
+	if dto.Email != "" {
+		updates["email"] = dto.Email
+	}
+	if dto.IsActive != nil {
+		updates["is_active"] = *dto.IsActive
+	}
 	if dto.Password != "" {
-		upData.Password = utils.HashPassword(dto.Password)
+		hash, err := utils.HashPassword(dto.Password, cost)
+		if err != nil {
+			logger(ctx).Error("Password hashing failed", zap.Error(err))
+			return nil, errors.New("Error hashing password")
+		}
+		updates["password"] = hash
+		updates["password_changed_at"] = time.Now()
+	}
+	if len(updates) == 0 {
+		return user, nil
+	}
+	before := *user
+	err = db.Conn.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		if dto.IsActive != nil && !*dto.IsActive && user.IsActive && user.HasRole(models.AdminRoleCode) {
+			if err := keepAnAdmin(tx, id); err != nil {
+				return err
+			}
+		}
+		return tx.Model(user).Updates(updates).Error
+	})
+	if errors.Is(err, ErrLastAdmin) {
+		return nil, err
+	}
+	if err != nil {
+		logDBError(ctx, err)
+		return nil, userWriteError(err, "Error when writing database")
 	}
-	result := db.Conn.Model(&user).Updates(upData)
+	evictPrincipal(id)
+	Audit(ctx, models.AuditEvent{
+		Action: models.AuditUserUpdate, Success: true,
+		TargetType: models.AuditTargetUser, TargetID: &user.ID, TargetName: user.Username,
+		Changes: userChanges(before, *user),
+	})
 
-	if result.Error != nil {
-		log.Println(result.Error.Error())
-		return nil, errors.New("Error when writing database")
+	if dto.Password != "" {
+		if err := RevokeRefreshTokensForUser(ctx, id); err != nil {
+			return nil, err
+		}
 	}
 
 	return user, nil
 }
+
+// VerifyPassword fails with ErrWrongPassword unless password is the one of
+// the user.
+func VerifyPassword(ctx context.Context, id uint, password string) error {
+	user, err := GetUserByID(ctx, id)
+	if err != nil {
+		return err
+	}
+	if !utils.CheckPassword(user.Password, password) {
+		return ErrWrongPassword
+	}
+
+	return nil
+}
+
+// userReadError tells a missing user from a failed query.
+func userReadError(err error) error {
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return ErrUserNotFound
+	}
+
+	return errors.New("Error when reading database")
+}
+
+// userWriteError maps unique violations on users to messages fit for clients.
+func userWriteError(err error, fallback string) error {
+	switch {
+	case db.IsUniqueViolation(err, "username"):
+		return ErrUsernameTaken
+	case db.IsUniqueViolation(err, "email"):
+		return ErrEmailTaken
+	default:
+		return errors.New(fallback)
+	}
+}
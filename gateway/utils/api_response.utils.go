@@ -7,9 +7,10 @@ import (
 )
 
 type DefaultResponseBody struct {
-	Status  int         `json:"statusCode"`
-	Message string      `json:"message"`
-	Data    interface{} `json:"data"`
+	Status    int         `json:"statusCode"`
+	Message   string      `json:"message"`
+	Data      interface{} `json:"data"`
+	RequestID string      `json:"requestId,omitempty"`
 }
 
 type fiberJSONStatusSender interface {
@@ -27,6 +28,9 @@ func JSONStatus(c fiberJSONStatusSender, status int, msg string, data interface{
 		Message: msg,
 		Data:    data,
 	}
+	if ctx, ok := c.(*fiber.Ctx); ok {
+		body.RequestID = RequestID(ctx)
+	}
 
 	c.Status(status)
 	return c.JSON(body)
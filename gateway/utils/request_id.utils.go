@@ -0,0 +1,13 @@
+package utils
+
+import "github.com/gofiber/fiber/v2"
+
+// RequestIDKey is the c.Locals key holding the ID of the request.
+const RequestIDKey = "requestId"
+
+// RequestID is the ID the request was given by the RequestID middleware, or
+// "" without it.
+func RequestID(c *fiber.Ctx) string {
+	id, _ := c.Locals(RequestIDKey).(string)
+	return id
+}
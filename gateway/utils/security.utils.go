@@ -4,8 +4,15 @@ import (
 	"golang.org/x/crypto/bcrypt"
 )
 
-func HashPassword(p string) string {
-	hashBytes, _ := bcrypt.GenerateFromPassword([]byte(p), 10)
+func HashPassword(p string, cost int) (string, error) {
+	hashBytes, err := bcrypt.GenerateFromPassword([]byte(p), cost)
+	if err != nil {
+		return "", err
+	}
 	hash := string(hashBytes)
-	return hash
+	return hash, nil
+}
+
+func CheckPassword(hash, p string) bool {
+	return bcrypt.CompareHashAndPassword([]byte(hash), []byte(p)) == nil
 }